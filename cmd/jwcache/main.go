@@ -0,0 +1,388 @@
+// jwcache 缓存节点，同时提供节点之间的接口（/_jw_cache/）和面向客户端的接口（/v1/）
+//
+// 配置从 -conf 指定的文件（默认为 conf/conf.ini）中读取，支持 ini、YAML 和 JSON，
+// JWCACHE_* 环境变量会覆盖配置文件，命令行参数又会覆盖环境变量：
+//
+//	jwcache -addr http://localhost:8001 -peers http://localhost:8001,http://localhost:8002 -api :9999 -groups scores:2048:lru
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"jw-cache/src/api"
+	"jw-cache/src/cache"
+	"jw-cache/src/https"
+	"jw-cache/src/metrics"
+	"jw-cache/src/pgk/log"
+	"jw-cache/src/pgk/setting"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownTimeout = 10 * time.Second // 表示关闭服务时等待请求结束的最长时间
+
+// parseGroups 解析命令行中的分组配置，格式为 name:bytes[:evicter]，多个分组用逗号分隔
+func parseGroups(s string) ([]setting.GroupConfig, error) {
+	var groups []setting.GroupConfig
+	for _, g := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(g), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("bad group %q, want name:bytes[:evicter]", g)
+		}
+		cacheBytes, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad cache bytes of group %s: %v", parts[0], err)
+		}
+		conf := setting.DefaultGroup(parts[0])
+		conf.CacheBytes = cacheBytes
+		if len(parts) == 3 {
+			conf.Evicter = parts[2]
+		}
+		groups = append(groups, conf)
+	}
+	return groups, nil
+}
+
+// flags 命令行参数，重新加载配置时同样会覆盖配置文件
+type flags struct {
+	path    string          // path 配置文件的路径
+	addr    string          // addr 当前节点的地址
+	peers   string          // peers 所有节点的地址，用逗号分隔
+	apiAddr string          // apiAddr 客户端接口的监听地址
+	groups  string          // groups 分组配置，格式为 name:bytes[:evicter]
+	set     map[string]bool // set 命令行中设置过的参数，没有设置的参数不会覆盖配置文件
+}
+
+// loadConf 读取配置文件和环境变量，再使用命令行中设置过的参数覆盖，最后校验配置
+func (f *flags) loadConf() (*setting.Config, error) {
+	conf, err := setting.Load(f.path, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	if f.set["addr"] {
+		conf.Server.Addr = f.addr
+	}
+	if f.set["peers"] {
+		conf.Server.Peers = nil
+		for _, peer := range strings.Split(f.peers, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				conf.Server.Peers = append(conf.Server.Peers, peer)
+			}
+		}
+	}
+	if f.set["api"] {
+		conf.Server.APIAddr = f.apiAddr
+	}
+	if f.groups != "" {
+		if conf.Groups, err = parseGroups(f.groups); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// logOptions 将日志配置转换为 log.Options，日志同时输出到标准输出
+func logOptions(c setting.LogConfig) log.Options {
+	return log.Options{
+		Level:      c.Level,
+		Dir:        c.Dir,
+		FileFormat: c.FileFormat,
+		MaxSize:    c.MaxSize,
+		MaxBackups: c.MaxBackups,
+		Compress:   c.Compress,
+		Output:     os.Stdout,
+	}
+}
+
+// groupOptions 将分组配置转换为 cache.GroupOptions
+func groupOptions(c setting.GroupConfig) *cache.GroupOptions {
+	return &cache.GroupOptions{
+		Evicter:           c.Evicter,
+		Compression:       c.Compression,
+		CompressThreshold: c.CompressThreshold,
+		Shards:            c.Shards,
+		TTL:               c.TTL,
+	}
+}
+
+// poolOptions 将节点之间请求的配置转换为 https.HTTPPoolOptions，配置了证书时还会返回节点服务端的TLS配置
+func poolOptions(c setting.TransportConfig) (*https.HTTPPoolOptions, *tls.Config, error) {
+	opts := &https.HTTPPoolOptions{
+		BasePath:        c.BasePath,
+		Timeout:         c.Timeout,
+		Retries:         c.Retries,
+		RetryBackoff:    c.RetryBackoff,
+		MaxRetryBackoff: c.MaxRetryBackoff,
+		HedgeDelay:      c.HedgeDelay,
+		SharedSecret:    []byte(c.SharedSecret),
+		ReplayWindow:    c.ReplayWindow,
+		HandoffRate:     c.HandoffRate,
+		HandoffTimeout:  c.HandoffTimeout,
+	}
+	if c.CertFile == "" {
+		return opts, nil, nil
+	}
+	serverTLS, err := https.NewServerTLSConfig(c.CertFile, c.KeyFile, c.CAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.TLSConfig, err = https.NewClientTLSConfig(c.CertFile, c.KeyFile, c.CAFile); err != nil {
+		return nil, nil, err
+	}
+	return opts, serverTLS, nil
+}
+
+// newGroup 根据配置创建分组，配置了 l2_dir 时打开分组的二级缓存，并注册哈希环上的节点
+func newGroup(c setting.GroupConfig, pool *https.ConnectHTTPPool) (*cache.Group, error) {
+	opts := groupOptions(c)
+	if c.L2Dir != "" {
+		l2, err := cache.OpenDiskTier(c.L2Dir, &cache.DiskTierOptions{MaxBytes: c.L2Bytes})
+		if err != nil {
+			return nil, fmt.Errorf("open l2 of group %s: %w", c.Name, err)
+		}
+		opts.L2 = l2
+	}
+	g, err := cache.NewGroupE(c.Name, c.CacheBytes, notFound, opts)
+	if err != nil {
+		if opts.L2 != nil {
+			opts.L2.Close()
+		}
+		return nil, err
+	}
+	return g, g.RegisterNodes(pool)
+}
+
+// closeL2 关闭所有分组的二级缓存，二级缓存不会在重启后恢复，关闭时删除段文件
+func closeL2() {
+	for _, name := range cache.GroupNames() {
+		g := cache.GetGroup(name)
+		if g == nil || g.L2() == nil {
+			continue
+		}
+		if err := g.L2().Close(); err != nil {
+			log.Error("close l2 failed", "group", name, "dir", g.L2().Dir(), "err", err)
+		}
+	}
+}
+
+// 没有配置数据源时，节点只保存通过客户端接口写入的数据
+var notFound = cache.GetterFunc(func(key string) ([]byte, error) {
+	return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+})
+
+// fatal 打印错误并退出，用于日志配置完成之前的错误
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "jwcache:", err)
+	os.Exit(1)
+}
+
+func main() {
+	f := &flags{set: map[string]bool{}}
+	flag.StringVar(&f.path, "conf", "conf/conf.ini", "配置文件的路径，支持 .ini、.yaml 和 .json，也可以通过 JWCACHE_CONF 环境变量设置")
+	flag.StringVar(&f.addr, "addr", "", "当前节点的地址，例如 http://localhost:8001")
+	flag.StringVar(&f.peers, "peers", "", "所有节点的地址，用逗号分隔")
+	flag.StringVar(&f.apiAddr, "api", "", "客户端接口的监听地址，为空时不开启")
+	flag.StringVar(&f.groups, "groups", "", "分组配置，格式为 name:bytes[:evicter]，多个分组用逗号分隔，设置后忽略配置文件中的分组")
+	flag.Parse()
+
+	flag.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
+	if env := os.Getenv(setting.EnvConfigPath); env != "" && !f.set["conf"] {
+		f.path = env
+	}
+	conf, err := f.loadConf()
+	if err != nil {
+		fatal(fmt.Errorf("fail to load conf:\n%v", err))
+	}
+	logger, err := log.New(logOptions(conf.Log))
+	if err != nil {
+		fatal(err)
+	}
+	defer logger.Close()
+	log.SetDefault(logger)
+	if err := run(conf, f); err != nil {
+		log.Error("jwcache exited", "err", err)
+		logger.Close()
+		os.Exit(1)
+	}
+}
+
+// run 启动节点，收到 SIGINT 或 SIGTERM 后等待请求结束再退出
+func run(conf *setting.Config, f *flags) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	opts, serverTLS, err := poolOptions(conf.Transport)
+	if err != nil {
+		return err
+	}
+	pool := https.NewHTTPPoolOpts(conf.Server.Addr, opts)
+	groups := make([]*cache.Group, 0, len(conf.Groups))
+	for _, c := range conf.Groups {
+		g, err := newGroup(c, pool)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, g)
+	}
+	// 在加入哈希环之前恢复快照或者追加日志，其他节点开始访问当前节点时本地缓存已经是热的
+	var (
+		snapshotter *cache.Snapshotter
+		aof         *cache.AOF
+		saver       api.Snapshotter // saver 快照接口使用的 Snapshotter，不能保存值为空的指针
+	)
+	switch {
+	case conf.Snapshot.Path != "":
+		snapshotter = cache.NewSnapshotter(conf.Snapshot.Path)
+		restore(snapshotter)
+		if conf.Snapshot.Interval > 0 {
+			go snapshotter.Run(ctx, conf.Snapshot.Interval)
+		}
+		saver = snapshotter
+	case conf.AOF.Dir != "":
+		if aof, err = openAOF(conf.AOF, groups); err != nil {
+			return err
+		}
+		saver = aof
+	}
+	if conf.Transport.SharedSecret == "" && conf.Transport.CertFile == "" {
+		log.Warn("peer requests are not authenticated: anyone who can reach the node can write and delete values, set transport.shared_secret or transport.cert_file")
+	}
+	if conf.Server.Handoff && !handoffEnabled(conf) {
+		log.Warn("handoff disabled: requires transport.shared_secret or transport.cert_file")
+	}
+	if len(conf.Server.Peers) > 0 {
+		setPeers(pool, handoffEnabled(conf), conf.Server.Peers)
+	}
+	pool.StartHealthCheck(conf.Server.HealthInterval)
+	defer pool.StopHealthCheck()
+	r := &reloader{flags: f, conf: conf, pool: pool, aof: aof}
+	go r.run(ctx)
+
+	u, _ := url.Parse(conf.Server.Addr)
+	peerMux := http.NewServeMux()
+	pool.Mount(peerMux)
+	peerMux.Handle("/metrics", metrics.Handler(metrics.Groups, pool))
+	servers := []*http.Server{{Addr: u.Host, Handler: peerMux, TLSConfig: serverTLS}}
+	if conf.Server.APIAddr != "" {
+		mux := http.NewServeMux()
+		api.NewServerOpts(&api.ServerOptions{Snapshotter: saver}).Mount(mux)
+		servers = append(servers, &http.Server{Addr: conf.Server.APIAddr, Handler: mux})
+	}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.Info("listening", "addr", server.Addr, "tls", server.TLSConfig != nil)
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(server)
+	}
+
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down")
+		if r.handoff() {
+			// 在关闭服务之前离开哈希环，新的节点需要从当前节点拉取数据
+			if stats, e := pool.Leave(context.Background()); e != nil || stats.Ranges > 0 {
+				log.Info("left the ring", "ranges", stats.Ranges, "entries", stats.Entries, "bytes", stats.Bytes, "failed", stats.Failed, "err", e)
+			}
+		}
+	case err = <-errs:
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if e := server.Shutdown(shutdownCtx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if snapshotter != nil && conf.Snapshot.OnShutdown {
+		// 所有请求都已经结束，快照中包含关闭前写入的数据
+		if stats, e := snapshotter.Save(); e != nil {
+			log.Error("save snapshot failed", "path", snapshotter.Path(), "err", e)
+		} else {
+			log.Info("snapshot saved", "path", snapshotter.Path(), "groups", stats.Groups, "entries", stats.Entries, "bytes", stats.Bytes)
+		}
+	}
+	if aof != nil {
+		if e := aof.Close(); e != nil {
+			log.Error("close aof failed", "dir", aof.Dir(), "err", e)
+		}
+	}
+	closeL2()
+	return err
+}
+
+// handoffEnabled 返回是否与其他节点交接数据，节点只接受签名校验通过或者使用双向TLS的交接请求，两者都没有配置时不交接
+func handoffEnabled(conf *setting.Config) bool {
+	return conf.Server.Handoff && (conf.Transport.SharedSecret != "" || conf.Transport.CertFile != "")
+}
+
+// setPeers 设置哈希环上的节点，handoff 为 true 时先与其他节点交接数据再切换哈希环
+func setPeers(pool *https.ConnectHTTPPool, handoff bool, peers []string) {
+	if !handoff {
+		pool.Set(peers...)
+		return
+	}
+	start := time.Now()
+	stats, err := pool.Handoff(context.Background(), peers...)
+	if err != nil {
+		log.Warn("handoff failed, switch the ring anyway", "ranges", stats.Ranges, "entries", stats.Entries, "failed", stats.Failed, "err", err)
+		return
+	}
+	if stats.Ranges > 0 {
+		log.Info("ring switched after handoff", "ranges", stats.Ranges, "entries", stats.Entries, "bytes", stats.Bytes, "cost", time.Since(start))
+	}
+}
+
+// openAOF 打开追加日志并恢复本地缓存，之后分组的修改都会追加到日志中。日志末尾不完整的记录会被截断，
+// 基础快照损坏等无法恢复的错误会阻止节点启动，避免之后的压缩覆盖旧的数据
+func openAOF(c setting.AOFConfig, groups []*cache.Group) (*cache.AOF, error) {
+	aof, err := cache.OpenAOF(c.Dir, &cache.AOFOptions{Fsync: c.Fsync, CompactSize: c.CompactSize})
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	stats, err := aof.Replay()
+	if err != nil {
+		return nil, fmt.Errorf("replay aof in %s: %w", c.Dir, err)
+	}
+	log.Info("aof replayed", "dir", c.Dir, "entries", stats.Snapshot.Entries, "records", stats.Records,
+		"skipped", stats.Snapshot.Skipped+stats.Skipped, "truncated", stats.Truncated, "fsync", c.Fsync, "cost", time.Since(start))
+	aof.Attach(groups...)
+	return aof, nil
+}
+
+// restore 从快照恢复本地缓存，快照不存在或者损坏时以空的缓存启动
+func restore(s *cache.Snapshotter) {
+	start := time.Now()
+	stats, err := s.Restore()
+	switch {
+	case os.IsNotExist(err):
+		log.Info("no snapshot to restore", "path", s.Path())
+	case err != nil:
+		log.Error("restore snapshot failed, start with empty cache", "path", s.Path(), "err", err)
+	default:
+		log.Info("snapshot restored", "path", s.Path(), "groups", stats.Groups, "entries", stats.Entries,
+			"skipped", stats.Skipped, "created", stats.Created.Format(time.RFC3339), "cost", time.Since(start))
+	}
+}
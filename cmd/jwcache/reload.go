@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"jw-cache/src/cache"
+	"jw-cache/src/https"
+	"jw-cache/src/pgk/log"
+	"jw-cache/src/pgk/setting"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// reloader 在配置文件变化或者收到 SIGHUP 后重新加载配置，并将差异应用到运行中的节点：
+// 更新哈希环上的节点、创建新的分组、修改分组的缓存容量以及日志级别，其他配置的变化只会记录日志，需要重启节点才能生效
+type reloader struct {
+	mu    sync.Mutex             // mu 保证同一时间只有一次重新加载
+	flags *flags                 // flags 命令行参数，重新加载时同样会覆盖配置文件
+	conf  *setting.Config        // conf 当前生效的配置
+	pool  *https.ConnectHTTPPool // pool 节点的连接池
+	aof   *cache.AOF             // aof 追加日志，新的分组同样需要记录修改，为空时没有开启
+}
+
+// run 监听配置文件和 SIGHUP，ctx 结束后返回
+func (r *reloader) run(ctx context.Context) {
+	if interval := r.conf.Server.WatchInterval; interval > 0 {
+		go setting.Watch(ctx, r.flags.path, interval, func() { r.reload("watch") })
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("sighup")
+		}
+	}
+}
+
+// reload 重新加载配置，配置无法解析或者校验失败时保留当前的配置
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conf, err := r.flags.loadConf()
+	if err != nil {
+		log.Error("reload conf failed, keep the current conf", "trigger", trigger, "err", err)
+		return
+	}
+	changes := setting.Diff(r.conf, conf)
+	if changes.Empty() {
+		// 没有需要应用的变化，但 server.handoff 这类在使用时读取的配置可能已经改变
+		r.conf = conf
+		log.Info("conf reloaded without changes", "trigger", trigger)
+		return
+	}
+	r.apply(conf, changes)
+	r.conf = conf
+	log.Info("conf reloaded", "trigger", trigger)
+}
+
+// handoff 返回当前的配置是否开启了数据交接
+func (r *reloader) handoff() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return handoffEnabled(r.conf)
+}
+
+// apply 将配置的差异应用到运行中的节点，某一项失败时继续应用其他的变化
+func (r *reloader) apply(conf *setting.Config, changes setting.Changes) {
+	if changes.PeersChanged() {
+		setPeers(r.pool, handoffEnabled(conf), conf.Server.Peers)
+		log.Info("peers changed", "added", changes.AddedPeers, "removed", changes.RemovedPeers, "peers", conf.Server.Peers)
+	}
+	for _, g := range changes.AddedGroups {
+		if group := cache.GetGroup(g.Name); group != nil {
+			// 从配置中删除后又重新加入的分组仍然在运行，只需要修改容量
+			changes.ResizedGroups = append(changes.ResizedGroups, g)
+			continue
+		}
+		group, err := newGroup(g, r.pool)
+		if err != nil {
+			log.Error("add group failed", "group", g.Name, "err", err)
+			continue
+		}
+		if r.aof != nil {
+			r.aof.Attach(group)
+		}
+		log.Info("group added", "group", g.Name, "cache_bytes", g.CacheBytes, "evicter", g.Evicter)
+	}
+	for _, g := range changes.ResizedGroups {
+		group := cache.GetGroup(g.Name)
+		if group == nil {
+			continue
+		}
+		old := group.CacheBytes()
+		if err := group.SetCacheBytes(g.CacheBytes); err != nil {
+			log.Error("resize group failed", "group", g.Name, "cache_bytes", g.CacheBytes, "err", err)
+			continue
+		}
+		log.Info("group resized", "group", g.Name, "old", old, "new", g.CacheBytes)
+	}
+	if changes.LogLevel != "" {
+		if err := log.SetLevel(changes.LogLevel); err != nil {
+			log.Error("change log level failed", "level", changes.LogLevel, "err", err)
+		} else {
+			log.Info("log level changed", "level", changes.LogLevel)
+		}
+	}
+	if len(changes.Restart) > 0 {
+		log.Warn("conf changed but requires a restart to apply", "keys", changes.Restart)
+	}
+}
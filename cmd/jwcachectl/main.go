@@ -0,0 +1,398 @@
+// jwcachectl 缓存节点的运维工具
+//
+//	jwcachectl [flags] get <group> <key>          获取值，-peer 时通过节点之间的协议获取并解码 pb.Response，-secret 用于签名
+//	jwcachectl [flags] set <group> <key> <value>  写入值，-ttl 设置过期时间
+//	jwcachectl [flags] del <group> <key>          删除值
+//	jwcachectl [flags] stats                      查看每个节点的统计信息
+//	jwcachectl [flags] keys <group>               列出每个节点中该组的所有键
+//	jwcachectl [flags] ring <key>...              查看 key 在哈希环上属于哪个节点
+//	jwcachectl [flags] loglevel [level]           查看或修改每个节点的日志级别
+//	jwcachectl [flags] snapshot                   让每个节点保存一次快照
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"jw-cache/src/api"
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/hashes"
+	"jw-cache/src/https"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	apiAddrs = flag.String("api", "http://localhost:9999", "客户端接口的地址，多个节点用逗号分隔，get/set/del 使用第一个节点")
+	peers    = flag.String("peers", "http://localhost:8001", "所有节点的地址，用逗号分隔，用于 ring 和 -peer")
+	basePath = flag.String("base-path", "/_jw_cache/", "节点之间请求的路径前缀")
+	replicas = flag.Int("replicas", 50, "每个节点的虚拟节点数，需要与节点保持一致")
+	output   = flag.String("o", "table", "输出格式：table 或 json")
+	viaPeer  = flag.Bool("peer", false, "get 时通过节点之间的协议从 key 所属的节点获取")
+	ttl      = flag.Duration("ttl", 0, "set 时值的过期时间，为 0 时表示永不过期")
+	timeout  = flag.Duration("timeout", 5*time.Second, "请求的超时时间")
+	secret   = flag.String("secret", "", "节点之间共享的签名密钥，与节点的 transport.shared_secret 相同，-peer 时用于签名请求，为空时读取 JWCACHE_TRANSPORT_SHARED_SECRET")
+)
+
+var client *http.Client
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jwcachectl [flags] get|set|del|stats|keys|ring|loglevel|snapshot args...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *secret == "" {
+		// 不作为默认值，避免密钥出现在帮助信息中
+		*secret = os.Getenv("JWCACHE_TRANSPORT_SHARED_SECRET")
+	}
+	client = &http.Client{Timeout: *timeout}
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(args[0], args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "jwcachectl:", err)
+		os.Exit(1)
+	}
+}
+
+// run 执行子命令
+func run(cmd string, args []string) error {
+	want := map[string]int{"get": 2, "set": 3, "del": 2, "stats": 0, "keys": 1, "snapshot": 0}
+	if n, ok := want[cmd]; ok && len(args) != n {
+		return fmt.Errorf("%s needs %d arguments, but %d got", cmd, n, len(args))
+	}
+	switch cmd {
+	case "get":
+		return get(args[0], args[1])
+	case "set":
+		return set(args[0], args[1], args[2])
+	case "del":
+		_, err := request(http.MethodDelete, keyURL(first(*apiAddrs), args[0], args[1]), "", nil)
+		return err
+	case "stats":
+		return stats()
+	case "keys":
+		return keys(args[0])
+	case "ring":
+		if len(args) == 0 {
+			return errors.New("ring needs at least one key")
+		}
+		return ring(args)
+	case "loglevel":
+		if len(args) > 1 {
+			return errors.New("loglevel needs at most one argument")
+		}
+		return logLevel(args)
+	case "snapshot":
+		return snapshot()
+	}
+	return fmt.Errorf("unknown command: %s", cmd)
+}
+
+// peerNames 将逗号分隔的节点拆分，与 jwcache 的 -peers 相同只去掉空白，节点名称需要与节点上的哈希环完全一致
+func peerNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// peerBasePath 与节点相同，路径前缀以 "/" 开头和结尾
+func peerBasePath() string {
+	p := *basePath
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+// split 将逗号分隔的地址拆分
+func split(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimRight(strings.TrimSpace(addr), "/"); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func first(s string) string {
+	if addrs := split(s); len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+func keyURL(addr, group, key string) string {
+	return fmt.Sprintf("%s/v1/groups/%s/keys/%s", addr, url.PathEscape(group), url.PathEscape(key))
+}
+
+// request 发送请求并返回响应体，响应码不是 2xx 时解析JSON格式的错误
+func request(method, u, body string, header map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return do(req)
+}
+
+// peerRequest 向节点之间的接口发送请求，设置了 -secret 时与节点之间的请求一样签名
+func peerRequest(method, u string) ([]byte, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if *secret != "" {
+		if err := https.SignRequest(req, nil, []byte(*secret)); err != nil {
+			return nil, err
+		}
+	}
+	return do(req)
+}
+
+// do 发送请求并返回响应体，响应码不是 2xx 时解析JSON格式的错误
+func do(req *http.Request) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s: %s", res.Status, e.Error)
+		}
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// newRing 根据节点地址创建与节点相同的哈希环
+func newRing() (*hashes.Map, []string) {
+	nodes := peerNames(*peers)
+	ring := hashes.New(*replicas, nil)
+	ring.Add(nodes...)
+	return ring, nodes
+}
+
+// render 按照输出格式打印，rows 的第一行为表头
+func render(v interface{}, rows [][]string) error {
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func get(group, key string) error {
+	var value []byte
+	source := first(*apiAddrs)
+	if *viaPeer {
+		ring, _ := newRing()
+		source = ring.Get(key)
+		if source == "" {
+			return errors.New("no peer configured")
+		}
+		// 与节点之间的请求使用相同的地址，节点名称原样拼接
+		u := source + peerBasePath() + url.PathEscape(group) + "/" + url.PathEscape(key)
+		body, err := peerRequest(http.MethodGet, u)
+		if err != nil {
+			return err
+		}
+		res := &pb.Response{}
+		if err := proto.Unmarshal(body, res); err != nil {
+			return fmt.Errorf("decoding response body: %v", err)
+		}
+		// 开启了压缩的分组返回压缩后的数据，需要按照 encoding 解压
+		if value, err = cache.Decode(cache.Encoding(res.Encoding), res.Value); err != nil {
+			return err
+		}
+	} else {
+		body, err := request(http.MethodGet, keyURL(source, group, key), "", nil)
+		if err != nil {
+			return err
+		}
+		value = body
+	}
+	if *output == "json" {
+		return render(map[string]string{"group": group, "key": key, "node": source, "value": string(value)}, nil)
+	}
+	_, err := os.Stdout.Write(append(value, '\n'))
+	return err
+}
+
+func set(group, key, value string) error {
+	header := map[string]string{}
+	if *ttl > 0 {
+		header["X-JWCache-TTL"] = strconv.FormatInt(int64((*ttl+time.Second-1)/time.Second), 10)
+	}
+	_, err := request(http.MethodPut, keyURL(first(*apiAddrs), group, key), value, header)
+	return err
+}
+
+// nodeStats 一个节点的统计信息
+type nodeStats struct {
+	Node   string           `json:"node"`
+	Error  string           `json:"error,omitempty"`
+	Groups []api.GroupStats `json:"groups"`
+}
+
+func stats() error {
+	var all []nodeStats
+	rows := [][]string{{"NODE", "GROUP", "ITEMS", "BYTES", "MAX_BYTES", "GETS", "HITS", "LOADS", "PEER_LOADS", "EVICTED"}}
+	for _, addr := range split(*apiAddrs) {
+		st := nodeStats{Node: addr}
+		body, err := request(http.MethodGet, addr+"/v1/stats", "", nil)
+		if err == nil {
+			err = json.Unmarshal(body, &st)
+		}
+		if err != nil {
+			st.Error = err.Error()
+			rows = append(rows, []string{addr, "ERROR: " + st.Error})
+		}
+		for _, g := range st.Groups {
+			rows = append(rows, []string{addr, g.Name, strconv.Itoa(g.Items),
+				strconv.FormatInt(g.Bytes, 10), strconv.FormatInt(g.MaxBytes, 10),
+				strconv.FormatInt(g.Gets, 10), strconv.FormatInt(g.Hits, 10), strconv.FormatInt(g.Loads, 10),
+				strconv.FormatInt(g.PeerLoads, 10), strconv.FormatInt(g.EvictedCapacity+g.EvictedExpired, 10)})
+		}
+		all = append(all, st)
+	}
+	return render(all, rows)
+}
+
+// nodeLevel 一个节点的日志级别
+type nodeLevel struct {
+	Node  string `json:"node"`
+	Error string `json:"error,omitempty"`
+	Level string `json:"level"`
+}
+
+// logLevel 查看每个节点的日志级别，args 不为空时先修改日志级别
+func logLevel(args []string) error {
+	method, body := http.MethodGet, ""
+	if len(args) == 1 {
+		b, _ := json.Marshal(map[string]string{"level": args[0]})
+		method, body = http.MethodPut, string(b)
+	}
+	var all []nodeLevel
+	rows := [][]string{{"NODE", "LEVEL"}}
+	for _, addr := range split(*apiAddrs) {
+		nl := nodeLevel{Node: addr}
+		res, err := request(method, addr+"/v1/log/level", body, map[string]string{"Content-Type": "application/json"})
+		if err == nil {
+			err = json.Unmarshal(res, &nl)
+		}
+		if err != nil {
+			nl.Error = err.Error()
+			rows = append(rows, []string{addr, "ERROR: " + nl.Error})
+		} else {
+			rows = append(rows, []string{addr, nl.Level})
+		}
+		all = append(all, nl)
+	}
+	return render(all, rows)
+}
+
+// nodeSnapshot 一个节点保存快照的结果
+type nodeSnapshot struct {
+	Node  string `json:"node"`
+	Error string `json:"error,omitempty"`
+	api.Snapshot
+}
+
+// snapshot 让每个节点保存一次快照
+func snapshot() error {
+	var all []nodeSnapshot
+	rows := [][]string{{"NODE", "PATH", "GROUPS", "ENTRIES", "BYTES"}}
+	for _, addr := range split(*apiAddrs) {
+		ns := nodeSnapshot{Node: addr}
+		body, err := request(http.MethodPost, addr+"/v1/snapshot", "", nil)
+		if err == nil {
+			err = json.Unmarshal(body, &ns)
+		}
+		if err != nil {
+			ns.Error = err.Error()
+			rows = append(rows, []string{addr, "ERROR: " + ns.Error})
+		} else {
+			rows = append(rows, []string{addr, ns.Path, strconv.Itoa(ns.Groups), strconv.Itoa(ns.Entries), strconv.FormatInt(ns.Bytes, 10)})
+		}
+		all = append(all, ns)
+	}
+	return render(all, rows)
+}
+
+// nodeKeys 一个节点中某个组的所有键
+type nodeKeys struct {
+	Node  string   `json:"node"`
+	Error string   `json:"error,omitempty"`
+	Keys  []string `json:"keys"`
+}
+
+func keys(group string) error {
+	var all []nodeKeys
+	rows := [][]string{{"NODE", "KEY"}}
+	for _, addr := range split(*apiAddrs) {
+		nk := nodeKeys{Node: addr}
+		body, err := request(http.MethodGet, fmt.Sprintf("%s/v1/groups/%s/keys", addr, url.PathEscape(group)), "", nil)
+		if err == nil {
+			err = json.Unmarshal(body, &nk)
+		}
+		if err != nil {
+			nk.Error = err.Error()
+			rows = append(rows, []string{addr, "ERROR: " + nk.Error})
+		}
+		for _, key := range nk.Keys {
+			rows = append(rows, []string{addr, key})
+		}
+		all = append(all, nk)
+	}
+	return render(all, rows)
+}
+
+func ring(keys []string) error {
+	ring, nodes := newRing()
+	if len(nodes) == 0 {
+		return errors.New("no peer configured")
+	}
+	owners := make(map[string]string, len(keys))
+	rows := [][]string{{"KEY", "NODE"}}
+	for _, key := range keys {
+		owners[key] = ring.Get(key)
+		rows = append(rows, []string{key, owners[key]})
+	}
+	return render(owners, rows)
+}
@@ -2,11 +2,18 @@ module jw-cache
 
 go 1.19
 
-require github.com/golang/protobuf v1.5.3
+require (
+	github.com/go-ini/ini v1.67.0
+	github.com/golang/protobuf v1.5.3
+	github.com/sirupsen/logrus v1.9.3
+	go.opentelemetry.io/otel v1.11.1
+	go.opentelemetry.io/otel/trace v1.11.1
+	google.golang.org/protobuf v1.26.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/go-ini/ini v1.67.0 // indirect
-	github.com/sirupsen/logrus v1.9.3 // indirect
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
-	google.golang.org/protobuf v1.26.0 // indirect
 )
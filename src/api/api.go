@@ -0,0 +1,360 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"jw-cache/src/cache"
+	"jw-cache/src/pgk/log"
+	"jw-cache/src/trace"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPrefix = "/v1/"          // 表示客户端接口的路径前缀
+	ttlHeader     = "X-JWCache-TTL" // 表示值的过期时间，单位为秒
+	maxValueBytes = 32 << 20        // 表示一次写入的值的最大字节数
+)
+
+// Server 面向客户端的HTTP接口，与节点之间的协议（/_jw_cache/）相互独立
+//
+//	GET    /v1/groups                    列出所有的组
+//	GET    /v1/stats                     返回所有组的统计信息
+//	GET    /v1/groups/{group}/keys       列出当前节点中该组的所有键
+//	GET    /v1/groups/{group}/keys/{key} 获取值，支持 If-None-Match
+//	PUT    /v1/groups/{group}/keys/{key} 写入值，过期时间通过 X-JWCache-TTL 请求头传递
+//	DELETE /v1/groups/{group}/keys/{key} 删除值
+//	GET    /v1/log/level                 返回当前的日志级别
+//	PUT    /v1/log/level                 修改日志级别，请求体为 {"level": "debug"}
+//	POST   /v1/snapshot                  将所有组保存到快照文件，开启追加日志时压缩日志
+type Server struct {
+	prefix      string      // prefix 接口的路径前缀
+	snapshotter Snapshotter // snapshotter 保存快照，为空时快照接口返回 501
+}
+
+// Snapshotter 快照接口保存快照的方式，*cache.Snapshotter 保存到快照文件，*cache.AOF 压缩追加日志
+type Snapshotter interface {
+	Path() string                       // Path 返回快照文件的路径
+	Save() (cache.SnapshotStats, error) // Save 保存快照
+}
+
+// ServerOptions 客户端接口的配置项，零值表示使用默认配置
+type ServerOptions struct {
+	Snapshotter Snapshotter // Snapshotter 快照接口使用的 Snapshotter，为空时不支持保存快照
+}
+
+// NewServer 新建客户端接口，使用默认的配置项
+func NewServer() *Server {
+	return NewServerOpts(nil)
+}
+
+// NewServerOpts 根据配置项新建客户端接口，opts 为空时使用默认的配置项
+func NewServerOpts(opts *ServerOptions) *Server {
+	s := &Server{prefix: defaultPrefix}
+	if opts != nil {
+		s.snapshotter = opts.Snapshotter
+	}
+	return s
+}
+
+// Mount 将客户端接口挂载到已有的 mux 上
+func (s *Server) Mount(mux *http.ServeMux) {
+	mux.Handle(s.prefix, s)
+}
+
+// errorBody 错误响应的JSON格式
+type errorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"error"`
+}
+
+// writeJSON 以JSON格式返回响应
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("write response failed", "err", err)
+	}
+}
+
+// writeError 以JSON格式返回错误
+func writeError(w http.ResponseWriter, code int, format string, v ...interface{}) {
+	writeJSON(w, code, errorBody{Code: code, Message: fmt.Sprintf(format, v...)})
+}
+
+// ServeHTTP 根据路径分发请求
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, s.prefix) {
+		writeError(w, http.StatusNotFound, "unexpected path: %s", r.URL.Path)
+		return
+	}
+	path := r.URL.Path[len(s.prefix):]
+	switch path {
+	case "log/level":
+		s.logLevel(w, r)
+		return
+	case "snapshot":
+		s.snapshot(w, r)
+		return
+	}
+	parts := strings.SplitN(path, "/", 4)
+	if len(parts) < 4 {
+		// 只读的接口：groups、stats、groups/{group}/keys
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+			return
+		}
+		switch {
+		case path == "groups":
+			writeJSON(w, http.StatusOK, map[string][]string{"groups": cache.GroupNames()})
+		case path == "stats":
+			s.stats(w)
+		case len(parts) == 3 && parts[0] == "groups" && parts[2] == "keys":
+			if group := cache.GetGroup(parts[1]); group != nil {
+				writeJSON(w, http.StatusOK, map[string][]string{"keys": group.Keys()})
+			} else {
+				writeError(w, http.StatusNotFound, "no such group: %s", parts[1])
+			}
+		default:
+			writeError(w, http.StatusNotFound, "unexpected path: %s", r.URL.Path)
+		}
+		return
+	}
+
+	// groups/{group}/keys/{key}，key 中可以包含 "/"
+	if parts[0] != "groups" || parts[2] != "keys" || parts[3] == "" {
+		writeError(w, http.StatusNotFound, "unexpected path: %s", r.URL.Path)
+		return
+	}
+	group := cache.GetGroup(parts[1])
+	if group == nil {
+		writeError(w, http.StatusNotFound, "no such group: %s", parts[1])
+		return
+	}
+	key := parts[3]
+
+	switch r.Method {
+	case http.MethodGet:
+		s.get(w, r, group, key)
+	case http.MethodPut:
+		s.put(w, r, group, key)
+	case http.MethodDelete:
+		if err := group.Delete(key); err != nil {
+			writeError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+	}
+}
+
+// logLevel 日志级别接口的JSON格式
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// logLevel 查看或修改全局 Logger 的日志级别
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	old, ok := log.GetLevel()
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "default logger does not support changing level")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req logLevel
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad request body: %v", err)
+			return
+		}
+		if err := log.SetLevel(req.Level); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		log.Info("log level changed", "from", old, "to", req.Level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+		return
+	}
+	level, _ := log.GetLevel()
+	writeJSON(w, http.StatusOK, logLevel{Level: level})
+}
+
+// Snapshot 快照接口的JSON格式
+type Snapshot struct {
+	Path    string    `json:"path"`
+	Groups  int       `json:"groups"`
+	Entries int       `json:"entries"`
+	Bytes   int64     `json:"bytes"`
+	Created time.Time `json:"created"`
+}
+
+// snapshot 将所有组保存到快照文件
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+		return
+	}
+	if s.snapshotter == nil {
+		writeError(w, http.StatusNotImplemented, "snapshot is not configured")
+		return
+	}
+	stats, err := s.snapshotter.Save()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "save snapshot: %v", err)
+		return
+	}
+	log.Info("snapshot saved", "path", s.snapshotter.Path(), "groups", stats.Groups, "entries", stats.Entries, "bytes", stats.Bytes)
+	writeJSON(w, http.StatusOK, Snapshot{
+		Path:    s.snapshotter.Path(),
+		Groups:  stats.Groups,
+		Entries: stats.Entries,
+		Bytes:   stats.Bytes,
+		Created: stats.Created,
+	})
+}
+
+// GroupStats 统计信息接口中每个组的JSON格式
+type GroupStats struct {
+	Name            string `json:"name"`
+	Items           int    `json:"items"`
+	Bytes           int64  `json:"bytes"`
+	MaxBytes        int64  `json:"max_bytes"`
+	Gets            int64  `json:"gets"`
+	Hits            int64  `json:"hits"`
+	Loads           int64  `json:"loads"`
+	DedupedLoads    int64  `json:"deduped_loads"`
+	PeerLoads       int64  `json:"peer_loads"`
+	PeerErrors      int64  `json:"peer_errors"`
+	LocalLoads      int64  `json:"local_loads"`
+	LoadErrors      int64  `json:"load_errors"`
+	EvictedCapacity int64  `json:"evicted_capacity"`
+	EvictedExpired  int64  `json:"evicted_expired"`
+	L2Hits          int64  `json:"l2_hits,omitempty"`
+	L2Items         int    `json:"l2_items,omitempty"`
+	L2Bytes         int64  `json:"l2_bytes,omitempty"`
+	L2MaxBytes      int64  `json:"l2_max_bytes,omitempty"`
+}
+
+// stats 返回所有组的统计信息
+func (s *Server) stats(w http.ResponseWriter) {
+	stats := make([]GroupStats, 0)
+	for _, name := range cache.GroupNames() {
+		if group := cache.GetGroup(name); group != nil {
+			st := group.Stats()
+			stats = append(stats, GroupStats{
+				Name:            name,
+				Items:           st.Items,
+				Bytes:           st.Bytes,
+				MaxBytes:        st.MaxBytes,
+				Gets:            st.Gets,
+				Hits:            st.Hits,
+				Loads:           st.Loads,
+				DedupedLoads:    st.DedupedLoads,
+				PeerLoads:       st.PeerLoads,
+				PeerErrors:      st.PeerErrors,
+				LocalLoads:      st.LocalLoads,
+				LoadErrors:      st.LoadErrors,
+				EvictedCapacity: st.EvictedCapacity,
+				EvictedExpired:  st.EvictedExpired,
+				L2Hits:          st.L2Hits,
+				L2Items:         st.L2Items,
+				L2Bytes:         st.L2Bytes,
+				L2MaxBytes:      st.L2MaxBytes,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string][]GroupStats{"groups": stats})
+}
+
+// get 获取值，值没有变化时返回 304
+func (s *Server) get(w http.ResponseWriter, r *http.Request, group *cache.Group, key string) {
+	// 调用方携带了追踪上下文时，读取过程会出现在调用方的调用链中
+	view, err := group.GetContext(trace.Extract(r.Context(), r.Header), key)
+	if errors.Is(err, cache.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	// 压缩的值只解压一次，ETag 和响应体读取同一份数据，未压缩时直接读取底层数组
+	body := view.Reader()
+	tag, size := etag(body)
+	w.Header().Set("ETag", tag)
+	if expire := view.Expire(); !expire.IsZero() {
+		// 剩余时间向上取整，避免返回 0 被当作永不过期
+		ttl := (time.Until(expire) + time.Second - 1) / time.Second
+		w.Header().Set(ttlHeader, strconv.FormatInt(int64(ttl), 10))
+	}
+	if matchETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	io.Copy(w, body)
+}
+
+// put 写入值
+func (s *Server) put(w http.ResponseWriter, r *http.Request, group *cache.Group, key string) {
+	var ttl time.Duration
+	if v := r.Header.Get(ttlHeader); v != "" {
+		seconds, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, "bad %s header: %s", ttlHeader, v)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueBytes))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, "value is larger than %d bytes", maxErr.Limit)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if err := group.Set(key, value, ttl); err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// etag 根据值解压后的内容计算 ETag，同时返回值的大小，读取之后 r 回到开头
+func etag(r io.ReadSeeker) (string, int64) {
+	h := fnv.New64a()
+	n, _ := io.Copy(h, r)
+	r.Seek(0, io.SeekStart)
+	return fmt.Sprintf(`"%016x"`, h.Sum64()), n
+}
+
+// matchETag 判断 If-None-Match 请求头是否与 ETag 匹配
+func matchETag(header, tag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,616 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"jw-cache/src/pgk/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalOp 本地缓存的修改类型
+type JournalOp byte
+
+const (
+	JournalSet    JournalOp = 1 // 写入值
+	JournalDelete JournalOp = 2 // 删除值
+	JournalExpire JournalOp = 3 // 读取时发现值已经过期而删除
+)
+
+// Journal 记录分组本地缓存的修改，在持有分片写锁时、修改缓存之前调用，返回错误时缓存不会被修改，
+// 同一个 key 的记录顺序与修改缓存的顺序相同，只有 JournalSet 时 value 有效
+type Journal interface {
+	Append(op JournalOp, group, key string, value ByteView) error
+}
+
+// JournalFlusher 可选接口，Journal 实现该接口时，分组在释放分片写锁之后调用 Flush 等待记录同步到磁盘，
+// 耗时的同步不会阻塞同一个分片的读写。Flush 失败时修改已经生效，错误返回给写入的调用方
+type JournalFlusher interface {
+	Flush() error
+}
+
+const (
+	FsyncAlways   = "always"   // 每次写入返回之前都同步到磁盘，最多丢失正在写入的记录
+	FsyncEverySec = "everysec" // 每秒同步一次，崩溃时最多丢失一秒的修改
+	FsyncNo       = "no"       // 只写入操作系统的缓冲区，由操作系统决定何时同步
+
+	aofMagic              = "JWCA"
+	aofVersion            = 1
+	aofHeaderSize         = len(aofMagic) + 2
+	aofRecordHeaderSize   = 8        // 每条记录的头部：记录的长度(4) + CRC-32C(4)
+	defaultAOFCompactSize = 64 << 20 // 表示默认的日志压缩阈值
+	aofSyncInterval       = time.Second
+)
+
+// AOFOptions 追加日志的配置项，零值表示使用默认配置
+type AOFOptions struct {
+	Fsync       string // Fsync 同步到磁盘的策略：always、everysec 或 no，默认为 everysec
+	CompactSize int64  // CompactSize 日志超过该大小时在后台压缩为新的快照，默认为 64MB，小于 0 时不自动压缩
+}
+
+// AOFStats 恢复追加日志的结果
+type AOFStats struct {
+	Snapshot  SnapshotStats // Snapshot 从基础快照恢复的结果
+	Records   int           // Records 重放的日志记录数量
+	Skipped   int           // Skipped 跳过的记录数量，包括已经过期的值和不存在的分组中的记录
+	Truncated int64         // Truncated 因为记录不完整或者损坏而被截断的字节数
+}
+
+// AOF 追加日志模式的持久化，目录中保存一个基础快照和之后的若干个日志文件：
+//
+//	jwcache.<n>.snap  基础快照，包含编号小于 n 的日志中的所有修改
+//	jwcache.<n>.aof   日志，编号从小到大重放
+//
+// 启动时使用编号最大的快照，再按顺序重放编号不小于该快照的日志。压缩时先切换到新的日志文件，
+// 再将分组的当前状态写入新的快照，快照通过重命名生效后删除旧的快照和日志，任何时刻崩溃都可以恢复
+type AOF struct {
+	dir       string
+	opts      AOFOptions
+	compactMu sync.Mutex // compactMu 保证同一时间只有一次压缩
+
+	mu     sync.Mutex // mu 保护以下字段，追加记录时持有
+	file   *os.File   // file 当前的日志文件
+	seq    int        // seq 当前日志文件的编号
+	base   int        // base 当前基础快照的编号，为 0 时没有快照
+	size   int64      // size 基础快照之后所有日志的大小
+	dirty  bool       // dirty 是否有还没有同步到磁盘的记录
+	buf    []byte     // buf 编码记录的缓冲区
+	groups []*Group   // groups 已经关联的分组，压缩时写入快照
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenAOF 打开 dir 中的追加日志，目录不存在时创建。打开后需要先调用 Replay 恢复数据，再调用 Attach 关联分组
+func OpenAOF(dir string, opts *AOFOptions) (*AOF, error) {
+	a := &AOF{dir: dir, stop: make(chan struct{}), done: make(chan struct{})}
+	if opts != nil {
+		a.opts = *opts
+	}
+	if a.opts.Fsync == "" {
+		a.opts.Fsync = FsyncEverySec
+	}
+	if err := checkFsync(a.opts.Fsync); err != nil {
+		return nil, err
+	}
+	if a.opts.CompactSize == 0 {
+		a.opts.CompactSize = defaultAOFCompactSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FsyncPolicies 返回所有的同步策略
+func FsyncPolicies() []string {
+	return []string{FsyncAlways, FsyncEverySec, FsyncNo}
+}
+
+func checkFsync(policy string) error {
+	for _, p := range FsyncPolicies() {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fsync policy %q, want always, everysec or no", policy)
+}
+
+// Dir 返回日志所在的目录
+func (a *AOF) Dir() string {
+	return a.dir
+}
+
+// Path 返回当前基础快照的路径，用于快照接口
+func (a *AOF) Path() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapPath(a.base)
+}
+
+func (a *AOF) snapPath(seq int) string {
+	return filepath.Join(a.dir, "jwcache."+strconv.Itoa(seq)+".snap")
+}
+
+func (a *AOF) logPath(seq int) string {
+	return filepath.Join(a.dir, "jwcache."+strconv.Itoa(seq)+".aof")
+}
+
+// listFiles 返回目录中所有快照和日志的编号，按照从小到大排列，并删除压缩中断时留下的临时文件
+func (a *AOF) listFiles() (snaps, logs []int, err error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") && strings.HasPrefix(name, "jwcache.") {
+			os.Remove(filepath.Join(a.dir, name))
+			continue
+		}
+		parts := strings.Split(name, ".")
+		if len(parts) != 3 || parts[0] != "jwcache" {
+			continue
+		}
+		seq, err := strconv.Atoi(parts[1])
+		if err != nil || seq <= 0 {
+			continue
+		}
+		switch parts[2] {
+		case "snap":
+			snaps = append(snaps, seq)
+		case "aof":
+			logs = append(logs, seq)
+		}
+	}
+	sort.Ints(snaps)
+	sort.Ints(logs)
+	return snaps, logs, nil
+}
+
+// Replay 从基础快照和日志恢复已经创建的同名分组，日志末尾不完整或者损坏的记录会被截断，
+// 之后的修改追加到最后一个日志文件中。基础快照损坏时返回错误，此时不应该继续使用该目录，避免压缩时覆盖旧的数据
+func (a *AOF) Replay() (AOFStats, error) {
+	var stats AOFStats
+	snaps, logs, err := a.listFiles()
+	if err != nil {
+		return stats, err
+	}
+	base := 0
+	if len(snaps) > 0 {
+		base = snaps[len(snaps)-1]
+		if stats.Snapshot, err = LoadSnapshot(a.snapPath(base)); err != nil {
+			return stats, err
+		}
+	}
+	var replay []int
+	for _, seq := range logs {
+		if seq >= base {
+			replay = append(replay, seq)
+		}
+	}
+	size := int64(0)
+	for i, seq := range replay {
+		n, err := a.replayLog(a.logPath(seq), &stats)
+		size += n
+		if err == nil {
+			continue
+		}
+		// 记录损坏时截断到最后一条完整的记录，之后的日志中的修改依赖被截断的记录，不能继续重放
+		if !errors.Is(err, errBadRecord) {
+			return stats, err
+		}
+		info, statErr := os.Stat(a.logPath(seq))
+		if statErr != nil {
+			return stats, statErr
+		}
+		stats.Truncated += info.Size() - n
+		if err := os.Truncate(a.logPath(seq), n); err != nil {
+			return stats, err
+		}
+		for _, later := range replay[i+1:] {
+			if info, err := os.Stat(a.logPath(later)); err == nil {
+				stats.Truncated += info.Size()
+			}
+			os.Remove(a.logPath(later))
+		}
+		log.Warn("aof truncated", "file", a.logPath(seq), "offset", n, "truncated", stats.Truncated, "err", err)
+		replay = replay[:i+1]
+		break
+	}
+	a.removeBefore(base)
+
+	seq := base
+	if len(replay) > 0 {
+		seq = replay[len(replay)-1]
+	}
+	if seq == 0 {
+		seq = 1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file, err = openLog(a.logPath(seq)); err != nil {
+		return stats, err
+	}
+	a.seq, a.base, a.size = seq, base, size
+	go a.loop()
+	return stats, nil
+}
+
+// errBadRecord 日志中的记录不完整或者损坏
+var errBadRecord = errors.New("bad aof record")
+
+// replayLog 重放一个日志文件，返回最后一条完整记录的结束位置
+func (a *AOF) replayLog(path string, stats *AOFStats) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header := make([]byte, aofHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// 创建日志文件时崩溃，头部还没有写完
+			return 0, fmt.Errorf("%w: truncated header", errBadRecord)
+		}
+		return 0, err
+	}
+	if string(header[:len(aofMagic)]) != aofMagic {
+		return 0, fmt.Errorf("%s is not an aof file", path)
+	}
+	if v := binary.LittleEndian.Uint16(header[len(aofMagic):]); v != aofVersion {
+		return 0, fmt.Errorf("%s: unsupported aof version %d, want %d", path, v, aofVersion)
+	}
+	offset := int64(aofHeaderSize)
+	now := time.Now()
+	var recordHeader [aofRecordHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, recordHeader[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, fmt.Errorf("%w: truncated record header", errBadRecord)
+			}
+			return offset, err
+		}
+		n := binary.LittleEndian.Uint32(recordHeader[:4])
+		if n > 2*maxRecordBytes {
+			return offset, fmt.Errorf("%w: record of %d bytes is too large", errBadRecord, n)
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, fmt.Errorf("%w: truncated record", errBadRecord)
+			}
+			return offset, err
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(recordHeader[4:]) {
+			return offset, fmt.Errorf("%w: checksum mismatch", errBadRecord)
+		}
+		op, group, key, value, err := decodeRecord(payload)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(aofRecordHeaderSize) + int64(n)
+		stats.Records++
+		g := GetGroup(group)
+		switch {
+		case g == nil:
+			stats.Skipped++
+		case op == JournalSet:
+			if !value.expire.IsZero() && now.After(value.expire) {
+				stats.Skipped++
+				g.mainCache.delete(key)
+				continue
+			}
+			g.mainCache.add(key, value)
+		default:
+			g.mainCache.delete(key)
+		}
+	}
+}
+
+// openLog 以追加的方式打开日志文件，新建的文件会先写入头部
+func openLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() == 0 {
+		header := make([]byte, aofHeaderSize)
+		copy(header, aofMagic)
+		binary.LittleEndian.PutUint16(header[len(aofMagic):], aofVersion)
+		if _, err = f.Write(header); err == nil {
+			err = f.Sync()
+		}
+		if err == nil {
+			err = syncDir(filepath.Dir(path))
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// encodeRecord 将一条修改编码为日志记录，记录的内容为：
+//
+//	类型(1) | 组名长度 | 组名 | 键的长度 | 键 | [编码方式(1) | 过期时间(8) | 值的长度 | 值]
+//
+// 只有 JournalSet 包含方括号中的部分，记录之前是内容的长度和 CRC-32C
+func encodeRecord(buf []byte, op JournalOp, group, key string, value ByteView) []byte {
+	buf = append(buf[:0], make([]byte, aofRecordHeaderSize)...)
+	buf = append(buf, byte(op))
+	buf = binary.AppendUvarint(buf, uint64(len(group)))
+	buf = append(buf, group...)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	if op == JournalSet {
+		buf = append(buf, byte(value.encoding))
+		var expire int64
+		if !value.expire.IsZero() {
+			expire = value.expire.UnixNano()
+		}
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(expire))
+		buf = binary.AppendUvarint(buf, uint64(len(value.bytes)))
+		buf = append(buf, value.bytes...)
+	}
+	payload := buf[aofRecordHeaderSize:]
+	binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+// decodeRecord 解析日志记录的内容，校验和已经通过但内容仍然无法解析时视为损坏
+func decodeRecord(p []byte) (op JournalOp, group, key string, value ByteView, err error) {
+	bad := fmt.Errorf("%w: malformed record", errBadRecord)
+	readBytes := func() ([]byte, bool) {
+		n, w := binary.Uvarint(p)
+		if w <= 0 || uint64(len(p)-w) < n {
+			return nil, false
+		}
+		b := p[w : w+int(n)]
+		p = p[w+int(n):]
+		return b, true
+	}
+	if len(p) == 0 {
+		return 0, "", "", ByteView{}, bad
+	}
+	op, p = JournalOp(p[0]), p[1:]
+	g, ok := readBytes()
+	if !ok {
+		return 0, "", "", ByteView{}, bad
+	}
+	k, ok := readBytes()
+	if !ok {
+		return 0, "", "", ByteView{}, bad
+	}
+	switch op {
+	case JournalDelete, JournalExpire:
+		return op, string(g), string(k), ByteView{}, nil
+	case JournalSet:
+	default:
+		return 0, "", "", ByteView{}, bad
+	}
+	if len(p) < 9 || Encoding(p[0]) > EncodingFlate {
+		return 0, "", "", ByteView{}, bad
+	}
+	value.encoding = Encoding(p[0])
+	if expire := int64(binary.LittleEndian.Uint64(p[1:9])); expire != 0 {
+		value.expire = time.Unix(0, expire)
+	}
+	p = p[9:]
+	v, ok := readBytes()
+	if !ok || len(p) != 0 {
+		return 0, "", "", ByteView{}, bad
+	}
+	value.bytes = cloneBytes(v)
+	return op, string(g), string(k), value, nil
+}
+
+// Attach 关联分组，之后分组本地缓存的修改会追加到日志中，压缩时分组会被写入快照
+func (a *AOF) Attach(groups ...*Group) {
+	a.mu.Lock()
+	a.groups = append(a.groups, groups...)
+	a.mu.Unlock()
+	for _, g := range groups {
+		g.SetJournal(a)
+	}
+}
+
+// Append 实现 Journal，将一条修改追加到当前的日志文件中，只写入操作系统的缓冲区，同步由 Flush 或后台完成
+func (a *AOF) Append(op JournalOp, group, key string, value ByteView) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed || a.file == nil {
+		return errors.New("aof is closed")
+	}
+	a.buf = encodeRecord(a.buf, op, group, key, value)
+	if _, err := a.file.Write(a.buf); err != nil {
+		return err
+	}
+	a.size += int64(len(a.buf))
+	a.dirty = true
+	return nil
+}
+
+// Flush 实现 JournalFlusher，同步策略为 always 时将日志同步到磁盘，其他策略直接返回。
+// 同步时不持有 mu，多个分组、多个分片的写入可以同时等待同步，不会互相阻塞追加记录。
+// 同步前日志文件被压缩切换或者关闭时，旧的文件在关闭之前已经同步过
+func (a *AOF) Flush() error {
+	if a.opts.Fsync != FsyncAlways {
+		return nil
+	}
+	a.mu.Lock()
+	file := a.file
+	a.mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Size 返回基础快照之后所有日志的大小
+func (a *AOF) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// loop 按照同步策略定时同步日志，日志超过压缩阈值时压缩
+func (a *AOF) loop() {
+	defer close(a.done)
+	ticker := time.NewTicker(aofSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		if a.opts.Fsync == FsyncEverySec {
+			if err := a.Sync(); err != nil {
+				log.Error("aof fsync failed", "dir", a.dir, "err", err)
+			}
+		}
+		if a.opts.CompactSize > 0 && a.Size() > a.opts.CompactSize {
+			// 在单独的 goroutine 中压缩，压缩期间仍然按时同步日志；上一次压缩还没有结束时跳过
+			go func() {
+				if !a.compactMu.TryLock() {
+					return
+				}
+				defer a.compactMu.Unlock()
+				if _, err := a.compact(); err != nil {
+					log.Error("aof compaction failed", "dir", a.dir, "err", err)
+				}
+			}()
+		}
+	}
+}
+
+// Sync 将还没有同步的记录同步到磁盘
+func (a *AOF) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.syncLocked()
+}
+
+func (a *AOF) syncLocked() error {
+	if !a.dirty || a.file == nil {
+		return nil
+	}
+	a.dirty = false
+	return a.file.Sync()
+}
+
+// Save 实现快照接口，立即压缩日志
+func (a *AOF) Save() (SnapshotStats, error) {
+	return a.Compact()
+}
+
+// Compact 将关联的分组写入新的基础快照，并删除旧的快照和日志。压缩期间的修改写入新的日志文件，
+// 不会阻塞分组的读写；压缩失败时旧的快照和日志仍然有效
+func (a *AOF) Compact() (SnapshotStats, error) {
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+	return a.compact()
+}
+
+// compact 压缩日志，调用时需要持有 compactMu
+func (a *AOF) compact() (SnapshotStats, error) {
+	a.mu.Lock()
+	if a.closed || a.file == nil {
+		a.mu.Unlock()
+		return SnapshotStats{}, errors.New("aof is closed")
+	}
+	next := a.seq + 1
+	file, err := openLog(a.logPath(next))
+	if err == nil {
+		if err = a.syncLocked(); err == nil {
+			err = a.file.Close()
+		}
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		a.mu.Unlock()
+		return SnapshotStats{}, err
+	}
+	// 切换之后的修改写入新的日志，之前的修改已经在缓存中，会被写入快照
+	a.file, a.seq, a.size = file, next, 0
+	groups := append([]*Group(nil), a.groups...)
+	a.mu.Unlock()
+
+	start := time.Now()
+	stats, err := SaveSnapshot(a.snapPath(next), groups...)
+	if err != nil {
+		return stats, err
+	}
+	a.mu.Lock()
+	a.base = next
+	a.mu.Unlock()
+	a.removeBefore(next)
+	log.Info("aof compacted", "snapshot", a.snapPath(next), "entries", stats.Entries, "bytes", stats.Bytes, "cost", time.Since(start))
+	return stats, nil
+}
+
+// removeBefore 删除编号小于 seq 的快照和日志
+func (a *AOF) removeBefore(seq int) {
+	snaps, logs, err := a.listFiles()
+	if err != nil {
+		return
+	}
+	for _, s := range snaps {
+		if s < seq {
+			os.Remove(a.snapPath(s))
+		}
+	}
+	for _, s := range logs {
+		if s < seq {
+			os.Remove(a.logPath(s))
+		}
+	}
+}
+
+// Close 同步并关闭日志，之后的修改不会再被记录，分组的 Journal 仍然指向 AOF，写入时会返回错误
+func (a *AOF) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	started := a.file != nil
+	var err error
+	if started {
+		a.dirty = true
+		if err = a.syncLocked(); err == nil {
+			err = a.file.Close()
+		}
+		a.file = nil
+	}
+	a.mu.Unlock()
+	if started {
+		close(a.stop)
+		<-a.done
+	}
+	return err
+}
@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	defaultArenaBytes = 64 << 20 // 表示不限制容量时预先分配的内存大小
+	// 每条数据的头部：哈希值(8) + 过期时间(8) + 键的长度(4) + 值的长度(4) + 编码方式(1) + 是否有效(1)
+	arenaHeaderSize = 26
+)
+
+// ArenaCache 基于预分配内存的淘汰策略，键和值都保存在一整块环形的 []byte 中，
+// 索引为不包含指针的 map[uint64]uint32，GC 不需要扫描其中的数据，适合保存大量较小的值。
+// 写满后从最先写入的数据开始覆盖（先进先出），过期的数据在读取时视为不存在，只能保存 ByteView
+type ArenaCache struct {
+	buf       []byte                        // 保存数据的环形缓冲区
+	index     map[uint64]uint32             // 键的哈希值到数据在 buf 中偏移量的索引
+	head      int                           // 最先写入的数据的偏移量
+	tail      int                           // 下一条数据写入的偏移量
+	end       int                           // 回绕后旧数据的结束位置，只有 wrapped 为 true 时有效
+	wrapped   bool                          // 是否已经回绕，回绕后数据分布在 [head, end) 和 [0, tail) 中
+	nowBytes  int64                         // 有效数据的键和值占用的大小
+	items     int                           // 有效数据的数量
+	OnEvicted func(key string, value Value) // 记录被淘汰时的回调函数，可选参数
+}
+
+// NewArena 实例化 ArenaCache，预先分配 maxBytes 大小的内存，maxBytes 为 0 时分配 64MB。
+// 分组的每个分片都会分配一块内存，因此分组使用 arena 时必须指定容量，见 newCache
+func NewArena(maxBytes int64, onEvicted func(string, Value)) *ArenaCache {
+	if maxBytes <= 0 {
+		maxBytes = defaultArenaBytes
+	}
+	if maxBytes > math.MaxUint32 { // 偏移量使用 uint32 保存
+		maxBytes = math.MaxUint32
+	}
+	return &ArenaCache{
+		buf:       make([]byte, maxBytes),
+		index:     make(map[uint64]uint32),
+		OnEvicted: onEvicted,
+	}
+}
+
+// arenaEntry 数据头部解析后的结果
+type arenaEntry struct {
+	hash     uint64
+	expire   int64
+	keyLen   int
+	valLen   int
+	encoding Encoding
+	live     bool
+}
+
+func (e arenaEntry) size() int {
+	return arenaHeaderSize + e.keyLen + e.valLen
+}
+
+// entryAt 解析 off 处的数据头部
+func (c *ArenaCache) entryAt(off int) arenaEntry {
+	b := c.buf[off:]
+	return arenaEntry{
+		hash:     binary.LittleEndian.Uint64(b),
+		expire:   int64(binary.LittleEndian.Uint64(b[8:])),
+		keyLen:   int(binary.LittleEndian.Uint32(b[16:])),
+		valLen:   int(binary.LittleEndian.Uint32(b[20:])),
+		encoding: Encoding(b[24]),
+		live:     b[25] == 1,
+	}
+}
+
+// keyAt 返回 off 处数据的键
+func (c *ArenaCache) keyAt(off int, e arenaEntry) []byte {
+	start := off + arenaHeaderSize
+	return c.buf[start : start+e.keyLen]
+}
+
+// viewAt 将 off 处的值拷贝到 ByteView 中，缓冲区会被覆盖，因此不能直接引用
+func (c *ArenaCache) viewAt(off int, e arenaEntry) ByteView {
+	start := off + arenaHeaderSize + e.keyLen
+	view := ByteView{bytes: cloneBytes(c.buf[start : start+e.valLen]), encoding: e.encoding}
+	if e.expire != 0 {
+		view.expire = time.Unix(0, e.expire)
+	}
+	return view
+}
+
+// lookup 查找键对应的数据，哈希冲突时比较完整的键
+func (c *ArenaCache) lookup(key string) (int, arenaEntry, bool) {
+	off, ok := c.index[hashKey(key)]
+	if !ok {
+		return 0, arenaEntry{}, false
+	}
+	e := c.entryAt(int(off))
+	if !e.live || string(c.keyAt(int(off), e)) != key {
+		return 0, arenaEntry{}, false
+	}
+	return int(off), e, true
+}
+
+// Get 查找功能，不改变淘汰顺序
+func (c *ArenaCache) Get(key string) (value Value, ok bool) {
+	return c.Peek(key)
+}
+
+// Peek 查找功能，已经过期的数据视为不存在
+func (c *ArenaCache) Peek(key string) (value Value, ok bool) {
+	off, e, ok := c.lookup(key)
+	if !ok || (e.expire != 0 && time.Now().UnixNano() > e.expire) {
+		return nil, false
+	}
+	return c.viewAt(off, e), true
+}
+
+// Add 新增/修改，修改时旧数据被标记为无效，新数据写入缓冲区末尾，空间不足时淘汰最先写入的数据
+func (c *ArenaCache) Add(key string, value Value) {
+	view, ok := value.(ByteView)
+	if !ok {
+		panic(fmt.Sprintf("arena evicter can only store ByteView, got %T", value))
+	}
+	c.Delete(key)
+	e := arenaEntry{hash: hashKey(key), keyLen: len(key), valLen: len(view.bytes), encoding: view.encoding, live: true}
+	if !view.expire.IsZero() {
+		e.expire = view.expire.UnixNano()
+	}
+	if e.size() > len(c.buf) {
+		// 数据比整个缓冲区还大，无法保存，与其他淘汰策略一样视为写入后立即被淘汰，
+		// 调用方可以通过 OnEvicted（例如淘汰次数的统计和二级缓存）看到这个值
+		if c.OnEvicted != nil {
+			c.OnEvicted(key, view)
+		}
+		return
+	}
+	c.makeRoom(e.size())
+
+	b := c.buf[c.tail:]
+	binary.LittleEndian.PutUint64(b, e.hash)
+	binary.LittleEndian.PutUint64(b[8:], uint64(e.expire))
+	binary.LittleEndian.PutUint32(b[16:], uint32(e.keyLen))
+	binary.LittleEndian.PutUint32(b[20:], uint32(e.valLen))
+	b[24] = byte(e.encoding)
+	b[25] = 1
+	copy(b[arenaHeaderSize:], key)
+	copy(b[arenaHeaderSize+e.keyLen:], view.bytes)
+
+	// 哈希冲突时旧数据会从索引中被替换掉，相当于被淘汰
+	if off, ok := c.index[e.hash]; ok {
+		c.remove(int(off), c.entryAt(int(off)), true)
+	}
+	c.index[e.hash] = uint32(c.tail)
+	c.tail += e.size()
+	c.nowBytes += int64(e.keyLen + e.valLen)
+	c.items++
+}
+
+// makeRoom 从最先写入的数据开始淘汰，直到 tail 之后有 n 个字节的连续空间
+func (c *ArenaCache) makeRoom(n int) {
+	for {
+		if !c.wrapped {
+			if c.tail+n <= len(c.buf) {
+				return
+			}
+			if c.head == c.tail { // 没有数据，直接从头开始写
+				c.head, c.tail = 0, 0
+				continue
+			}
+			// 末尾的空间不足，回绕到缓冲区开头，[tail, len(buf)) 之间的空间被丢弃
+			c.end, c.tail, c.wrapped = c.tail, 0, true
+			continue
+		}
+		if c.tail+n <= c.head {
+			return
+		}
+		e := c.entryAt(c.head)
+		if e.live {
+			c.remove(c.head, e, true)
+		}
+		c.head += e.size()
+		if c.head == c.end { // 回绕前的数据已经全部淘汰
+			c.head, c.wrapped = 0, false
+		}
+	}
+}
+
+// remove 将 off 处的数据标记为无效，并从索引中删除
+func (c *ArenaCache) remove(off int, e arenaEntry, evicted bool) {
+	if !e.live {
+		return
+	}
+	c.buf[off+25] = 0
+	if cur, ok := c.index[e.hash]; ok && int(cur) == off {
+		delete(c.index, e.hash)
+	}
+	c.nowBytes -= int64(e.keyLen + e.valLen)
+	c.items--
+	if evicted && c.OnEvicted != nil {
+		c.OnEvicted(string(c.keyAt(off, e)), c.viewAt(off, e))
+	}
+}
+
+// Delete 删除指定的键，返回该键是否存在
+func (c *ArenaCache) Delete(key string) bool {
+	off, e, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	c.remove(off, e, false)
+	return true
+}
+
+// Keys 返回所有的键，包括已经过期但还没有被覆盖的键，按照写入的顺序排列，最新写入的在前
+func (c *ArenaCache) Keys() []string {
+	keys := make([]string, c.items)
+	i := c.items
+	c.each(func(off int, e arenaEntry) {
+		i--
+		keys[i] = string(c.keyAt(off, e))
+	})
+	return keys
+}
+
+// Len 返回缓存值的数量
+func (c *ArenaCache) Len() int {
+	return c.items
+}
+
+// NowSize 返回有效数据的键和值占用的大小，不包括数据头部和已经失效但还没有被覆盖的数据
+func (c *ArenaCache) NowSize() int64 {
+	return c.nowBytes
+}
+
+// MaxCapacity 返回预先分配的内存大小
+func (c *ArenaCache) MaxCapacity() int64 {
+	return int64(len(c.buf))
+}
+
+// SetMaxCapacity 重新分配 maxCapacity 大小的内存，未过期的数据按照写入的顺序拷贝到新的缓冲区，
+// 容量变小时最先写入的数据会被淘汰。arena 的内存是预先分配的，不能设置为不限制容量
+func (c *ArenaCache) SetMaxCapacity(maxCapacity int64) error {
+	if maxCapacity <= 0 {
+		return fmt.Errorf("arena requires a positive capacity, got %d", maxCapacity)
+	}
+	resized := NewArena(maxCapacity, c.OnEvicted)
+	if len(resized.buf) == len(c.buf) {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	c.each(func(off int, e arenaEntry) {
+		if e.expire == 0 || now <= e.expire {
+			resized.Add(string(c.keyAt(off, e)), c.viewAt(off, e))
+		}
+	})
+	*c = *resized
+	return nil
+}
+
+// AdjustCapacity 在当前容量的基础上调整预先分配的内存大小
+func (c *ArenaCache) AdjustCapacity(delta int64) error {
+	return adjustCapacity(c, delta)
+}
+
+// each 按照写入的顺序遍历所有有效的数据
+func (c *ArenaCache) each(fn func(off int, e arenaEntry)) {
+	walk := func(from, to int) {
+		for off := from; off < to; {
+			e := c.entryAt(off)
+			if e.live {
+				fn(off, e)
+			}
+			off += e.size()
+		}
+	}
+	if c.wrapped {
+		walk(c.head, c.end)
+		walk(0, c.tail)
+	} else {
+		walk(c.head, c.tail)
+	}
+}
@@ -1,22 +1,117 @@
 package cache
 
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
 // ByteView 只读数据结构，用于支持并发操作
 type ByteView struct {
-	bytes []byte
+	bytes    []byte
+	expire   time.Time // 过期时间，零值表示永不过期
+	encoding Encoding  // bytes 的编码方式，压缩后的数据在读取时会自动解压
 }
 
-// Len 继承 Value 接口的方法
+// Len 继承 Value 接口的方法，返回实际存储的大小，数据被压缩时为压缩后的大小
 func (v ByteView) Len() int {
 	return len(v.bytes)
 }
 
-// ByteSlice 返回当前数据的拷贝
+// ByteSlice 返回当前数据的拷贝，数据被压缩时返回解压后的数据
 func (v ByteView) ByteSlice() []byte {
+	if v.encoding != EncodingIdentity {
+		return v.decoded()
+	}
 	return cloneBytes(v.bytes)
 }
 
 func (v ByteView) String() string {
-	return string(v.bytes)
+	return string(v.view())
+}
+
+// At 返回下标为 i 的字节，数据被压缩时需要先解压，应尽量避免在循环中调用
+func (v ByteView) At(i int) byte {
+	return v.view()[i]
+}
+
+// Slice 返回 [from, to) 之间的数据，返回的 ByteView 与原数据共享底层数组，不会发生拷贝
+func (v ByteView) Slice(from, to int) ByteView {
+	return ByteView{bytes: v.view()[from:to], expire: v.expire}
+}
+
+// SliceFrom 返回从 from 开始的数据，不会发生拷贝
+func (v ByteView) SliceFrom(from int) ByteView {
+	return ByteView{bytes: v.view()[from:], expire: v.expire}
+}
+
+// Equal 判断两个 ByteView 中的数据是否相同
+func (v ByteView) Equal(b ByteView) bool {
+	return bytes.Equal(v.view(), b.view())
+}
+
+// EqualBytes 判断数据是否与 b 相同
+func (v ByteView) EqualBytes(b []byte) bool {
+	return bytes.Equal(v.view(), b)
+}
+
+// EqualString 判断数据是否与 s 相同
+func (v ByteView) EqualString(s string) bool {
+	return string(v.view()) == s
+}
+
+// Reader 返回读取数据的 io.ReadSeeker，未压缩时直接读取底层数组，不会发生拷贝
+func (v ByteView) Reader() io.ReadSeeker {
+	return bytes.NewReader(v.view())
+}
+
+// WriteTo 将数据写入 w，未压缩时直接写入底层数组，不会发生拷贝
+func (v ByteView) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(v.view())
+	return int64(n), err
+}
+
+// WriteEncodedTo 将编码后的数据写入 w，用于在节点之间直接传输压缩后的数据，不会发生拷贝
+func (v ByteView) WriteEncodedTo(w io.Writer) (int64, error) {
+	n, err := w.Write(v.bytes)
+	return int64(n), err
+}
+
+// Encoding 返回数据的编码方式
+func (v ByteView) Encoding() Encoding {
+	return v.encoding
+}
+
+// EncodedBytes 返回编码后数据的拷贝，用于在节点之间直接传输压缩后的数据
+func (v ByteView) EncodedBytes() []byte {
+	return cloneBytes(v.bytes)
+}
+
+// Expire 返回过期时间，零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.expire
+}
+
+// Expired 判断数据是否已经过期
+func (v ByteView) Expired() bool {
+	return !v.expire.IsZero() && time.Now().After(v.expire)
+}
+
+// view 返回只读的原始数据，未压缩时直接返回底层数组，调用方不能修改返回的数据
+func (v ByteView) view() []byte {
+	if v.encoding != EncodingIdentity {
+		return v.decoded()
+	}
+	return v.bytes
+}
+
+// decoded 返回解压后的数据，压缩数据只会在本地生成或在接收时校验过，因此解压不会失败
+func (v ByteView) decoded() []byte {
+	b, err := decompress(v.encoding, v.bytes)
+	if err != nil {
+		return nil
+	}
+	return b
 }
 
 // 拷贝数据
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"container/list"
+	"fmt"
 )
 
 // Cache 定义了LRU Cache的基本数据结构
@@ -34,6 +35,14 @@ func (c *Cache) Get(key string) (value Value, success bool) {
 	return nil, false
 }
 
+// Peek 查找功能，与 Get 不同，不会改变节点在队列中的位置
+func (c *Cache) Peek(key string) (value Value, success bool) {
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return nil, false
+}
+
 // Remove 删除(缓存淘汰)
 func (c *Cache) Remove() {
 	ele := c.ll.Back()
@@ -65,11 +74,60 @@ func (c *Cache) Add(key string, value Value) {
 	}
 }
 
+// Delete 删除指定的键，返回该键是否存在
+func (c *Cache) Delete(key string) bool {
+	ele, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	c.ll.Remove(ele)
+	delete(c.cache, key)
+	kv := ele.Value.(*entry)
+	c.nowBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	return true
+}
+
+// Keys 返回所有的键，按照最近使用的顺序排列
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		keys = append(keys, ele.Value.(*entry).key)
+	}
+	return keys
+}
+
 // Len 方便测试
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
 
+// NowSize 返回已使用的内存
+func (c *Cache) NowSize() int64 {
+	return c.nowBytes
+}
+
+// MaxCapacity 返回允许使用的最大内存
+func (c *Cache) MaxCapacity() int64 {
+	return c.maxBytes
+}
+
+// SetMaxCapacity 设置允许使用的最大内存，为 0 时不限制，超出新的容量时立即淘汰最近最少使用的数据
+func (c *Cache) SetMaxCapacity(maxCapacity int64) error {
+	if maxCapacity < 0 {
+		return fmt.Errorf("bad max capacity %d", maxCapacity)
+	}
+	c.maxBytes = maxCapacity
+	for c.maxBytes != 0 && c.maxBytes < c.nowBytes {
+		c.Remove()
+	}
+	return nil
+}
+
+// AdjustCapacity 在当前最大容量的基础上调整允许使用的最大内存
+func (c *Cache) AdjustCapacity(delta int64) error {
+	return adjustCapacity(c, delta)
+}
+
 // New 实例化 Cache
 func New(maxBytes int64, OnEvicted func(string, Value)) *Cache {
 	return &Cache{
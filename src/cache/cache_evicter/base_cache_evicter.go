@@ -1,10 +1,10 @@
 package cache_evicter
 
 import (
+	"fmt"
 	k "jw-cache/src/cache/cache_key"
 	v "jw-cache/src/cache/cache_value"
 	"jw-cache/src/pgk/log"
-	"strconv"
 )
 
 // CacheEvicter 缓存淘汰接口
@@ -43,11 +43,11 @@ func (cache *BaseCacheEvicter) Add(key *k.Key, value v.CacheValue) {
 
 func (cache *BaseCacheEvicter) BeforeAdd(key *k.Key, value v.CacheValue) bool {
 	if value == nil {
-		log.Debug("[Cache] value is null, key: %s, value: %v", key, value)
+		log.Debug("[Cache] value is null", "key", key)
 		return false
 	}
 	if cache.nowBytes+key.Size()+value.Size() > cache.maxBytes {
-		log.Debug("[Cache] 缓存已满, 现容量: %s, 最大容量: %d", strconv.FormatInt(cache.nowBytes, 10), cache.maxBytes)
+		log.Debug("[Cache] 缓存已满", "now_bytes", cache.nowBytes, "max_bytes", cache.maxBytes)
 		return false
 	}
 	return true
@@ -92,19 +92,32 @@ func (cache *BaseCacheEvicter) NowSize() int64 {
 
 // MaxCapacity 返回缓存的最大容量
 func (cache *BaseCacheEvicter) MaxCapacity() int64 {
-	return cache.nowBytes
+	return cache.maxBytes
 }
 
-// SetMaxCapacity 设置缓存的最大容量
+// SetMaxCapacity 设置缓存的最大容量，容量必须为正数。BaseCacheEvicter 不保存数据，
+// 具体的淘汰策略需要在设置之后淘汰数据直到不超过新的容量
 func (cache *BaseCacheEvicter) SetMaxCapacity(maxCapacity int64) error {
-	// todo 判断maxCapacity的合理性，根据实际情况进行缓存淘汰
+	if maxCapacity <= 0 {
+		return fmt.Errorf("bad max capacity %d", maxCapacity)
+	}
 	cache.maxBytes = maxCapacity
 	return nil
 }
 
-// AdjustCapacity 调整缓存的最大容量
+// AdjustCapacity 在当前最大容量的基础上增加（capacity 为正数）或减少缓存容量
 func (cache *BaseCacheEvicter) AdjustCapacity(capacity int64) error {
-	// todo 判断capacity的合理性，根据实际情况进行缓存淘汰
-	cache.maxBytes += capacity
-	return nil
+	max, err := cache.adjusted(capacity)
+	if err != nil {
+		return err
+	}
+	return cache.SetMaxCapacity(max)
+}
+
+// adjusted 根据当前的最大容量计算调整后的容量，调整后的容量必须为正数
+func (cache *BaseCacheEvicter) adjusted(delta int64) (int64, error) {
+	if cache.maxBytes+delta <= 0 {
+		return 0, fmt.Errorf("capacity %d%+d should be positive", cache.maxBytes, delta)
+	}
+	return cache.maxBytes + delta, nil
 }
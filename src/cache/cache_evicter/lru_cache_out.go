@@ -2,6 +2,7 @@ package cache_evicter
 
 import (
 	"container/list"
+	"jw-cache/src/cache"
 	k "jw-cache/src/cache/cache_key"
 	v "jw-cache/src/cache/cache_value"
 	"jw-cache/src/pgk/log"
@@ -15,6 +16,9 @@ type LRUCacheEvict struct {
 	//mu         sync.Mutex
 }
 
+// LRUCacheEvict 与内置的淘汰策略一样支持在运行时修改容量
+var _ cache.CapacitySetter = (*LRUCacheEvict)(nil)
+
 type cacheNode struct {
 	key   *k.Key
 	value v.CacheValue
@@ -27,21 +31,21 @@ func NewLRUCache(maxBytes int64, onEvicted func(*k.Key, v.CacheValue)) *LRUCache
 		make(map[*k.Key]*list.Element)}
 }
 
-func (cache LRUCacheEvict) Add(key *k.Key, value v.CacheValue) {
+func (cache *LRUCacheEvict) Add(key *k.Key, value v.CacheValue) {
 	if !cache.BeforeAdd(key, value) {
 		return
 	}
 	if _, exist := cache.cache[key]; exist {
-		log.Debug("[Cache] 缓存值已存在, key: %s", key)
+		log.Debug("[Cache] 缓存值已存在", "key", key)
 		return
 	}
 	cache.nowBytes += key.Size() + value.Size()
 	ele := cache.evictList.PushFront(&cacheNode{key: key, value: value})
 	cache.cache[key] = ele
-	log.Debug("[Cache] 缓存值添加成功: {key: %s, value: %s}", key.String(), value.ToString())
+	log.Debug("[Cache] 缓存值添加成功", "key", key.String(), "value", value.ToString())
 }
 
-func (cache LRUCacheEvict) Get(key *k.Key) (value v.CacheValue, exist bool) {
+func (cache *LRUCacheEvict) Get(key *k.Key) (value v.CacheValue, exist bool) {
 	if val, ok := cache.cache[key]; ok {
 		cache.evictList.MoveToFront(val)
 		kv := val.Value.(*cacheNode)
@@ -50,7 +54,7 @@ func (cache LRUCacheEvict) Get(key *k.Key) (value v.CacheValue, exist bool) {
 	return nil, false
 }
 
-func (cache LRUCacheEvict) Update(key *k.Key, value v.CacheValue) error {
+func (cache *LRUCacheEvict) Update(key *k.Key, value v.CacheValue) error {
 	//if val , exist := cache.cache[key]; exist {
 	//} else {
 	//
@@ -58,31 +62,66 @@ func (cache LRUCacheEvict) Update(key *k.Key, value v.CacheValue) error {
 	return nil
 }
 
-func (cache LRUCacheEvict) Delete(key *k.Key) error {
+func (cache *LRUCacheEvict) Delete(key *k.Key) error {
 	if ele, ok := cache.cache[key]; ok {
 		cache.evictList.Remove(ele)
 		delete(cache.cache, key)
 		kv := ele.Value.(*cacheNode)
 		cache.nowBytes -= key.Size() + kv.value.Size()
-		log.Debug("[Cache] 缓存删除: %v", kv)
+		log.Debug("[Cache] 缓存删除", "entry", kv)
 	} else {
-		log.Debug("[Cache] 缓存值不存在, key: %s", key)
+		log.Debug("[Cache] 缓存值不存在", "key", key)
 	}
 	return nil
 }
 
-func (cache LRUCacheEvict) Clear() error {
+func (cache *LRUCacheEvict) Clear() error {
 	return nil
 }
 
-func (cache LRUCacheEvict) Keys() []*k.Key {
+func (cache *LRUCacheEvict) Keys() []*k.Key {
 	return nil
 }
 
-func (cache LRUCacheEvict) Has(key *k.Key) bool {
+func (cache *LRUCacheEvict) Has(key *k.Key) bool {
 	return true
 }
 
-func (cache LRUCacheEvict) Evict() error {
+func (cache *LRUCacheEvict) Evict() error {
 	return nil
 }
+
+// SetMaxCapacity 设置缓存的最大容量，超出新的容量时立即淘汰最近最少使用的数据
+func (cache *LRUCacheEvict) SetMaxCapacity(maxCapacity int64) error {
+	if err := cache.BaseCacheEvicter.SetMaxCapacity(maxCapacity); err != nil {
+		return err
+	}
+	for cache.nowBytes > cache.maxBytes {
+		cache.removeOldest()
+	}
+	return nil
+}
+
+// AdjustCapacity 在当前最大容量的基础上调整缓存容量，容量变小时立即淘汰数据
+func (cache *LRUCacheEvict) AdjustCapacity(capacity int64) error {
+	max, err := cache.adjusted(capacity)
+	if err != nil {
+		return err
+	}
+	return cache.SetMaxCapacity(max)
+}
+
+// removeOldest 淘汰最近最少使用的缓存值
+func (cache *LRUCacheEvict) removeOldest() {
+	ele := cache.evictList.Back()
+	if ele == nil {
+		return
+	}
+	cache.evictList.Remove(ele)
+	kv := ele.Value.(*cacheNode)
+	delete(cache.cache, kv.key)
+	cache.nowBytes -= kv.key.Size() + kv.value.Size()
+	if cache.onEvicted != nil {
+		cache.onEvicted(kv.key, kv.value)
+	}
+}
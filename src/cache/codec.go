@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"reflect"
+)
+
+// Codec 类型 T 与字节之间的编解码器，编码后的字节就是缓存中保存以及节点之间传输（pb.Response）的数据
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)    // Encode 将值编码为字节
+	Decode(data []byte) (T, error) // Decode 将字节解码为值
+}
+
+// funcCodec 通过 marshal 和 unmarshal 函数实现的编解码器
+type funcCodec[T any] struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+// NewCodec 通过 marshal 和 unmarshal 函数创建编解码器，
+// msgpack、cbor 等序列化库只需要传入它们的 Marshal 和 Unmarshal 函数即可
+func NewCodec[T any](marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec[T] {
+	return funcCodec[T]{marshal: marshal, unmarshal: unmarshal}
+}
+
+func (c funcCodec[T]) Encode(v T) ([]byte, error) {
+	return c.marshal(v)
+}
+
+func (c funcCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := c.unmarshal(data, &v)
+	return v, err
+}
+
+// JSONCodec 使用JSON编解码
+func JSONCodec[T any]() Codec[T] {
+	return NewCodec[T](json.Marshal, json.Unmarshal)
+}
+
+// GobCodec 使用gob编解码
+func GobCodec[T any]() Codec[T] {
+	return NewCodec[T](gobMarshal, gobUnmarshal)
+}
+
+func gobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobUnmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec 使用protobuf编解码，T 为生成的消息类型的指针，例如 *pb.Request
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T]) Decode(data []byte) (T, error) {
+	var zero T
+	v := reflect.New(reflect.TypeOf(zero).Elem()).Interface().(T) // 通过消息类型创建新的消息
+	err := proto.Unmarshal(data, v)
+	return v, err
+}
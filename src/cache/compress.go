@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Encoding 值的编码方式，与 pb.Response 中的 encoding 字段一一对应
+type Encoding uint32
+
+const (
+	EncodingIdentity Encoding = iota // 未压缩
+	EncodingGzip                     // 使用 gzip 压缩
+	EncodingFlate                    // 使用 flate 压缩
+)
+
+const (
+	CompressionGzip          = "gzip"  // 表示使用 gzip 压缩
+	CompressionFlate         = "flate" // 表示使用 flate 压缩
+	defaultCompressThreshold = 1024    // 表示默认的压缩阈值，小于该大小的值不压缩
+)
+
+// String 返回编码方式的名称
+func (e Encoding) String() string {
+	switch e {
+	case EncodingIdentity:
+		return "identity"
+	case EncodingGzip:
+		return CompressionGzip
+	case EncodingFlate:
+		return CompressionFlate
+	}
+	return fmt.Sprintf("encoding(%d)", uint32(e))
+}
+
+// ParseCompression 根据名称返回编码方式，空字符串表示不压缩
+func ParseCompression(name string) (Encoding, error) {
+	switch name {
+	case "":
+		return EncodingIdentity, nil
+	case CompressionGzip:
+		return EncodingGzip, nil
+	case CompressionFlate:
+		return EncodingFlate, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", name)
+}
+
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// compress 使用指定的编码方式压缩数据
+func compress(enc Encoding, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch enc {
+	case EncodingIdentity:
+		return b, nil
+	case EncodingGzip:
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case EncodingFlate:
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %d", uint32(enc))
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 将其他节点返回的 pb.Response 中按照 enc 编码的 value 还原为原始数据，enc 为 pb.Response 的 encoding 字段，
+// 用于在 Group 之外直接通过节点之间的协议读取值，例如运维工具
+func Decode(enc Encoding, b []byte) ([]byte, error) {
+	return decompress(enc, b)
+}
+
+// decompress 按照编码方式解压数据
+func decompress(enc Encoding, b []byte) ([]byte, error) {
+	var r io.ReadCloser
+	switch enc {
+	case EncodingIdentity:
+		return b, nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing gzip value: %v", err)
+		}
+		r = zr
+	case EncodingFlate:
+		r = flate.NewReader(bytes.NewReader(b))
+	default:
+		return nil, fmt.Errorf("unknown encoding %d", uint32(enc))
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s value: %v", enc, err)
+	}
+	return out, nil
+}
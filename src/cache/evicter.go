@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Evicter 缓存淘汰策略接口，Group 的本地缓存通过该接口存取数据，实现不需要保证并发安全，
+// 但 Peek、Keys、Len、NowSize 和 MaxCapacity 会在读锁下被并发调用，不能修改内部状态
+type Evicter interface {
+	Get(key string) (value Value, ok bool)  // Get 获取缓存值，可以更新淘汰策略的访问记录
+	Peek(key string) (value Value, ok bool) // Peek 获取缓存值，不更新淘汰策略的访问记录
+	Add(key string, value Value)            // Add 新增或修改缓存值，超出最大容量时淘汰数据
+	Delete(key string) bool                 // Delete 删除缓存值，返回该键是否存在
+	Keys() []string                         // Keys 返回所有的键，应当按照最近使用或最新加入的顺序排列，快照按照相反的顺序恢复
+	Len() int                               // Len 返回缓存值的数量
+	NowSize() int64                         // NowSize 返回缓存占用的大小（以字节为单位）
+	MaxCapacity() int64                     // MaxCapacity 返回缓存的最大容量
+}
+
+// CapacitySetter 支持在运行时修改最大容量的淘汰策略，容量变小时立即淘汰数据直到不超过新的容量。
+// 内置的淘汰策略都实现了该接口，自定义的淘汰策略可选实现
+type CapacitySetter interface {
+	SetMaxCapacity(maxCapacity int64) error // SetMaxCapacity 设置缓存的最大容量
+	AdjustCapacity(delta int64) error       // AdjustCapacity 在当前最大容量的基础上增加（delta 为正数）或减少缓存容量
+}
+
+// adjustCapacity 根据当前的最大容量计算新的容量，不限制容量的缓存不能调整，调整后的容量必须为正数
+func adjustCapacity(c interface {
+	MaxCapacity() int64
+	SetMaxCapacity(int64) error
+}, delta int64) error {
+	max := c.MaxCapacity()
+	if max == 0 {
+		return errors.New("can not adjust unlimited capacity")
+	}
+	if max+delta <= 0 {
+		return fmt.Errorf("capacity %d%+d should be positive", max, delta)
+	}
+	return c.SetMaxCapacity(max + delta)
+}
+
+// EvicterFactory 根据最大容量和淘汰回调函数创建淘汰策略
+type EvicterFactory func(maxBytes int64, onEvicted func(key string, value Value)) Evicter
+
+const (
+	PolicyLRU   = "lru"   // 最近最少使用
+	PolicyFIFO  = "fifo"  // 先进先出
+	PolicyArena = "arena" // 基于预分配内存的先进先出，适合保存大量较小的值
+)
+
+var (
+	evictersMu sync.RWMutex
+	evicters   = map[string]EvicterFactory{
+		PolicyLRU: func(maxBytes int64, onEvicted func(string, Value)) Evicter {
+			return New(maxBytes, onEvicted)
+		},
+		PolicyFIFO: func(maxBytes int64, onEvicted func(string, Value)) Evicter {
+			return NewFIFO(maxBytes, onEvicted)
+		},
+		PolicyArena: func(maxBytes int64, onEvicted func(string, Value)) Evicter {
+			return NewArena(maxBytes, onEvicted)
+		},
+	}
+)
+
+// RegisterEvicter 注册淘汰策略，已存在的同名策略会被覆盖
+func RegisterEvicter(policy string, factory EvicterFactory) {
+	evictersMu.Lock()
+	defer evictersMu.Unlock()
+	evicters[policy] = factory
+}
+
+// EvicterPolicies 返回所有已注册的淘汰策略名称
+func EvicterPolicies() []string {
+	evictersMu.RLock()
+	defer evictersMu.RUnlock()
+	policies := make([]string, 0, len(evicters))
+	for policy := range evicters {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	return policies
+}
+
+// NewEvicter 根据策略名称创建淘汰策略，策略名称为空时使用 LRU
+func NewEvicter(policy string, maxBytes int64, onEvicted func(key string, value Value)) (Evicter, error) {
+	if policy == "" {
+		policy = PolicyLRU
+	}
+	evictersMu.RLock()
+	factory, ok := evicters[policy]
+	evictersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown evicter policy: %s", policy)
+	}
+	return factory(maxBytes, onEvicted), nil
+}
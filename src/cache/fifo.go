@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// FIFOCache 先进先出的淘汰策略，最先加入的数据最先被淘汰，访问数据不会改变淘汰顺序
+type FIFOCache struct {
+	maxBytes  int64                         // 允许使用的最大内存
+	nowBytes  int64                         // 当前已使用的内存
+	ll        *list.List                    // 双向链表，按照加入的顺序排列，队首为最新加入的数据
+	cache     map[string]*list.Element      // 哈希表，记录每个键对应的值在链表中的位置
+	OnEvicted func(key string, value Value) // 记录被删除时的回调函数，可选参数
+}
+
+// NewFIFO 实例化 FIFOCache
+func NewFIFO(maxBytes int64, onEvicted func(string, Value)) *FIFOCache {
+	return &FIFOCache{
+		maxBytes:  maxBytes,
+		ll:        list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Get 查找功能，不改变淘汰顺序
+func (c *FIFOCache) Get(key string) (value Value, ok bool) {
+	return c.Peek(key)
+}
+
+// Peek 查找功能
+func (c *FIFOCache) Peek(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return nil, false
+}
+
+// Add 新增/修改，修改不改变淘汰顺序，若发现已使用内存超过了最大内存，则淘汰最先加入的数据
+func (c *FIFOCache) Add(key string, value Value) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		c.nowBytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+	} else {
+		c.cache[key] = c.ll.PushFront(&entry{key, value})
+		c.nowBytes += int64(len(key)) + int64(value.Len())
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.nowBytes {
+		c.Remove()
+	}
+}
+
+// Remove 淘汰最先加入的数据
+func (c *FIFOCache) Remove() {
+	if ele := c.ll.Back(); ele != nil {
+		kv := ele.Value.(*entry)
+		c.Delete(kv.key)
+		if c.OnEvicted != nil {
+			c.OnEvicted(kv.key, kv.value)
+		}
+	}
+}
+
+// Delete 删除指定的键，返回该键是否存在
+func (c *FIFOCache) Delete(key string) bool {
+	ele, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	c.ll.Remove(ele)
+	delete(c.cache, key)
+	kv := ele.Value.(*entry)
+	c.nowBytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	return true
+}
+
+// Keys 返回所有的键，按照加入的顺序排列，最新加入的在前
+func (c *FIFOCache) Keys() []string {
+	keys := make([]string, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		keys = append(keys, ele.Value.(*entry).key)
+	}
+	return keys
+}
+
+// Len 返回缓存值的数量
+func (c *FIFOCache) Len() int {
+	return c.ll.Len()
+}
+
+// NowSize 返回已使用的内存
+func (c *FIFOCache) NowSize() int64 {
+	return c.nowBytes
+}
+
+// MaxCapacity 返回允许使用的最大内存
+func (c *FIFOCache) MaxCapacity() int64 {
+	return c.maxBytes
+}
+
+// SetMaxCapacity 设置允许使用的最大内存，为 0 时不限制，超出新的容量时立即淘汰最先加入的数据
+func (c *FIFOCache) SetMaxCapacity(maxCapacity int64) error {
+	if maxCapacity < 0 {
+		return fmt.Errorf("bad max capacity %d", maxCapacity)
+	}
+	c.maxBytes = maxCapacity
+	for c.maxBytes != 0 && c.maxBytes < c.nowBytes {
+		c.Remove()
+	}
+	return nil
+}
+
+// AdjustCapacity 在当前最大容量的基础上调整允许使用的最大内存
+func (c *FIFOCache) AdjustCapacity(delta int64) error {
+	return adjustCapacity(c, delta)
+}
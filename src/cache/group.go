@@ -1,14 +1,24 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	pb "jw-cache/src/cachepb"
 	"jw-cache/src/nodes"
+	"jw-cache/src/pgk/log"
 	"jw-cache/src/singleflight"
-	"log"
+	"jw-cache/src/trace"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrNotFound 数据不存在，Getter 可以返回该错误（或包装该错误）表示数据源中没有该数据
+var ErrNotFound = errors.New("not found")
+
 // Getter 回调函数，但在缓存中获取数据失败时，可以调用回调函数获取数据
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -28,36 +38,89 @@ type Group struct {
 	mainCache cache               // 缓存的具体实现，使用 lru.Cache 实现缓存淘汰策略
 	nodes     nodes.NodePicker    // 节点选择器，用于选择要缓存到哪个节点，从哪个节点获取数据，实现分布式缓存
 	loader    *singleflight.Group // 防止缓存击穿的实现，保证只有一个 goroutine 去加载缓存
+	encoding  Encoding            // 存入缓存时使用的压缩方式
+	threshold int                 // 大于等于该大小的值才会被压缩
+	stats     *groupStats         // 统计信息
+	logger    log.Logger          // 日志，每条日志都带有组名
+	ttl       time.Duration       // 通过 Getter 加载的值的过期时间，为 0 时永不过期
+	journal   atomic.Value        // 记录本地缓存修改的 Journal，保存的是 journalHolder
+}
+
+// journalHolder 用于在 atomic.Value 中保存 Journal 接口，Journal 可以为空
+type journalHolder struct {
+	Journal
 }
 
-// RegisterNodes 注册节点
-func (g *Group) RegisterNodes(nodes nodes.NodePicker) {
+// RegisterNodes 注册节点，每个组只能注册一次
+func (g *Group) RegisterNodes(nodes nodes.NodePicker) error {
 	if g.nodes != nil {
-		panic("RegisterNodePicker called more than once")
+		return fmt.Errorf("RegisterNodes called more than once for group %s", g.name)
 	}
 	g.nodes = nodes
+	return nil
 }
 
 // load 根据key加载缓存，会根据节点选择器选择节点，若选择到了节点，则会从该节点获取数据，否则会从回调函数中获取数据
-func (g *Group) load(key string) (value ByteView, err error) {
-	view, err := g.loader.Do(key, func() (interface{}, error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	return g.loadOnce(ctx, key, func(ctx context.Context) (interface{}, error) {
 		if g.nodes != nil {
 			if node, ok := g.nodes.PickNode(key); ok {
-				if value, err = g.GetFromNode(node, key); err == nil {
+				if value, err = g.getFromNode(ctx, node, key); err == nil {
+					g.stats.peerLoads.Add(1)
 					return value, nil
 				}
-				log.Println("[JWCache] Failed to get for node", err)
+				g.stats.peerErrors.Add(1)
+				g.logger.Warn("get from node failed", "key", key, "node", nodeName(node), "err", err)
 			}
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
+}
 
-	if err == nil {
-		return view.(ByteView), nil
+// loadOnce 通过 singleflight 执行 fn，同一个 key 同时只有一个 goroutine 在加载，span 记录等待的时间以及结果是否为共享的。
+// 加载的结果由所有等待的调用方共享，fn 收到的 context 只保留追踪上下文而不会被取消，
+// 每个调用方只等待自己的 ctx，取消后立即返回 ctx.Err()，加载在后台继续，完成后照常放入缓存
+func (g *Group) loadOnce(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (ByteView, error) {
+	g.stats.loads.Add(1)
+	_, span := trace.Start(ctx, "jwcache.singleflight")
+	defer span.End()
+	shared := detachedContext{ctx}
+	var res singleflight.Result
+	if ctx.Done() == nil {
+		// ctx 不会被取消时直接等待，不需要额外的 goroutine
+		res.Val, res.Err, res.Shared = g.loader.DoShared(key, func() (interface{}, error) { return fn(shared) })
+	} else {
+		select {
+		case res = <-g.loader.DoChan(key, func() (interface{}, error) { return fn(shared) }):
+		case <-ctx.Done():
+			span.RecordError(ctx.Err())
+			return ByteView{}, ctx.Err()
+		}
 	}
-	return
+	if res.Shared {
+		g.stats.dedupedLoads.Add(1)
+	}
+	if span.IsRecording() {
+		span.SetAttributes(trace.String("shared", strconv.FormatBool(res.Shared)))
+	}
+	if res.Err != nil {
+		span.RecordError(res.Err)
+		return ByteView{}, res.Err
+	}
+	return res.Val.(ByteView), nil
+}
+
+// detachedContext 保留父 context 中的值（包括追踪上下文），但不会被取消，也没有截止时间，
+// 用于被多个调用方共享的加载，避免第一个调用方断开连接时其他调用方也拿到取消的错误
+type detachedContext struct {
+	parent context.Context
 }
 
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
 // GetFromNode 从指定节点中获取数据
 //func (g *Group) GetFromNode(node nodes.NodeGetter, key string) (ByteView, error) {
 //	bytes, err := node.Get(g.name, key)
@@ -67,48 +130,336 @@ func (g *Group) load(key string) (value ByteView, err error) {
 //	return ByteView{bytes: bytes}, nil
 //}
 
+// nodeName 返回节点的名称，节点实现了 fmt.Stringer 时为节点的地址
+func nodeName(node nodes.NodeGetter) string {
+	if s, ok := node.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", node)
+}
+
 // GetFromNode 从指定节点中获取数据
 func (g *Group) GetFromNode(node nodes.NodeGetter, key string) (ByteView, error) {
+	return g.getFromNode(context.Background(), node, key)
+}
+
+// getFromNode 从指定节点中获取数据，节点实现了 nodes.ContextNodeGetter 时会将追踪上下文传递给该节点
+func (g *Group) getFromNode(ctx context.Context, node nodes.NodeGetter, key string) (ByteView, error) {
+	ctx, span := trace.Start(ctx, "jwcache.peer.get")
+	defer span.End()
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := node.Get(req, res)
+	var err error
+	if getter, ok := node.(nodes.ContextNodeGetter); ok {
+		err = getter.GetContext(ctx, req, res)
+	} else {
+		err = node.Get(req, res)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return ByteView{}, err
+	}
+	// 其他节点可能返回压缩后的数据，在这里解压并校验数据是否完整
+	bytes, err := decompress(Encoding(res.Encoding), res.Value)
 	if err != nil {
+		span.RecordError(err)
 		return ByteView{}, err
 	}
-	return ByteView{bytes: res.Value}, nil
+	return ByteView{bytes: bytes}, nil
 }
 
 // Get 根据key获取组内的值，若没有获取到，抛出异常
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同，ctx 中的追踪上下文会作为各个阶段 span 的父 span，并传递给其他节点
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
-	if v, ok := g.mainCache.get(key); ok {
-		// todo 为了方便测试，这里暂时不打印该日志
-		//log.Println("[JwCache] hit")
+	ctx, span := g.startGet(ctx, "jwcache.Group.Get", key)
+	defer span.End()
+	if v, ok := g.lookup(ctx, key); ok {
 		return v, nil
 	}
 	// 尝试从其他数据源获取
-	return g.load(key)
+	v, err := g.load(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return v, err
+}
+
+// GetLocal 根据key获取组内的值，若没有获取到，只会调用Getter获取数据，不会从其他节点获取
+func (g *Group) GetLocal(key string) (ByteView, error) {
+	return g.GetLocalContext(context.Background(), key)
+}
+
+// GetLocalContext 与 GetLocal 相同，ctx 中的追踪上下文会作为各个阶段 span 的父 span
+func (g *Group) GetLocalContext(ctx context.Context, key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	ctx, span := g.startGet(ctx, "jwcache.Group.GetLocal", key)
+	defer span.End()
+	if v, ok := g.lookup(ctx, key); ok {
+		return v, nil
+	}
+	v, err := g.loadOnce(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return g.getLocally(ctx, key)
+	})
+	if err != nil {
+		span.RecordError(err)
+	}
+	return v, err
+}
+
+// GetLocalUncachedContext 与 GetLocalContext 相同，但未命中时 Getter 加载的值只返回给调用方，不会放入缓存，
+// 也不会与其他加载合并。用于当前节点不是 key 的所有者时的读取，例如对冲请求，避免在非所有者上缓存一份副本
+func (g *Group) GetLocalUncachedContext(ctx context.Context, key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	ctx, span := g.startGet(ctx, "jwcache.Group.GetLocalUncached", key)
+	defer span.End()
+	if v, ok := g.lookup(ctx, key); ok {
+		return v, nil
+	}
+	g.stats.loads.Add(1)
+	v, err := g.loadLocally(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return v, err
+}
+
+// startGet 创建读取操作的 span，只有在记录数据时才设置属性，避免命中时产生额外的内存分配
+func (g *Group) startGet(ctx context.Context, name, key string) (context.Context, trace.Span) {
+	ctx, span := trace.Start(ctx, name)
+	if span.IsRecording() {
+		span.SetAttributes(trace.String("group", g.name), trace.String("key", key))
+	}
+	return ctx, span
+}
+
+// lookup 从本地缓存中查找，span 记录等待分片锁以及查找的时间
+func (g *Group) lookup(ctx context.Context, key string) (ByteView, bool) {
+	_, span := trace.Start(ctx, "jwcache.cache.lookup")
+	defer span.End()
+	g.stats.gets.Add(1)
+	v, ok := g.mainCache.get(key)
+	if !ok {
+		// 本地缓存未命中时先从二级缓存中取出，命中后放回本地缓存，不需要从其他节点或者 Getter 加载
+		if v, ok = g.mainCache.promote(key); ok {
+			g.stats.l2Hits.Add(1)
+		}
+	}
+	if ok {
+		g.stats.hits.Add(1)
+	}
+	if span.IsRecording() {
+		span.SetAttributes(trace.String("hit", strconv.FormatBool(ok)))
+	}
+	return v, ok
 }
 
 // 调用Getter从其他数据源获取数据，若获取到数据，将该数据存入缓存中
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	value, err := g.loadLocally(ctx, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	g.mainCache.add(key, value)
+	return value, nil
+}
+
+// loadLocally 调用Getter从其他数据源获取数据，返回与存入缓存时相同编码的值，但不存入缓存
+func (g *Group) loadLocally(ctx context.Context, key string) (ByteView, error) {
+	_, span := trace.Start(ctx, "jwcache.getter.load")
+	defer span.End()
 	bytes, err := g.getter.Get(key)
 	if err != nil {
+		span.RecordError(err)
+		g.stats.loadErrors.Add(1)
 		return ByteView{}, err
 	}
-	value := ByteView{bytes: cloneBytes(bytes)}
-	g.populateCache(key, value)
+	g.stats.localLoads.Add(1)
+	// 返回实际存入缓存的值，保证未命中和命中时发送给其他节点的数据编码一致
+	value := ByteView{bytes: bytes}
+	if g.ttl > 0 {
+		value.expire = time.Now().Add(g.ttl)
+	}
+	value = g.compress(value)
+	if value.encoding == EncodingIdentity {
+		// Getter 可能会复用返回的切片，未压缩时需要拷贝一份，压缩后的数据本身就是新分配的
+		value.bytes = cloneBytes(bytes)
+	}
 	return value, nil
 }
 
-// populateCache 将获取到的数据存入缓存中
-func (g *Group) populateCache(key string, value ByteView) {
+// Set 将值写入缓存，ttl 为 0 时表示永不过期，若该 key 属于其他节点，则写入该节点
+func (g *Group) Set(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if writer, ok := g.pickWriter(key); ok {
+		return writer.Set(g.name, key, value, ttl)
+	}
+	return g.SetLocal(key, value, ttl)
+}
+
+// SetLocal 将值写入当前节点的缓存，ttl 为 0 时表示永不过期
+func (g *Group) SetLocal(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	view := ByteView{bytes: cloneBytes(value)}
+	if ttl > 0 {
+		view.expire = time.Now().Add(ttl)
+	}
+	j := g.getJournal()
+	if j == nil {
+		g.populateCache(key, view)
+		return nil
+	}
+	view = g.compress(view)
+	err := g.mainCache.addLogged(key, view, func() error {
+		return j.Append(JournalSet, g.name, key, view)
+	})
+	if err != nil {
+		return err
+	}
+	return flushJournal(j)
+}
+
+// Delete 删除缓存中的值，若该 key 属于其他节点，则同时删除该节点中的值
+func (g *Group) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if err := g.deleteLocally(key); err != nil {
+		return err
+	}
+	if writer, ok := g.pickWriter(key); ok {
+		return writer.Delete(g.name, key)
+	}
+	return nil
+}
+
+// DeleteLocal 删除当前节点缓存中的值
+func (g *Group) DeleteLocal(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	return g.deleteLocally(key)
+}
+
+// deleteLocally 删除本地缓存中的值，设置了 Journal 时同时记录删除操作
+func (g *Group) deleteLocally(key string) error {
+	j := g.getJournal()
+	if j == nil {
+		g.mainCache.delete(key)
+		return nil
+	}
+	err := g.mainCache.deleteLogged(key, func() error {
+		return j.Append(JournalDelete, g.name, key, ByteView{})
+	})
+	if err != nil {
+		return err
+	}
+	return flushJournal(j)
+}
+
+// flushJournal 释放分片写锁之后等待 Journal 中的记录同步到磁盘，Journal 没有实现 JournalFlusher 时直接返回
+func flushJournal(j Journal) error {
+	if f, ok := j.(JournalFlusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// SetJournal 设置记录本地缓存修改的 Journal，之后通过 SetLocal、DeleteLocal 写入和删除的值，
+// 以及读取时发现过期而删除的值都会被记录，j 为空时不再记录。通过 Getter 或其他节点加载的值不会被记录
+func (g *Group) SetJournal(j Journal) {
+	g.journal.Store(journalHolder{j})
+}
+
+func (g *Group) getJournal() Journal {
+	h, _ := g.journal.Load().(journalHolder)
+	return h.Journal
+}
+
+// logExpired 记录读取时因为过期而被删除的值，失败时只记录日志，不影响读取。
+// 重放时过期的值本来就会被跳过，这里不等待同步，避免读取被磁盘同步阻塞
+func (g *Group) logExpired(key string) {
+	if j := g.getJournal(); j != nil {
+		if err := j.Append(JournalExpire, g.name, key, ByteView{}); err != nil {
+			g.logger.Warn("append expire to journal failed", "key", key, "err", err)
+		}
+	}
+}
+
+// pickWriter 选择该 key 所属的远程节点，节点需要支持写入操作
+func (g *Group) pickWriter(key string) (nodes.NodeWriter, bool) {
+	if g.nodes == nil {
+		return nil, false
+	}
+	node, ok := g.nodes.PickNode(key)
+	if !ok {
+		return nil, false
+	}
+	writer, ok := node.(nodes.NodeWriter)
+	return writer, ok
+}
+
+// Name 返回组名
+func (g *Group) Name() string {
+	return g.name
+}
+
+// CacheBytes 返回本地缓存的最大容量，为 0 时不限制
+func (g *Group) CacheBytes() int64 {
+	return g.mainCache.capacity()
+}
+
+// SetCacheBytes 在运行时修改本地缓存的最大容量，为 0 时不限制，容量变小时立即淘汰数据直到不超过新的容量。
+// 分片的数量在创建分组时已经确定，不会随容量变化
+func (g *Group) SetCacheBytes(cacheBytes int64) error {
+	return g.mainCache.setCapacity(cacheBytes)
+}
+
+// L2 返回分组的二级缓存，没有开启时为空
+func (g *Group) L2() *DiskTier {
+	return g.mainCache.l2
+}
+
+// Keys 返回当前节点缓存中所有未过期的键，不包括二级缓存中的键
+func (g *Group) Keys() []string {
+	return g.mainCache.keys()
+}
+
+// populateCache 将获取到的数据存入缓存中，开启压缩时会先压缩超过阈值的数据，返回存入缓存的值
+func (g *Group) populateCache(key string, value ByteView) ByteView {
+	value = g.compress(value)
 	g.mainCache.add(key, value)
+	return value
+}
+
+// compress 压缩超过阈值的数据，压缩失败或压缩后没有变小时保留原始数据
+func (g *Group) compress(value ByteView) ByteView {
+	if g.encoding == EncodingIdentity || value.encoding != EncodingIdentity || len(value.bytes) < g.threshold {
+		return value
+	}
+	compressed, err := compress(g.encoding, value.bytes)
+	if err != nil || len(compressed) >= len(value.bytes) {
+		return value
+	}
+	value.bytes, value.encoding = compressed, g.encoding
+	return value
 }
 
 var (
@@ -116,21 +467,85 @@ var (
 	groups = make(map[string]*Group)
 )
 
-// NewGroup 创建分组
+// GroupOptions 分组的配置项，零值表示使用默认配置
+type GroupOptions struct {
+	Evicter           string        // Evicter 本地缓存的淘汰策略名称，默认为 LRU
+	Compression       string        // Compression 值的压缩方式，可选 "gzip" 和 "flate"，默认不压缩
+	CompressThreshold int           // CompressThreshold 大于等于该大小的值才会被压缩，默认为 1KB
+	Shards            int           // Shards 本地缓存的分片数量，会向下取整为 2 的幂，默认为 16，容量较小时会自动减少
+	Logger            log.Logger    // Logger 分组的日志，默认为 log.Default()
+	TTL               time.Duration // TTL 通过 Getter 加载的值的过期时间，默认永不过期，通过 Set 写入的值使用各自的过期时间
+	L2                *DiskTier     // L2 本地缓存之下的二级缓存，因为容量不足淘汰的值写入其中，默认不开启，每个分组需要使用单独的 DiskTier
+}
+
+// NewGroup 创建分组，使用默认的配置项
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return NewGroupOpts(name, cacheBytes, getter, nil)
+}
+
+// NewGroupOpts 根据配置项创建分组，opts 为空时使用默认的配置项，配置项错误时 panic，见 NewGroupE
+func NewGroupOpts(name string, cacheBytes int64, getter Getter, opts *GroupOptions) *Group {
+	g, err := NewGroupE(name, cacheBytes, getter, opts)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// NewGroupE 与 NewGroupOpts 相同，但 getter 为空、淘汰策略或压缩方式不存在等错误通过 error 返回，
+// 用于根据配置文件创建分组，返回错误时不会注册分组
+func NewGroupE(name string, cacheBytes int64, getter Getter, opts *GroupOptions) (*Group, error) {
 	if getter == nil {
-		panic("空的 Getter")
+		return nil, fmt.Errorf("getter of group %s is required", name)
+	}
+	if opts == nil {
+		opts = &GroupOptions{}
+	}
+	stats := &groupStats{}
+	mainCache, err := newCache(cacheBytes, opts.Evicter, opts.Shards, stats, opts.L2)
+	if err != nil {
+		return nil, fmt.Errorf("group %s: %w", name, err)
+	}
+	encoding, err := ParseCompression(opts.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("group %s: %w", name, err)
+	}
+	threshold := opts.CompressThreshold
+	if threshold <= 0 {
+		threshold = defaultCompressThreshold
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
 		name:      name,
 		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
+		mainCache: mainCache,
 		loader:    &singleflight.Group{},
+		encoding:  encoding,
+		threshold: threshold,
+		stats:     stats,
+		logger:    log.With(logger, "group", name),
+		ttl:       opts.TTL,
 	}
+	g.mainCache.onExpired = g.logExpired
 	groups[name] = g
-	return g
+	return g, nil
+}
+
+// GroupNames 返回所有分组的名称，按照字典序排列
+func GroupNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // GetGroup 根据name获取分组
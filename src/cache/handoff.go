@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportEntries 将本地缓存中 keep 接受的值按照快照的格式写入 w，用于哈希环变化时将值交接给新的节点。
+// 与 WriteSnapshot 相同，每次只持有一个分片的读锁，写入 w 时不持有锁，w 较慢时不会阻塞分组的读写
+func (g *Group) ExportEntries(w io.Writer, keep func(key string) bool) (SnapshotStats, error) {
+	return writeSnapshot(w, keep, g)
+}
+
+// TrackWrites 开始记录当前节点写入或删除的键，直到调用返回的 stop，期间 ImportEntries 不会覆盖这些键。
+// 在请求旧节点导出之前调用，交接期间在当前节点删除的键不会被旧节点的值恢复
+func (g *Group) TrackWrites() (stop func()) {
+	return g.mainCache.trackWrites()
+}
+
+// ImportEntries 读取 ExportEntries 写入的数据，写入本地缓存，已经过期的值、其他分组的值、
+// 本地缓存（包括二级缓存）中已经存在的值，以及 TrackWrites 之后在当前节点写入或删除过的键会被跳过：
+// 交接期间当前节点的写入和删除比交接的值更新。ImportEntries 执行期间同样会记录。
+// 数据一边读取一边写入，读取失败时已经读取的值仍然有效；设置了 Journal 时写入的值同样会被记录
+func (g *Group) ImportEntries(r io.Reader) (SnapshotStats, error) {
+	stop := g.TrackWrites()
+	defer stop()
+	now := time.Now()
+	skipped := 0
+	var logErr error
+	j := g.getJournal()
+	stats, err := readSnapshot(r, func(group, key string, value ByteView) {
+		if group != g.name || (!value.expire.IsZero() && now.After(value.expire)) || logErr != nil {
+			skipped++
+			return
+		}
+		var log func() error
+		if j != nil {
+			log = func() error { return j.Append(JournalSet, g.name, key, value) }
+		}
+		added, err := g.mainCache.addAbsent(key, value, log)
+		if err != nil {
+			logErr = fmt.Errorf("append %s to journal: %w", key, err)
+		}
+		if !added {
+			skipped++
+		}
+	})
+	stats.Entries -= skipped
+	stats.Skipped = skipped
+	if err == nil {
+		err = logErr
+	}
+	if j != nil && stats.Entries > 0 {
+		// 所有的值写入之后只同步一次
+		if flushErr := flushJournal(j); err == nil {
+			err = flushErr
+		}
+	}
+	return stats, err
+}
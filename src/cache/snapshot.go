@@ -0,0 +1,428 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"jw-cache/src/pgk/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 快照文件的格式，整数均为小端序，变长整数为 uvarint：
+//
+//	头部      "JWCS" | 版本(2) | 创建时间(8，UnixNano)
+//	分组记录  0x01 | 组名长度 | 组名
+//	数据记录  0x02 | 键的长度 | 键 | 编码方式(1) | 过期时间(8，UnixNano，0 表示永不过期) | 值的长度 | 值
+//	结束记录  0xff | 校验和(4，CRC-32C，覆盖结束记录之前的所有字节)
+//
+// 数据记录属于前面最近的分组记录，同一个分组中的数据按照淘汰顺序排列，最先被淘汰的在前
+const (
+	snapshotMagic   = "JWCS"
+	snapshotVersion = 1 // 表示当前的快照格式版本，格式不兼容时递增
+
+	recordGroup byte = 0x01
+	recordEntry byte = 0x02
+	recordEnd   byte = 0xff
+
+	maxRecordBytes = 1 << 30 // 表示键或值的最大长度，避免损坏的长度字段导致分配过多的内存
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBadSnapshot 快照文件损坏、被截断或者版本不支持
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+// SnapshotStats 保存或恢复一次快照的结果
+type SnapshotStats struct {
+	Groups  int       // Groups 快照中的分组数量
+	Entries int       // Entries 保存或恢复的缓存值数量
+	Skipped int       // Skipped 恢复时跳过的缓存值数量，包括已经过期的值和不存在的分组中的值
+	Bytes   int64     // Bytes 快照的大小
+	Created time.Time // Created 快照的创建时间
+}
+
+// snapshotWriter 写入快照的字段，同时计算校验和，出错后忽略之后的写入
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	return &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.New(crcTable)}
+}
+
+func (w *snapshotWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc.Write(p)
+	var n int
+	n, w.err = w.w.Write(p)
+	w.n += int64(n)
+}
+
+func (w *snapshotWriter) byte(b byte) {
+	w.buf[0] = b
+	w.write(w.buf[:1])
+}
+
+func (w *snapshotWriter) uvarint(v uint64) {
+	w.write(w.buf[:binary.PutUvarint(w.buf[:], v)])
+}
+
+func (w *snapshotWriter) int64(v int64) {
+	binary.LittleEndian.PutUint64(w.buf[:], uint64(v))
+	w.write(w.buf[:8])
+}
+
+func (w *snapshotWriter) bytes(p []byte) {
+	w.uvarint(uint64(len(p)))
+	w.write(p)
+}
+
+// entry 写入一条数据记录
+func (w *snapshotWriter) entry(key string, value ByteView) {
+	w.byte(recordEntry)
+	w.bytes([]byte(key))
+	w.byte(byte(value.encoding))
+	var expire int64
+	if !value.expire.IsZero() {
+		expire = value.expire.UnixNano()
+	}
+	w.int64(expire)
+	w.bytes(value.bytes)
+}
+
+// finish 写入结束记录和校验和
+func (w *snapshotWriter) finish() error {
+	w.byte(recordEnd)
+	binary.LittleEndian.PutUint32(w.buf[:], w.crc.Sum32())
+	if w.err == nil {
+		_, w.err = w.w.Write(w.buf[:4])
+		w.n += 4
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// WriteSnapshot 将分组的本地缓存写入 w，已经过期的值不会被写入。
+// 每次只持有一个分片的读锁，写入快照期间分组可以正常读写，快照中每个分片的数据是一致的
+func WriteSnapshot(w io.Writer, groups ...*Group) (SnapshotStats, error) {
+	return writeSnapshot(w, nil, groups...)
+}
+
+// writeSnapshot 将分组中 keep 接受的值写入 w，keep 为空时写入所有的值
+func writeSnapshot(w io.Writer, keep func(key string) bool, groups ...*Group) (SnapshotStats, error) {
+	stats := SnapshotStats{Created: time.Now()}
+	sw := newSnapshotWriter(w)
+	sw.write([]byte(snapshotMagic))
+	binary.LittleEndian.PutUint16(sw.buf[:], snapshotVersion)
+	sw.write(sw.buf[:2])
+	sw.int64(stats.Created.UnixNano())
+	for _, g := range groups {
+		sw.byte(recordGroup)
+		sw.bytes([]byte(g.name))
+		stats.Groups++
+		g.mainCache.each(func(key string, value ByteView) {
+			if keep != nil && !keep(key) {
+				return
+			}
+			sw.entry(key, value)
+			stats.Entries++
+		})
+		if sw.err != nil {
+			return stats, sw.err
+		}
+	}
+	err := sw.finish()
+	stats.Bytes = sw.n
+	return stats, err
+}
+
+// snapshotReader 读取快照的字段，同时计算已经读取的字节的校验和
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	buf [8]byte
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	return &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(crcTable)}
+}
+
+// ReadByte 实现 io.ByteReader，用于 binary.ReadUvarint
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.buf[0] = b
+		r.crc.Write(r.buf[:1])
+	}
+	return b, err
+}
+
+func (r *snapshotReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		return err
+	}
+	r.crc.Write(p)
+	return nil
+}
+
+func (r *snapshotReader) int64() (int64, error) {
+	if err := r.readFull(r.buf[:8]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(r.buf[:8])), nil
+}
+
+func (r *snapshotReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRecordBytes {
+		return nil, fmt.Errorf("%w: record of %d bytes is too large", ErrBadSnapshot, n)
+	}
+	p := make([]byte, n)
+	return p, r.readFull(p)
+}
+
+// entry 读取一条数据记录中记录类型之后的部分
+func (r *snapshotReader) entry() (string, ByteView, error) {
+	key, err := r.bytes()
+	if err != nil {
+		return "", ByteView{}, err
+	}
+	encoding, err := r.ReadByte()
+	if err != nil {
+		return "", ByteView{}, err
+	}
+	if Encoding(encoding) > EncodingFlate {
+		return "", ByteView{}, fmt.Errorf("%w: unknown encoding %d", ErrBadSnapshot, encoding)
+	}
+	expire, err := r.int64()
+	if err != nil {
+		return "", ByteView{}, err
+	}
+	value, err := r.bytes()
+	if err != nil {
+		return "", ByteView{}, err
+	}
+	view := ByteView{bytes: value, encoding: Encoding(encoding)}
+	if expire != 0 {
+		view.expire = time.Unix(0, expire)
+	}
+	return string(key), view, nil
+}
+
+// readSnapshot 读取快照，对每一条数据调用 apply，apply 为空时只校验快照
+func readSnapshot(r io.Reader, apply func(group, key string, value ByteView)) (stats SnapshotStats, err error) {
+	defer func() {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("%w: truncated", ErrBadSnapshot)
+		}
+	}()
+	sr := newSnapshotReader(r)
+	header := make([]byte, len(snapshotMagic)+2)
+	if err := sr.readFull(header); err != nil {
+		return stats, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return stats, fmt.Errorf("%w: not a snapshot file", ErrBadSnapshot)
+	}
+	if v := binary.LittleEndian.Uint16(header[len(snapshotMagic):]); v != snapshotVersion {
+		return stats, fmt.Errorf("%w: unsupported version %d, want %d", ErrBadSnapshot, v, snapshotVersion)
+	}
+	created, err := sr.int64()
+	if err != nil {
+		return stats, err
+	}
+	stats.Created = time.Unix(0, created)
+	group := ""
+	for {
+		t, err := sr.ReadByte()
+		if err != nil {
+			return stats, err
+		}
+		switch t {
+		case recordGroup:
+			name, err := sr.bytes()
+			if err != nil {
+				return stats, err
+			}
+			group = string(name)
+			stats.Groups++
+		case recordEntry:
+			key, value, err := sr.entry()
+			if err != nil {
+				return stats, err
+			}
+			if group == "" {
+				return stats, fmt.Errorf("%w: entry before any group", ErrBadSnapshot)
+			}
+			if apply != nil {
+				apply(group, key, value)
+			}
+			stats.Entries++
+		case recordEnd:
+			sum := sr.crc.Sum32()
+			if _, err := io.ReadFull(sr.r, sr.buf[:4]); err != nil {
+				return stats, err
+			}
+			if binary.LittleEndian.Uint32(sr.buf[:4]) != sum {
+				return stats, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+			}
+			return stats, nil
+		default:
+			return stats, fmt.Errorf("%w: unknown record type %#x", ErrBadSnapshot, t)
+		}
+	}
+}
+
+// SaveSnapshot 将分组的本地缓存保存到 path，先写入同一目录下的临时文件，同步到磁盘后再重命名，
+// 保存失败或者节点崩溃时不会破坏已有的快照
+func SaveSnapshot(path string, groups ...*Group) (stats SnapshotStats, err error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return stats, err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if stats, err = WriteSnapshot(f, groups...); err != nil {
+		return stats, err
+	}
+	if err = f.Sync(); err != nil {
+		return stats, err
+	}
+	if err = f.Close(); err != nil {
+		return stats, err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return stats, err
+	}
+	return stats, syncDir(dir)
+}
+
+// syncDir 将目录同步到磁盘，保证重命名在崩溃后仍然有效
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// LoadSnapshot 从 path 恢复快照，写入已经创建的同名分组，快照中不存在的分组和已经过期的值会被跳过。
+// 先校验整个文件再写入分组，快照损坏时返回 ErrBadSnapshot，分组不会被修改；文件不存在时返回的错误满足 os.IsNotExist
+func LoadSnapshot(path string) (SnapshotStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SnapshotStats{}, err
+	}
+	defer f.Close()
+	if _, err := readSnapshot(f, nil); err != nil {
+		return SnapshotStats{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return SnapshotStats{}, err
+	}
+	now := time.Now()
+	skipped := 0
+	var last *Group
+	stats, err := readSnapshot(f, func(group, key string, value ByteView) {
+		if last == nil || last.name != group {
+			last = GetGroup(group)
+		}
+		if last == nil || (!value.expire.IsZero() && now.After(value.expire)) {
+			skipped++
+			return
+		}
+		last.mainCache.add(key, value)
+	})
+	stats.Entries -= skipped
+	stats.Skipped = skipped
+	if info, err := f.Stat(); err == nil {
+		stats.Bytes = info.Size()
+	}
+	return stats, err
+}
+
+// Snapshotter 将所有分组保存到同一个快照文件，定时任务、接口和关闭节点可能同时触发保存，保存会依次执行
+type Snapshotter struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewSnapshotter 新建 Snapshotter，path 为快照文件的路径
+func NewSnapshotter(path string) *Snapshotter {
+	return &Snapshotter{path: path}
+}
+
+// Path 返回快照文件的路径
+func (s *Snapshotter) Path() string {
+	return s.path
+}
+
+// Save 将所有分组保存到快照文件
+func (s *Snapshotter) Save() (SnapshotStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := GroupNames()
+	groups := make([]*Group, 0, len(names))
+	for _, name := range names {
+		if g := GetGroup(name); g != nil {
+			groups = append(groups, g)
+		}
+	}
+	return SaveSnapshot(s.path, groups...)
+}
+
+// Restore 从快照文件恢复所有分组，需要在分组创建之后、加入哈希环之前调用
+func (s *Snapshotter) Restore() (SnapshotStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return LoadSnapshot(s.path)
+}
+
+// Run 每隔 interval 保存一次快照，ctx 结束后返回
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		stats, err := s.Save()
+		if err != nil {
+			log.Error("save snapshot failed", "path", s.path, "err", err)
+			continue
+		}
+		log.Info("snapshot saved", "path", s.path, "groups", stats.Groups, "entries", stats.Entries,
+			"bytes", stats.Bytes, "cost", time.Since(start))
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import "sync/atomic"
+
+// Stats 分组的统计信息
+type Stats struct {
+	Gets            int64 // Gets 获取值的次数，包括其他节点的请求
+	Hits            int64 // Hits 本地缓存命中的次数，包括二级缓存命中的次数
+	L2Hits          int64 // L2Hits 本地缓存未命中，但二级缓存命中的次数
+	Loads           int64 // Loads 本地缓存未命中，需要加载值的次数
+	DedupedLoads    int64 // DedupedLoads 被 singleflight 合并，复用了其他请求结果的加载次数
+	PeerLoads       int64 // PeerLoads 从其他节点成功获取值的次数
+	PeerErrors      int64 // PeerErrors 从其他节点获取值失败的次数
+	LocalLoads      int64 // LocalLoads 通过 Getter 成功加载值的次数
+	LoadErrors      int64 // LoadErrors 通过 Getter 加载值失败的次数
+	EvictedCapacity int64 // EvictedCapacity 因为容量不足被淘汰的值的数量
+	EvictedExpired  int64 // EvictedExpired 因为过期被删除的值的数量
+	InFlightLoads   int   // InFlightLoads 正在进行中的加载数量，相同 key 的并发加载只计算一次
+	Items           int   // Items 本地缓存中值的数量
+	Bytes           int64 // Bytes 本地缓存占用的大小
+	MaxBytes        int64 // MaxBytes 本地缓存的最大容量
+	L2Items         int   // L2Items 二级缓存中值的数量
+	L2Bytes         int64 // L2Bytes 二级缓存段文件的大小
+	L2MaxBytes      int64 // L2MaxBytes 二级缓存的最大容量，没有开启二级缓存时为 0
+}
+
+// groupStats 分组的计数器，所有字段都通过原子操作更新
+type groupStats struct {
+	gets            atomic.Int64
+	hits            atomic.Int64
+	l2Hits          atomic.Int64
+	loads           atomic.Int64
+	dedupedLoads    atomic.Int64
+	peerLoads       atomic.Int64
+	peerErrors      atomic.Int64
+	localLoads      atomic.Int64
+	loadErrors      atomic.Int64
+	evictedCapacity atomic.Int64
+	evictedExpired  atomic.Int64
+}
+
+// Stats 返回分组当前统计信息的快照，各个计数器分别读取，彼此之间不保证一致
+func (g *Group) Stats() Stats {
+	items, bytes := g.mainCache.stats()
+	var l2 TierStats
+	if g.mainCache.l2 != nil {
+		l2 = g.mainCache.l2.Stats()
+	}
+	return Stats{
+		Gets:            g.stats.gets.Load(),
+		Hits:            g.stats.hits.Load(),
+		L2Hits:          g.stats.l2Hits.Load(),
+		Loads:           g.stats.loads.Load(),
+		DedupedLoads:    g.stats.dedupedLoads.Load(),
+		PeerLoads:       g.stats.peerLoads.Load(),
+		PeerErrors:      g.stats.peerErrors.Load(),
+		LocalLoads:      g.stats.localLoads.Load(),
+		LoadErrors:      g.stats.loadErrors.Load(),
+		EvictedCapacity: g.stats.evictedCapacity.Load(),
+		EvictedExpired:  g.stats.evictedExpired.Load(),
+		InFlightLoads:   g.loader.InFlight(),
+		Items:           items,
+		Bytes:           bytes,
+		MaxBytes:        g.mainCache.capacity(),
+		L2Items:         l2.Items,
+		L2Bytes:         l2.Bytes,
+		L2MaxBytes:      l2.MaxBytes,
+	}
+}
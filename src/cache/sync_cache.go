@@ -1,30 +1,393 @@
 package cache
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
 
+const (
+	defaultShards  = 16      // 表示默认的分片数量
+	minShardBytes  = 1 << 20 // 表示每个分片的最小容量，容量较小时减少分片数量，避免较大的值放不进分片
+	readBufferSize = 64      // 表示每个读缓冲区记录多少次访问后批量更新淘汰策略
+	readStripes    = 8       // 表示每个分片的读缓冲区数量，不同 key 的访问记录写入不同的缓冲区，减少竞争
+)
+
+// cache 并发安全的本地缓存，按照 key 的哈希值分成多个分片，每个分片有独立的锁和淘汰策略，
+// 不同分片之间的读写互不影响
 type cache struct {
-	mu         sync.Mutex
-	cache      *Cache
-	cacheBytes int64
+	shards     []*shard         // 分片，数量为 2 的幂
+	cacheBytes int64            // 所有分片的总容量，为 0 时表示不限制，通过 capacity 和 setCapacity 原子地读写
+	counters   *groupStats      // 所属分组的统计信息，用于记录淘汰的次数
+	onExpired  func(key string) // 读取时发现值已经过期并删除后调用，在持有分片写锁时调用，可以为空
+	l2         *DiskTier        // 二级缓存，因为容量不足淘汰的值写入其中，可以为空
+}
+
+// shard 缓存的一个分片，命中时只持有读锁，访问记录先写入读缓冲区，缓冲区满了之后再持有写锁批量更新淘汰策略
+type shard struct {
+	mu       sync.RWMutex
+	cache    Evicter
+	reads    [readStripes]readBuffer // 读缓冲区，根据 key 的哈希值选择
+	tracking int                     // 正在记录写入的导入数量，见 trackWrites
+	touched  map[string]struct{}     // 记录期间写入或删除的键，导入的值不会覆盖这些键，没有记录时为空
+}
+
+// touch 记录写入或删除了 key，需要持有分片写锁
+func (s *shard) touch(key string) {
+	if s.touched != nil {
+		s.touched[key] = struct{}{}
+	}
+}
+
+// readBuffer 记录命中的 key，用于批量更新淘汰策略的访问记录
+type readBuffer struct {
+	mu   sync.Mutex
+	keys [readBufferSize]string
+	n    int // 已经记录的访问次数
+}
+
+// newCache 创建缓存，总容量平均分配给每个分片，shards 会向下取整为 2 的幂。l2 不为空时，
+// 因为容量不足淘汰的值在持有分片写锁时写入 l2，写入失败只记录在 l2 的统计信息中
+func newCache(cacheBytes int64, policy string, shards int, stats *groupStats, l2 *DiskTier) (cache, error) {
+	if policy == PolicyArena && cacheBytes <= 0 {
+		// arena 为每个分片预先分配内存，不限制容量时每个分片都会分配默认的大小
+		return cache{}, fmt.Errorf("evicter %s requires a positive cache size", policy)
+	}
+	if shards <= 0 {
+		shards = defaultShards
+	}
+	if cacheBytes > 0 && int64(shards) > cacheBytes/minShardBytes {
+		shards = int(cacheBytes / minShardBytes)
+	}
+	n := 1
+	for n*2 <= shards {
+		n *= 2
+	}
+	c := cache{shards: make([]*shard, n), cacheBytes: cacheBytes, counters: stats, l2: l2}
+	onEvicted := func(key string, value Value) {
+		stats.evictedCapacity.Add(1)
+		if l2 != nil {
+			l2.Put(key, value.(ByteView))
+		}
+	}
+	for i := range c.shards {
+		evicter, err := NewEvicter(policy, shardBytes(cacheBytes, n, i), onEvicted)
+		if err != nil {
+			return cache{}, err
+		}
+		c.shards[i] = &shard{cache: evicter}
+	}
+	return c, nil
+}
+
+// shardBytes 返回第 i 个分片的容量，容量不能整除时，余数分配给前面的分片
+func shardBytes(cacheBytes int64, shards, i int) int64 {
+	maxBytes := cacheBytes / int64(shards)
+	if int64(i) < cacheBytes%int64(shards) {
+		maxBytes++
+	}
+	return maxBytes
+}
+
+// capacity 返回所有分片的总容量
+func (c *cache) capacity() int64 {
+	return atomic.LoadInt64(&c.cacheBytes)
+}
+
+// setCapacity 修改所有分片的总容量，分片的数量不变，容量变小时每个分片立即淘汰数据直到不超过新的容量，
+// 淘汰策略需要实现 CapacitySetter
+func (c *cache) setCapacity(cacheBytes int64) error {
+	if cacheBytes < 0 {
+		return fmt.Errorf("bad cache bytes %d", cacheBytes)
+	}
+	if cacheBytes > 0 && cacheBytes < int64(len(c.shards)) {
+		// 分片的容量为 0 时表示不限制，不能把容量分配成 0
+		return fmt.Errorf("cache bytes %d is less than the number of shards %d", cacheBytes, len(c.shards))
+	}
+	for _, s := range c.shards {
+		if _, ok := s.cache.(CapacitySetter); !ok {
+			return fmt.Errorf("evicter %T does not support changing capacity", s.cache)
+		}
+		if _, ok := s.cache.(*ArenaCache); ok && cacheBytes == 0 {
+			return fmt.Errorf("evicter %s requires a positive cache size", PolicyArena)
+		}
+	}
+	for i, s := range c.shards {
+		s.mu.Lock()
+		err := s.cache.(CapacitySetter).SetMaxCapacity(shardBytes(cacheBytes, len(c.shards), i))
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt64(&c.cacheBytes, cacheBytes)
+	return nil
+}
+
+// shard 根据 key 的哈希值选择分片
+func (c *cache) shard(key string) *shard {
+	s, _ := c.locate(key)
+	return s
+}
+
+// locate 返回 key 所在的分片以及 key 的哈希值
+func (c *cache) locate(key string) (*shard, uint64) {
+	h := hashKey(key)
+	return c.shards[h&uint64(len(c.shards)-1)], h
+}
+
+// hashKey 计算 key 的 FNV-1a 哈希值，直接在字符串上计算，避免转换成 []byte 时的内存分配
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
 }
 
 func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.cache == nil {
-		c.cache = New(c.cacheBytes, nil)
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(key)
+	c.dropL2(key)
+	s.cache.Add(key, value)
+}
+
+// addLogged 在持有分片写锁时先调用 log，成功后再写入缓存，同一个 key 的日志顺序与写入缓存的顺序相同，
+// log 失败时缓存不变
+func (c *cache) addLogged(key string, value ByteView, log func() error) error {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := log(); err != nil {
+		return err
+	}
+	s.touch(key)
+	c.dropL2(key)
+	s.cache.Add(key, value)
+	return nil
+}
+
+// dropL2 删除 l2 中的旧值，需要持有 key 所在分片的写锁。同一个 key 最多只在缓存和 l2 的其中一个中，
+// 缓存中的值过期或被删除之后不会再读到 l2 中更旧的值
+func (c *cache) dropL2(key string) {
+	if c.l2 != nil {
+		c.l2.Delete(key)
 	}
-	c.cache.Add(key, value)
 }
 
+// promote 缓存未命中时从 l2 中取出值并放回缓存。先在不持有分片锁时读取磁盘，再持有分片写锁确认 l2 中的记录没有变化后
+// 从 l2 中删除并放回缓存，不会与同一个 key 的写入和删除交错。读取之后该值已经被写入缓存时直接返回缓存中的值，
+// 记录被并发移动时重新读取
+func (c *cache) promote(key string) (ByteView, bool) {
+	if c.l2 == nil {
+		return ByteView{}, false
+	}
+	s := c.shard(key)
+	for i := 0; i < tierReadRetries; i++ {
+		value, e, ok := c.l2.peek(key)
+		if !ok {
+			return ByteView{}, false
+		}
+		s.mu.Lock()
+		if v, ok := s.cache.Peek(key); ok && !v.(ByteView).Expired() {
+			s.mu.Unlock()
+			return v.(ByteView), true
+		}
+		if c.l2.removeIf(key, e) {
+			c.l2.hits.Add(1)
+			s.cache.Add(key, value)
+			s.mu.Unlock()
+			return value, true
+		}
+		s.mu.Unlock()
+	}
+	return ByteView{}, false
+}
+
+// addAbsent 在 key 不存在或者已经过期时写入缓存，返回是否写入，log 不为空时在持有分片写锁时先调用，失败时不写入。
+// key 在 l2 中，或者在 trackWrites 之后被写入或删除过时同样不写入
+func (c *cache) addAbsent(key string, value ByteView, log func() error) (bool, error) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.cache.Peek(key); ok && !v.(ByteView).Expired() {
+		return false, nil
+	}
+	if _, ok := s.touched[key]; ok {
+		return false, nil
+	}
+	if c.l2 != nil && c.l2.contains(key) {
+		return false, nil
+	}
+	if log != nil {
+		if err := log(); err != nil {
+			return false, err
+		}
+	}
+	c.dropL2(key)
+	s.cache.Add(key, value)
+	return true, nil
+}
+
+// deleteLogged 在持有分片写锁时先调用 log，成功后再删除缓存，log 失败时缓存不变
+func (c *cache) deleteLogged(key string, log func() error) error {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := log(); err != nil {
+		return err
+	}
+	s.touch(key)
+	c.dropL2(key)
+	s.cache.Delete(key)
+	return nil
+}
+
+// get 获取缓存值，命中时只持有读锁，已经过期的值会被删除
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.cache == nil {
+	s, h := c.locate(key)
+	s.mu.RLock()
+	v, ok := s.cache.Peek(key)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+	if view := v.(ByteView); !view.Expired() {
+		s.record(key, h)
+		return view, true
+	}
+	s.mu.Lock()
+	// 加写锁之前该值可能已经被更新，需要重新检查
+	if v, ok := s.cache.Peek(key); ok && v.(ByteView).Expired() {
+		if s.cache.Delete(key) {
+			c.counters.evictedExpired.Add(1)
+			if c.onExpired != nil {
+				c.onExpired(key)
+			}
+		}
+	}
+	s.mu.Unlock()
+	return ByteView{}, false
+}
+
+// record 记录一次命中，缓冲区满了之后尝试获取写锁批量更新淘汰策略。缓冲区正在被其他 goroutine 使用，
+// 或者获取不到写锁时直接丢弃访问记录，丢弃少量访问记录只会略微影响淘汰的准确性，但可以保证读取不会被阻塞
+func (s *shard) record(key string, h uint64) {
+	b := &s.reads[(h>>32)%readStripes] // 低位已经用于选择分片，这里使用高位
+	if !b.mu.TryLock() {
 		return
 	}
-	if v, ok := c.cache.Get(key); ok {
-		return v.(ByteView), ok
+	defer b.mu.Unlock()
+	b.keys[b.n] = key
+	b.n++
+	if b.n < readBufferSize {
+		return
+	}
+	if s.mu.TryLock() {
+		for _, key := range b.keys {
+			s.cache.Get(key)
+		}
+		s.mu.Unlock()
+	}
+	for i := range b.keys {
+		b.keys[i] = "" // 避免缓冲区持有已经被删除的 key
+	}
+	b.n = 0
+}
+
+func (c *cache) delete(key string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(key)
+	c.dropL2(key)
+	s.cache.Delete(key)
+}
+
+// trackWrites 开始记录所有分片中被写入或删除的键，addAbsent 不会覆盖这些键，直到返回的 stop 被调用。
+// 可以同时有多次记录，全部结束后清空记录的键
+func (c *cache) trackWrites() (stop func()) {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		if s.tracking == 0 {
+			s.touched = make(map[string]struct{})
+		}
+		s.tracking++
+		s.mu.Unlock()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			for _, s := range c.shards {
+				s.mu.Lock()
+				if s.tracking--; s.tracking == 0 {
+					s.touched = nil
+				}
+				s.mu.Unlock()
+			}
+		})
+	}
+}
+
+// each 按照淘汰顺序遍历所有未过期的值，包括 l2 中的值，同一个分片中 l2 中的值以及最先被淘汰的在前，
+// 按照相同的顺序写回时可以保留淘汰顺序。每次只持有一个分片的读锁，fn 在锁外调用
+func (c *cache) each(fn func(key string, value ByteView)) {
+	type item struct {
+		key   string
+		value ByteView
+	}
+	for _, s := range c.shards {
+		s.mu.RLock()
+		keys := s.cache.Keys()
+		items := make([]item, 0, len(keys))
+		for i := len(keys) - 1; i >= 0; i-- {
+			if v, ok := s.cache.Peek(keys[i]); ok && !v.(ByteView).Expired() {
+				items = append(items, item{keys[i], v.(ByteView)})
+			}
+		}
+		var demoted []tierItem
+		if c.l2 != nil {
+			// 值只在持有分片写锁时在缓存和 l2 之间移动，持有读锁时两者合起来是分片完整的状态
+			demoted = c.l2.items(func(key string) bool { return c.shard(key) == s })
+		}
+		s.mu.RUnlock()
+		// 读取磁盘时不持有分片锁，期间被取出放回缓存的值读取的是取出之前的记录
+		for _, it := range demoted {
+			if value, ok := c.l2.read(it); ok && !value.Expired() {
+				fn(it.key, value)
+			}
+		}
+		for _, it := range items {
+			fn(it.key, it.value)
+		}
+	}
+}
+
+// keys 返回所有未过期的键
+func (c *cache) keys() []string {
+	var keys []string
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for _, key := range s.cache.Keys() {
+			if v, ok := s.cache.Peek(key); ok && !v.(ByteView).Expired() {
+				keys = append(keys, key)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return keys
+}
+
+// stats 返回缓存值的数量和占用的大小
+func (c *cache) stats() (items int, bytes int64) {
+	for _, s := range c.shards {
+		s.mu.RLock()
+		items += s.cache.Len()
+		bytes += s.cache.NowSize()
+		s.mu.RUnlock()
 	}
-	return
+	return items, bytes
 }
@@ -0,0 +1,630 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTierBytes       = 1 << 30  // 表示默认的二级缓存容量
+	defaultTierSegmentSize = 64 << 20 // 表示默认的段文件大小
+	defaultTierGCRatio     = 0.5      // 表示默认的段压缩阈值
+	minTierSegmentSize     = 4 << 10  // 表示段文件的最小大小
+	tierRecordHeaderSize   = 8        // 每条记录的头部：内容的长度(4) + CRC-32C(4)
+	tierWriteBufferSize    = 64 << 10 // 表示写入段文件的缓冲区大小
+	tierReadRetries        = 3        // 读取之后记录被并发移动时重新读取的次数
+	tierSegmentPrefix      = "l2."
+	tierSegmentExt         = ".seg"
+)
+
+var errTierClosed = errors.New("disk tier is closed")
+
+// DiskTierOptions 二级缓存的配置项，零值表示使用默认配置
+type DiskTierOptions struct {
+	MaxBytes    int64   // MaxBytes 所有段文件的最大字节数，超过后淘汰最早的段，默认为 1GB
+	SegmentSize int64   // SegmentSize 单个段文件的大小，写满后切换到新的段，默认为 64MB，不超过 MaxBytes 的 1/4
+	GCRatio     float64 // GCRatio 有效数据占比低于该值的段会被压缩，其中有效的值重新写入当前的段，默认为 0.5
+}
+
+// TierStats 二级缓存的统计信息
+type TierStats struct {
+	Items     int   // Items 二级缓存中值的数量
+	Bytes     int64 // Bytes 所有段文件的大小，包括已经失效的记录
+	LiveBytes int64 // LiveBytes 有效记录的大小
+	MaxBytes  int64 // MaxBytes 所有段文件的最大字节数
+	Segments  int   // Segments 段文件的数量
+	Demoted   int64 // Demoted 写入二级缓存的次数
+	Hits      int64 // Hits 命中并取出的次数
+	Dropped   int64 // Dropped 因为过期、过大或者所在的段被淘汰而丢弃的值的数量
+	Errors    int64 // Errors 写入段文件失败的次数
+}
+
+// DiskTier 基于磁盘的二级缓存，值按照写入的顺序追加到段文件中，内存中只保存键到记录位置的索引。
+// 分组本地缓存因为容量不足淘汰的值写入二级缓存，读取时本地缓存未命中会先从二级缓存中取出并放回本地缓存。
+// 覆盖、删除或者取出的值只从索引中删除，后台定期回收：有效数据为 0 的段直接删除，超过容量时淘汰最早的段，
+// 有效数据占比较低的段中仍然有效的值重新写入当前的段后删除。
+//
+// 二级缓存只用于扩展本地缓存的容量，不保证持久化：打开时会删除目录中已有的段文件，关闭时同样删除
+type DiskTier struct {
+	dir  string
+	opts DiskTierOptions
+
+	gcMu sync.Mutex    // gcMu 保证同一时间只有一次回收
+	gc   chan struct{} // gc 通知后台回收，容量为 1
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex // mu 保护以下字段
+	index    map[string]tierEntry
+	segments []*tierSegment // segments 按照编号排列，最后一个是当前写入的段
+	w        *bufio.Writer  // w 当前段的写缓冲区
+	bytes    int64          // bytes 所有段文件的大小
+	live     int64          // live 有效记录的大小
+	nextID   int
+	closed   bool
+
+	demoted atomic.Int64
+	hits    atomic.Int64
+	dropped atomic.Int64
+	errors  atomic.Int64
+}
+
+// tierEntry 索引中一条记录的位置
+type tierEntry struct {
+	seg    *tierSegment
+	off    int64
+	size   int64
+	expire int64 // expire 过期时间的纳秒时间戳，为 0 时永不过期
+}
+
+// tierSegment 一个段文件，读取记录时持有读锁，删除段文件之前持有写锁等待正在进行的读取结束
+type tierSegment struct {
+	mu      sync.RWMutex
+	id      int
+	f       *os.File
+	size    int64 // size 已经写入的大小，包括还在写缓冲区中的部分
+	live    int64 // live 段中有效记录的大小
+	flushed int64 // flushed 已经从写缓冲区写入文件的大小
+}
+
+// OpenDiskTier 在 dir 中打开二级缓存，目录不存在时创建，opts 为空时使用默认配置
+func OpenDiskTier(dir string, opts *DiskTierOptions) (*DiskTier, error) {
+	o := DiskTierOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxBytes < 0 || o.SegmentSize < 0 || o.GCRatio < 0 || o.GCRatio >= 1 {
+		return nil, fmt.Errorf("bad disk tier options %+v", o)
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = defaultTierBytes
+	}
+	if o.SegmentSize == 0 {
+		o.SegmentSize = defaultTierSegmentSize
+	}
+	if o.SegmentSize > o.MaxBytes/4 {
+		// 至少保留 4 个段，淘汰一个段时不会清空大部分数据
+		o.SegmentSize = o.MaxBytes / 4
+	}
+	if o.SegmentSize < minTierSegmentSize {
+		o.SegmentSize = minTierSegmentSize
+	}
+	if o.GCRatio == 0 {
+		o.GCRatio = defaultTierGCRatio
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := removeTierSegments(dir); err != nil {
+		return nil, err
+	}
+	t := &DiskTier{
+		dir:   dir,
+		opts:  o,
+		gc:    make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		index: make(map[string]tierEntry),
+	}
+	if err := t.rotate(); err != nil {
+		return nil, err
+	}
+	go t.loop()
+	return t, nil
+}
+
+// removeTierSegments 删除目录中上次运行留下的段文件
+func removeTierSegments(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, tierSegmentPrefix) || !strings.HasSuffix(name, tierSegmentExt) {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, tierSegmentPrefix), tierSegmentExt)); err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentPath 返回编号为 id 的段文件的路径
+func (t *DiskTier) segmentPath(id int) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%s%08d%s", tierSegmentPrefix, id, tierSegmentExt))
+}
+
+// Dir 返回二级缓存的目录
+func (t *DiskTier) Dir() string {
+	return t.dir
+}
+
+// Put 写入值，已经存在的值会被覆盖。已经过期的值，以及超过段文件大小的值不会被写入，同时删除旧的值。
+// 在分组的淘汰回调中调用，此时持有本地缓存的分片写锁，Put 只写入缓冲区，不会同步到磁盘
+func (t *DiskTier) Put(key string, value ByteView) error {
+	rec := encodeTierRecord(key, value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errTierClosed
+	}
+	if value.Expired() || int64(len(rec)) > t.opts.SegmentSize {
+		t.removeLocked(key)
+		t.dropped.Add(1)
+		return nil
+	}
+	var expire int64
+	if !value.expire.IsZero() {
+		expire = value.expire.UnixNano()
+	}
+	if err := t.appendLocked(key, rec, expire); err != nil {
+		t.removeLocked(key)
+		t.errors.Add(1)
+		return err
+	}
+	t.demoted.Add(1)
+	return nil
+}
+
+// Take 取出值并从二级缓存中删除，值不存在、已经过期或者读取失败时返回 false。
+// 取出的值由调用方放回本地缓存，之后再次被淘汰时重新写入
+func (t *DiskTier) Take(key string) (ByteView, bool) {
+	for i := 0; i < tierReadRetries; i++ {
+		value, e, ok := t.peek(key)
+		if !ok {
+			return ByteView{}, false
+		}
+		if t.removeIf(key, e) {
+			t.hits.Add(1)
+			return value, true
+		}
+	}
+	return ByteView{}, false
+}
+
+// peek 读取值但不删除，返回的 tierEntry 用于之后通过 removeIf 删除同一条记录。读取磁盘时不持有 mu
+func (t *DiskTier) peek(key string) (ByteView, tierEntry, bool) {
+	t.mu.Lock()
+	e, ok := t.index[key]
+	if !ok || t.closed {
+		t.mu.Unlock()
+		return ByteView{}, tierEntry{}, false
+	}
+	if e.expire != 0 && time.Now().UnixNano() > e.expire {
+		t.removeLocked(key)
+		t.mu.Unlock()
+		t.dropped.Add(1)
+		return ByteView{}, tierEntry{}, false
+	}
+	value, ok := t.readLocked(key, e)
+	if !ok {
+		// 记录无法读取或者已经损坏，之后不会再读到
+		if t.removeIf(key, e) {
+			t.dropped.Add(1)
+		}
+		return ByteView{}, tierEntry{}, false
+	}
+	return value, e, true
+}
+
+// readLocked 读取 e 指向的记录，调用时需要持有 mu，返回时已经释放。记录还在写缓冲区中时先写入文件，
+// 读取时持有段的读锁，回收需要等待读取结束才能删除段文件；段已经被删除时读取失败
+func (t *DiskTier) readLocked(key string, e tierEntry) (ByteView, bool) {
+	if active := t.active(); e.seg == active && e.off+e.size > active.flushed {
+		if err := t.w.Flush(); err != nil {
+			t.mu.Unlock()
+			return ByteView{}, false
+		}
+		active.flushed = active.size
+	}
+	e.seg.mu.RLock()
+	t.mu.Unlock()
+	buf := make([]byte, e.size)
+	_, err := e.seg.f.ReadAt(buf, e.off)
+	e.seg.mu.RUnlock()
+	if err != nil {
+		return ByteView{}, false
+	}
+	k, value, err := decodeTierRecord(buf)
+	if err != nil || k != key {
+		return ByteView{}, false
+	}
+	return value, true
+}
+
+// removeIf 索引中的 key 仍然指向 e 时删除并返回 true。读取之后该值被覆盖、删除或者被回收移动到其他段时返回 false
+func (t *DiskTier) removeIf(key string, e tierEntry) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cur, ok := t.index[key]; !ok || cur != e {
+		return false
+	}
+	return t.removeLocked(key)
+}
+
+// contains 判断二级缓存中是否有未过期的 key，不读取磁盘
+func (t *DiskTier) contains(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.index[key]
+	return ok && (e.expire == 0 || time.Now().UnixNano() <= e.expire)
+}
+
+// tierItem 二级缓存中的一个键以及它在索引中的位置
+type tierItem struct {
+	key   string
+	entry tierEntry
+}
+
+// items 返回 match 接受的键以及它们的位置，只在持有 mu 时遍历索引，不读取磁盘
+func (t *DiskTier) items(match func(key string) bool) []tierItem {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var items []tierItem
+	for key, e := range t.index {
+		if match(key) {
+			items = append(items, tierItem{key: key, entry: e})
+		}
+	}
+	return items
+}
+
+// read 读取 items 返回的记录，不删除。记录在此期间被回收移动到其他段时按照键重新读取，
+// 被取出、覆盖或者删除时返回 false
+func (t *DiskTier) read(it tierItem) (ByteView, bool) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ByteView{}, false
+	}
+	if value, ok := t.readLocked(it.key, it.entry); ok {
+		return value, true
+	}
+	value, _, ok := t.peek(it.key)
+	return value, ok
+}
+
+// Delete 删除值，返回该键是否存在
+func (t *DiskTier) Delete(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.removeLocked(key)
+}
+
+// Stats 返回二级缓存的统计信息
+func (t *DiskTier) Stats() TierStats {
+	t.mu.Lock()
+	stats := TierStats{
+		Items:     len(t.index),
+		Bytes:     t.bytes,
+		LiveBytes: t.live,
+		MaxBytes:  t.opts.MaxBytes,
+		Segments:  len(t.segments),
+	}
+	t.mu.Unlock()
+	stats.Demoted = t.demoted.Load()
+	stats.Hits = t.hits.Load()
+	stats.Dropped = t.dropped.Load()
+	stats.Errors = t.errors.Load()
+	return stats
+}
+
+// Close 停止后台回收，关闭并删除所有段文件，之后写入会返回错误，读取总是未命中
+func (t *DiskTier) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+	close(t.stop)
+	<-t.done
+	// 等待正在进行的回收结束，之后不会再有新的回收
+	t.gcMu.Lock()
+	defer t.gcMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	for _, s := range t.segments {
+		s.mu.Lock()
+		if e := s.f.Close(); e != nil && err == nil {
+			err = e
+		}
+		if e := os.Remove(s.f.Name()); e != nil && err == nil {
+			err = e
+		}
+		s.mu.Unlock()
+	}
+	t.segments, t.index, t.bytes, t.live = nil, nil, 0, 0
+	return err
+}
+
+// active 返回当前写入的段
+func (t *DiskTier) active() *tierSegment {
+	return t.segments[len(t.segments)-1]
+}
+
+// rotate 将写缓冲区中的数据写入当前的段，然后切换到新的段
+func (t *DiskTier) rotate() error {
+	if t.w != nil {
+		if err := t.w.Flush(); err != nil {
+			return err
+		}
+		active := t.active()
+		active.flushed = active.size
+	}
+	t.nextID++
+	f, err := os.OpenFile(t.segmentPath(t.nextID), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	t.segments = append(t.segments, &tierSegment{id: t.nextID, f: f})
+	if t.w == nil {
+		t.w = bufio.NewWriterSize(f, tierWriteBufferSize)
+	} else {
+		t.w.Reset(f)
+	}
+	return nil
+}
+
+// appendLocked 将记录追加到当前的段并更新索引，当前的段写满时先切换到新的段，需要持有 mu
+func (t *DiskTier) appendLocked(key string, rec []byte, expire int64) error {
+	size := int64(len(rec))
+	if active := t.active(); active.size > 0 && active.size+size > t.opts.SegmentSize {
+		if err := t.rotate(); err != nil {
+			return err
+		}
+		t.kick()
+	}
+	active := t.active()
+	if _, err := t.w.Write(rec); err != nil {
+		return err
+	}
+	t.removeLocked(key)
+	t.index[key] = tierEntry{seg: active, off: active.size, size: size, expire: expire}
+	active.size += size
+	active.live += size
+	t.bytes += size
+	t.live += size
+	if t.bytes > t.opts.MaxBytes {
+		t.kick()
+	}
+	return nil
+}
+
+// removeLocked 从索引中删除 key，段中的有效数据占比低于阈值时通知后台回收，需要持有 mu
+func (t *DiskTier) removeLocked(key string) bool {
+	e, ok := t.index[key]
+	if !ok {
+		return false
+	}
+	delete(t.index, key)
+	e.seg.live -= e.size
+	t.live -= e.size
+	if e.seg != t.active() && float64(e.seg.live) < t.opts.GCRatio*float64(e.seg.size) {
+		t.kick()
+	}
+	return true
+}
+
+// kick 通知后台回收，已经有等待处理的通知时直接返回
+func (t *DiskTier) kick() {
+	select {
+	case t.gc <- struct{}{}:
+	default:
+	}
+}
+
+func (t *DiskTier) loop() {
+	defer close(t.done)
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-t.gc:
+			t.Collect()
+		}
+	}
+}
+
+// Collect 回收段文件直到没有需要回收的段，通常由后台自动调用，也可以手动调用立即回收
+func (t *DiskTier) Collect() {
+	t.gcMu.Lock()
+	defer t.gcMu.Unlock()
+	for {
+		s, drop := t.pick()
+		if s == nil {
+			return
+		}
+		t.reclaim(s, drop)
+	}
+}
+
+// pick 选择需要回收的段，drop 表示丢弃段中的值还是将有效的值重新写入当前的段。当前写入的段不会被回收
+func (t *DiskTier) pick() (s *tierSegment, drop bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || len(t.segments) < 2 {
+		return nil, false
+	}
+	sealed := t.segments[:len(t.segments)-1]
+	for _, s := range sealed {
+		if s.live == 0 {
+			return s, true
+		}
+	}
+	if t.bytes > t.opts.MaxBytes {
+		// 最早的段中是最早被淘汰的值，访问的可能性最低
+		return sealed[0], true
+	}
+	ratio := t.opts.GCRatio
+	for _, seg := range sealed {
+		if r := float64(seg.live) / float64(seg.size); r < ratio {
+			s, ratio = seg, r
+		}
+	}
+	return s, false
+}
+
+// reclaim 顺序读取段文件，索引仍然指向的记录根据 drop 丢弃或者重新写入当前的段，最后删除段文件。
+// 读取时不持有 mu，每条记录只在检查和更新索引时持有
+func (t *DiskTier) reclaim(s *tierSegment, drop bool) {
+	if s.live > 0 {
+		if err := t.scan(s, drop); err != nil {
+			// 段文件无法读取时丢弃其中剩余的值
+			t.mu.Lock()
+			for key, e := range t.index {
+				if e.seg == s {
+					t.removeLocked(key)
+					t.dropped.Add(1)
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+	t.mu.Lock()
+	for i, seg := range t.segments {
+		if seg == s {
+			t.segments = append(t.segments[:i], t.segments[i+1:]...)
+			break
+		}
+	}
+	t.bytes -= s.size
+	t.mu.Unlock()
+	s.mu.Lock()
+	s.f.Close()
+	os.Remove(s.f.Name())
+	s.mu.Unlock()
+}
+
+// scan 遍历段中的记录，处理索引仍然指向的记录
+func (t *DiskTier) scan(s *tierSegment, drop bool) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(s.f, 0, s.size), tierWriteBufferSize)
+	now := time.Now().UnixNano()
+	var buf []byte
+	for off := int64(0); off < s.size; {
+		buf = append(buf[:0], make([]byte, tierRecordHeaderSize)...)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		n := int64(binary.LittleEndian.Uint32(buf))
+		if off+tierRecordHeaderSize+n > s.size {
+			return fmt.Errorf("bad record at %d in %s", off, s.f.Name())
+		}
+		buf = append(buf, make([]byte, n)...)
+		if _, err := io.ReadFull(r, buf[tierRecordHeaderSize:]); err != nil {
+			return err
+		}
+		key, err := tierRecordKey(buf)
+		if err != nil {
+			return err
+		}
+		t.mu.Lock()
+		if e, ok := t.index[key]; ok && e.seg == s && e.off == off {
+			if drop || (e.expire != 0 && now > e.expire) || t.closed {
+				t.removeLocked(key)
+				t.dropped.Add(1)
+			} else if err := t.appendLocked(key, buf, e.expire); err != nil {
+				t.removeLocked(key)
+				t.dropped.Add(1)
+			}
+		}
+		t.mu.Unlock()
+		off += int64(len(buf))
+	}
+	return nil
+}
+
+// encodeTierRecord 将值编码为段文件中的记录，记录的内容为：
+//
+//	编码方式(1) | 过期时间(8) | 键的长度 | 键 | 值
+//
+// 记录之前是内容的长度和 CRC-32C
+func encodeTierRecord(key string, value ByteView) []byte {
+	buf := make([]byte, tierRecordHeaderSize, tierRecordHeaderSize+1+8+binary.MaxVarintLen64+len(key)+len(value.bytes))
+	buf = append(buf, byte(value.encoding))
+	var expire int64
+	if !value.expire.IsZero() {
+		expire = value.expire.UnixNano()
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(expire))
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = append(buf, value.bytes...)
+	payload := buf[tierRecordHeaderSize:]
+	binary.LittleEndian.PutUint32(buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+// tierRecordKey 返回记录中的键，不校验内容
+func tierRecordKey(rec []byte) (string, error) {
+	p := rec[tierRecordHeaderSize:]
+	if len(p) < 9 {
+		return "", errors.New("malformed tier record")
+	}
+	n, w := binary.Uvarint(p[9:])
+	if w <= 0 || uint64(len(p)-9-w) < n {
+		return "", errors.New("malformed tier record")
+	}
+	return string(p[9+w : 9+w+int(n)]), nil
+}
+
+// decodeTierRecord 校验并解析记录，返回的值与 rec 共享底层数组
+func decodeTierRecord(rec []byte) (string, ByteView, error) {
+	payload := rec[tierRecordHeaderSize:]
+	if int(binary.LittleEndian.Uint32(rec)) != len(payload) || crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(rec[4:]) {
+		return "", ByteView{}, errors.New("tier record checksum mismatch")
+	}
+	key, err := tierRecordKey(rec)
+	if err != nil {
+		return "", ByteView{}, err
+	}
+	if Encoding(payload[0]) > EncodingFlate {
+		return "", ByteView{}, errors.New("malformed tier record")
+	}
+	value := ByteView{encoding: Encoding(payload[0])}
+	if expire := int64(binary.LittleEndian.Uint64(payload[1:9])); expire != 0 {
+		value.expire = time.Unix(0, expire)
+	}
+	_, w := binary.Uvarint(payload[9:])
+	value.bytes = payload[9+w+len(key):]
+	return key, value, nil
+}
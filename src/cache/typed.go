@@ -0,0 +1,72 @@
+package cache
+
+import "time"
+
+// TypedGetter 类型化的回调函数，当缓存中获取数据失败时，调用回调函数获取类型为 T 的数据
+type TypedGetter[T any] interface {
+	Get(key string) (T, error)
+}
+
+// TypedGetterFunc 函数类型，实现了TypedGetter接口中的Get方法
+type TypedGetterFunc[T any] func(key string) (T, error)
+
+func (f TypedGetterFunc[T]) Get(key string) (T, error) {
+	return f(key)
+}
+
+// TypedGroup 类型化的分组，对 Group 进行封装，通过编解码器在 T 与缓存中的字节之间转换
+type TypedGroup[T any] struct {
+	group *Group   // group 实际存储数据的分组
+	codec Codec[T] // codec 编解码器
+}
+
+// NewTypedGroup 创建类型化的分组，使用默认的配置项
+func NewTypedGroup[T any](name string, cacheBytes int64, codec Codec[T], getter TypedGetter[T]) *TypedGroup[T] {
+	return NewTypedGroupOpts(name, cacheBytes, codec, getter, nil)
+}
+
+// NewTypedGroupOpts 根据配置项创建类型化的分组
+func NewTypedGroupOpts[T any](name string, cacheBytes int64, codec Codec[T], getter TypedGetter[T], opts *GroupOptions) *TypedGroup[T] {
+	if getter == nil {
+		panic("空的 Getter")
+	}
+	return &TypedGroup[T]{
+		group: NewGroupOpts(name, cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+			v, err := getter.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			return codec.Encode(v)
+		}), opts),
+		codec: codec,
+	}
+}
+
+// Group 返回实际存储数据的分组，用于注册节点等操作
+func (g *TypedGroup[T]) Group() *Group {
+	return g.group
+}
+
+// Get 根据key获取组内的值并解码，分组开启压缩时先解压再解码
+func (g *TypedGroup[T]) Get(key string) (T, error) {
+	view, err := g.group.Get(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return g.codec.Decode(view.ByteSlice())
+}
+
+// Set 将值编码后写入缓存，ttl 为 0 时表示永不过期
+func (g *TypedGroup[T]) Set(key string, v T, ttl time.Duration) error {
+	data, err := g.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return g.group.Set(key, data, ttl)
+}
+
+// Delete 删除缓存中的值
+func (g *TypedGroup[T]) Delete(key string) error {
+	return g.group.Delete(key)
+}
@@ -0,0 +1,67 @@
+package getters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"jw-cache/src/cache"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileGetter 从目录中加载数据，key 为文件相对于目录的路径（使用 "/" 分隔），不能访问目录之外的文件，
+// 目录中指向目录之外的符号链接同样会被拒绝
+type FileGetter struct {
+	Dir     string // Dir 数据所在的目录
+	MaxSize int64  // MaxSize 文件的最大字节数，为 0 时不限制
+}
+
+// NewFileGetter 新建从目录中加载数据的 Getter
+func NewFileGetter(dir string) *FileGetter {
+	return &FileGetter{Dir: dir}
+}
+
+// Get 读取文件内容，文件不存在时返回 cache.ErrNotFound
+func (g *FileGetter) Get(key string) ([]byte, error) {
+	name := filepath.Clean(filepath.FromSlash(key))
+	// 拒绝绝对路径和 ".." 等跳出目录的路径
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("invalid key: %s", key)
+	}
+	// 解析符号链接之后的真实路径仍然需要在目录之中
+	dir, err := filepath.EvalSymlinks(g.Dir)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("invalid key: %s", key)
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}
+	if g.MaxSize > 0 && info.Size() > g.MaxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", key, g.MaxSize)
+	}
+	return io.ReadAll(f)
+}
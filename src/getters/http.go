@@ -0,0 +1,150 @@
+package getters
+
+import (
+	"fmt"
+	"io"
+	"jw-cache/src/cache"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// defaultValidatorBytes 表示默认用于保存条件请求校验信息的最大字节数
+const defaultValidatorBytes = 8 << 20
+
+// HTTPGetter 从上游HTTP服务中加载数据，请求地址为 BaseURL 加上转义后的 key，
+// 会记住响应中的 ETag 和 Last-Modified，再次加载同一个 key 时发送条件请求，上游返回 304 时直接使用上一次的响应体
+type HTTPGetter struct {
+	BaseURL string       // BaseURL 上游服务的地址前缀，例如 http://origin/objects/
+	Client  *http.Client // Client HTTP客户端，为空时使用 http.DefaultClient
+	Header  http.Header  // Header 每个请求都会携带的请求头，例如认证信息
+
+	mu         sync.Mutex
+	validators *cache.Cache // validators 保存每个 key 的校验信息和响应体，超出容量时按照 LRU 淘汰
+}
+
+// validator 条件请求的校验信息
+type validator struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+func (v *validator) Len() int {
+	return len(v.etag) + len(v.lastModified) + len(v.body)
+}
+
+// NewHTTPGetter 新建从上游HTTP服务中加载数据的 Getter
+func NewHTTPGetter(baseURL string) *HTTPGetter {
+	return NewHTTPGetterWithValidatorBytes(baseURL, defaultValidatorBytes)
+}
+
+// NewHTTPGetterWithValidatorBytes 新建从上游HTTP服务中加载数据的 Getter，validatorBytes 为保存校验信息的最大字节数，为 0 时不限制，为负数时不发送条件请求
+func NewHTTPGetterWithValidatorBytes(baseURL string, validatorBytes int64) *HTTPGetter {
+	g := &HTTPGetter{BaseURL: baseURL}
+	if validatorBytes >= 0 {
+		g.validators = cache.New(validatorBytes, nil)
+	}
+	return g
+}
+
+// escapeKey 按照 "/" 分段转义 key，保留 key 中的路径结构
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Get 请求上游服务，上游返回 404 或 410 时返回 cache.ErrNotFound
+func (g *HTTPGetter) Get(key string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, g.BaseURL+escapeKey(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range g.Header {
+		req.Header[k] = v
+	}
+	cached := g.validator(key)
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		return cloneBytes(cached.body), nil
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		g.forget(key)
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("origin returned: %v", res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	g.remember(key, &validator{
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+		body:         body,
+	})
+	return cloneBytes(body), nil
+}
+
+func (g *HTTPGetter) validator(key string) *validator {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.validators == nil {
+		return nil
+	}
+	if v, ok := g.validators.Get(key); ok {
+		return v.(*validator)
+	}
+	return nil
+}
+
+// remember 保存校验信息，没有任何校验信息的响应不需要保存
+func (g *HTTPGetter) remember(key string, v *validator) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.validators == nil {
+		return
+	}
+	if v.etag == "" && v.lastModified == "" {
+		g.validators.Delete(key)
+		return
+	}
+	g.validators.Add(key, v)
+}
+
+func (g *HTTPGetter) forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.validators != nil {
+		g.validators.Delete(key)
+	}
+}
+
+// 拷贝数据，避免调用方修改保存的响应体
+func cloneBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,42 @@
+package getters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"jw-cache/src/cache"
+	"time"
+)
+
+// SQLGetter 从数据库中加载数据，Query 只能返回一行一列，key 会作为唯一的参数绑定到 Query 的占位符上，
+// 例如 MySQL 中的 "SELECT score FROM scores WHERE name = ?"
+type SQLGetter struct {
+	DB      *sql.DB       // DB 数据库连接
+	Query   string        // Query 查询语句
+	Timeout time.Duration // Timeout 单次查询的超时时间，为 0 时不限制
+}
+
+// NewSQLGetter 新建从数据库中加载数据的 Getter
+func NewSQLGetter(db *sql.DB, query string) *SQLGetter {
+	return &SQLGetter{DB: db, Query: query}
+}
+
+// Get 执行查询，没有查询到数据时返回 cache.ErrNotFound
+func (g *SQLGetter) Get(key string) ([]byte, error) {
+	ctx := context.Background()
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+	var value []byte
+	err := g.DB.QueryRowContext(ctx, g.Query, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %v", key, err)
+	}
+	return value, nil
+}
@@ -2,6 +2,7 @@ package hashes
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -62,3 +63,108 @@ func (m *Map) Get(key string) string {
 	})
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// GetWithFilter 根据key获取节点名称，从key在哈希环上的位置开始顺时针查找第一个被 accept 接受的节点，
+// 若所有节点都不被接受，则返回空字符串
+func (m *Map) GetWithFilter(key string, accept func(node string) bool) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	checked := make(map[string]bool)
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if checked[node] {
+			continue
+		}
+		if accept(node) {
+			return node
+		}
+		checked[node] = true
+	}
+	return ""
+}
+
+// Hash 计算 key 在哈希环上的位置
+func (m *Map) Hash(key string) uint32 {
+	return m.hash([]byte(key))
+}
+
+// owner 返回哈希值为 hash 的 key 所属的节点
+func (m *Map) owner(hash uint32) string {
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= int(hash)
+	})
+	return m.hashMap[m.keys[idx%len(m.keys)]]
+}
+
+// Range 哈希环上的一段区间 [Start, End]，区间内的 key 所属的节点从 From 变为 To
+type Range struct {
+	Start uint32 // Start 区间的起点，包含在区间内
+	End   uint32 // End 区间的终点，包含在区间内
+	From  string // From 原来的节点
+	To    string // To 新的节点
+}
+
+// Ranges 按照起点排列、互不重叠的区间
+type Ranges []Range
+
+// Contains 判断哈希值是否在某个区间内
+func (r Ranges) Contains(hash uint32) bool {
+	i := sort.Search(len(r), func(i int) bool { return r[i].End >= hash })
+	return i < len(r) && r[i].Start <= hash
+}
+
+// Filter 返回 accept 接受的区间
+func (r Ranges) Filter(accept func(Range) bool) Ranges {
+	var out Ranges
+	for _, rg := range r {
+		if accept(rg) {
+			out = append(out, rg)
+		}
+	}
+	return out
+}
+
+// Moved 比较新旧两个哈希环，返回所属节点发生变化的区间，两个哈希环需要使用相同的哈希函数。
+// 两个哈希环的虚拟节点将哈希空间分成若干段，同一段中的 key 在两个哈希环上分别属于同一个节点，
+// 只需要比较每一段的终点在两个哈希环上所属的节点；任意一个哈希环为空时返回空
+func Moved(old, new *Map) Ranges {
+	if len(old.keys) == 0 || len(new.keys) == 0 {
+		return nil
+	}
+	points := make([]int, 0, len(old.keys)+len(new.keys)+1)
+	points = append(points, old.keys...)
+	points = append(points, new.keys...)
+	sort.Ints(points)
+	var moved Ranges
+	start := uint32(0)
+	add := func(end uint32) {
+		from, to := old.owner(end), new.owner(end)
+		if from != to {
+			// 与上一段相邻且节点的变化相同时合并
+			if n := len(moved); n > 0 && moved[n-1].End+1 == start && moved[n-1].From == from && moved[n-1].To == to {
+				moved[n-1].End = end
+			} else {
+				moved = append(moved, Range{Start: start, End: end, From: from, To: to})
+			}
+		}
+	}
+	for i, p := range points {
+		if i > 0 && p == points[i-1] {
+			continue
+		}
+		add(uint32(p))
+		start = uint32(p) + 1
+		if uint32(p) == math.MaxUint32 {
+			return moved
+		}
+	}
+	// 最后一个虚拟节点之后的 key 属于第一个虚拟节点
+	add(math.MaxUint32)
+	return moved
+}
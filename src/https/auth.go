@@ -0,0 +1,168 @@
+package https
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	timestampHeader     = "X-JWCache-Timestamp" // 签名时的时间戳，单位为秒
+	nonceHeader         = "X-JWCache-Nonce"     // 每个请求唯一的随机数，用于防止重放
+	signatureHeader     = "X-JWCache-Signature" // 请求的签名
+	defaultReplayWindow = 30 * time.Second      // 表示默认的时间戳有效窗口
+)
+
+// NewServerTLSConfig 根据证书文件创建服务端的TLS配置，caFile 不为空时要求客户端提供由该CA签发的证书（双向TLS）
+func NewServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading key pair: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// NewClientTLSConfig 根据证书文件创建客户端的TLS配置，certFile 不为空时向服务端提供客户端证书，caFile 用于校验服务端证书
+func NewClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading key pair: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// loadCertPool 从PEM文件中加载CA证书
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading ca file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// hmacAuth 基于共享密钥的请求签名，签名内容包括请求方法、路径、时间戳和随机数，
+// 时间戳超出窗口或随机数在窗口内重复出现的请求都会被拒绝
+type hmacAuth struct {
+	secret    []byte               // secret 节点之间共享的密钥
+	window    time.Duration        // window 时间戳的有效窗口
+	mu        sync.Mutex           // mu 互斥锁，用于保护 nonces 的并发访问
+	nonces    map[string]time.Time // nonces 窗口内已经使用过的随机数及其过期时间
+	lastSweep time.Time            // lastSweep 最近一次清理过期随机数的时间
+}
+
+func newHMACAuth(secret []byte, window time.Duration) *hmacAuth {
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
+	return &hmacAuth{secret: secret, window: window, nonces: make(map[string]time.Time)}
+}
+
+// sign 计算请求的签名，签名内容包括查询参数（例如交接的 ?from=）、对冲请求头、过期时间和请求体的摘要，
+// 防止请求被篡改
+func (a *hmacAuth) sign(r *http.Request, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.URL.RawQuery + "\n" + timestamp + "\n" + nonce + "\n" +
+		r.Header.Get(ttlHeader) + "\n" + r.Header.Get(hedgedHeader) + "\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign 为请求添加签名相关的请求头，body 为请求体
+func (a *hmacAuth) Sign(r *http.Request, body []byte) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(b)
+	r.Header.Set(timestampHeader, timestamp)
+	r.Header.Set(nonceHeader, nonce)
+	r.Header.Set(signatureHeader, a.sign(r, timestamp, nonce, body))
+	return nil
+}
+
+// SignRequest 使用节点之间共享的密钥为请求签名，body 为请求体，用于在连接池之外向节点发送请求，例如运维工具
+func SignRequest(r *http.Request, body, secret []byte) error {
+	return newHMACAuth(secret, 0).Sign(r, body)
+}
+
+// Verify 校验请求的签名，并拒绝过期或重放的请求，校验时会读取请求体，并将其重新放回请求中
+func (a *hmacAuth) Verify(r *http.Request) error {
+	timestamp, nonce := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	signature, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil || timestamp == "" || nonce == "" {
+		return fmt.Errorf("missing or malformed signature")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("reading request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	expected, _ := hex.DecodeString(a.sign(r, timestamp, nonce, body))
+	if !hmac.Equal(signature, expected) {
+		return fmt.Errorf("invalid signature")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+	now := time.Now()
+	if diff := now.Sub(time.Unix(ts, 0)); diff > a.window || diff < -a.window {
+		return fmt.Errorf("timestamp out of window")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.lastSweep) > a.window { // 每个窗口清理一次已经过期的随机数
+		for n, expire := range a.nonces {
+			if now.After(expire) {
+				delete(a.nonces, n)
+			}
+		}
+		a.lastSweep = now
+	}
+	if _, ok := a.nonces[nonce]; ok {
+		return fmt.Errorf("replayed request")
+	}
+	// 时间戳在窗口内的请求都可能被重放，因此随机数需要保留到时间戳离开窗口为止
+	a.nonces[nonce] = time.Unix(ts, 0).Add(a.window)
+	return nil
+}
@@ -0,0 +1,377 @@
+package https
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jw-cache/src/cache"
+	"jw-cache/src/hashes"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHandoffPath    = "_handoff"  // 表示交接数据的路径，位于 basePath 之下
+	defaultHandoffRate    = 16 << 20    // 表示默认的交接速度上限，单位为字节每秒
+	defaultHandoffTimeout = time.Minute // 表示默认的一次交接的最长时间
+	maxRangesBytes        = 1 << 20     // 表示交接请求中区间列表的最大字节数
+)
+
+// HandoffStats 一次交接的结果
+type HandoffStats struct {
+	Ranges  int   // Ranges 所属节点发生变化、需要交接的区间数量
+	Entries int   // Entries 新的节点写入的值的数量
+	Bytes   int64 // Bytes 传输的字节数
+	Failed  int   // Failed 交接失败的节点数量
+}
+
+// Peers 返回哈希环上的所有节点
+func (p *ConnectHTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.peers...)
+}
+
+// Handoff 切换到新的节点列表，切换前与其他节点交接所属节点发生变化的值：
+// 当前节点加入哈希环时，从原来的节点拉取现在属于当前节点的值；当前节点离开哈希环时，
+// 通知新的节点从当前节点拉取原来属于当前节点的值。其他节点的加入和离开由这些节点负责交接，只切换哈希环。
+// 交接完成之前哈希环保持不变，请求仍然由原来的节点处理；交接失败时同样会切换哈希环，缺少的值可以重新加载
+func (p *ConnectHTTPPool) Handoff(ctx context.Context, nodes ...string) (HandoffStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.HandoffTimeout)
+	defer cancel()
+	p.mu.Lock()
+	old, oldPeers := p.nodes, p.peers
+	p.mu.Unlock()
+	if old == nil {
+		// 还没有设置过节点，视为从没有当前节点的哈希环加入
+		oldPeers = without(nodes, p.self)
+		old = newRing(oldPeers...)
+	}
+	var stats HandoffStats
+	var err error
+	moved := hashes.Moved(old, newRing(nodes...))
+	switch wasIn, isIn := contains(oldPeers, p.self), contains(nodes, p.self); {
+	case !wasIn && isIn:
+		moved = moved.Filter(func(r hashes.Range) bool { return r.To == p.self })
+		stats, err = p.handoff(ctx, moved, func(r hashes.Range) string { return r.From }, p.pull)
+	case wasIn && !isIn:
+		moved = moved.Filter(func(r hashes.Range) bool { return r.From == p.self })
+		stats, err = p.handoff(ctx, moved, func(r hashes.Range) string { return r.To }, p.notify)
+	}
+	p.Set(nodes...)
+	return stats, err
+}
+
+// Leave 将当前节点从哈希环中移除，移除前将当前节点的值交接给新的节点，用于正常关闭节点
+func (p *ConnectHTTPPool) Leave(ctx context.Context) (HandoffStats, error) {
+	return p.Handoff(ctx, without(p.Peers(), p.self)...)
+}
+
+// handoff 按照 peer 返回的节点将区间分组，并发地与每个节点交接，某个节点失败时继续与其他节点交接，返回第一个错误
+func (p *ConnectHTTPPool) handoff(ctx context.Context, moved hashes.Ranges, peer func(hashes.Range) string,
+	transfer func(ctx context.Context, node string, ranges hashes.Ranges) (int, int64, error)) (HandoffStats, error) {
+	byPeer := map[string]hashes.Ranges{}
+	for _, r := range moved {
+		byPeer[peer(r)] = append(byPeer[peer(r)], r)
+	}
+	stats := HandoffStats{Ranges: len(moved)}
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	for node, ranges := range byPeer {
+		wg.Add(1)
+		go func(node string, ranges hashes.Ranges) {
+			defer wg.Done()
+			start := time.Now()
+			entries, n, err := transfer(ctx, node, ranges)
+			mu.Lock()
+			defer mu.Unlock()
+			stats.Entries += entries
+			stats.Bytes += n
+			if err != nil {
+				stats.Failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+				p.logger.Warn("handoff failed", "node", node, "ranges", len(ranges), "entries", entries, "err", err)
+				return
+			}
+			p.logger.Info("handoff finished", "node", node, "ranges", len(ranges), "entries", entries, "bytes", n, "cost", time.Since(start))
+		}(node, ranges)
+	}
+	wg.Wait()
+	return stats, firstErr
+}
+
+// pull 从 from 拉取当前节点所有分组中属于 ranges 的值，原来的节点没有该分组时跳过
+func (p *ConnectHTTPPool) pull(ctx context.Context, from string, ranges hashes.Ranges) (entries int, n int64, err error) {
+	body := encodeRanges(ranges)
+	for _, name := range cache.GroupNames() {
+		g := cache.GetGroup(name)
+		if g == nil {
+			continue
+		}
+		// 旧节点导出期间当前节点的写入和删除不会被导出的值覆盖
+		stop := g.TrackWrites()
+		res, err := p.handoffRequest(ctx, from+p.basePath+defaultHandoffPath+"/"+url.PathEscape(name), body)
+		if err != nil {
+			stop()
+			return entries, n, err
+		}
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			stop()
+			continue
+		}
+		r := &countingReader{r: res.Body}
+		stats, err := g.ImportEntries(r)
+		res.Body.Close()
+		stop()
+		entries += stats.Entries
+		n += r.n
+		if err != nil {
+			return entries, n, fmt.Errorf("pulling group %s from %s: %w", name, from, err)
+		}
+	}
+	return entries, n, nil
+}
+
+// handoffResult 通知其他节点拉取数据后返回的结果
+type handoffResult struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// notify 通知 to 从当前节点拉取属于 ranges 的值，等待拉取完成后返回
+func (p *ConnectHTTPPool) notify(ctx context.Context, to string, ranges hashes.Ranges) (int, int64, error) {
+	u := to + p.basePath + defaultHandoffPath + "?from=" + url.QueryEscape(p.self)
+	res, err := p.handoffRequest(ctx, u, encodeRanges(ranges))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%s returned: %v", to, res.Status)
+	}
+	var result handoffResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, 0, fmt.Errorf("decoding handoff result from %s: %v", to, err)
+	}
+	return result.Entries, result.Bytes, nil
+}
+
+// handoffRequest 发送交接请求，响应体可能很大，只受 ctx 的限制，不使用单次请求的超时时间。
+// 返回状态码为 200 或 404 的响应，其他状态码返回错误
+func (p *ConnectHTTPPool) handoffRequest(ctx context.Context, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if p.auth != nil {
+		if err := p.auth.Sign(req, body); err != nil {
+			return nil, err
+		}
+	}
+	res, err := p.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		return nil, fmt.Errorf("server returned: %v: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return res, nil
+}
+
+// serveHandoff 处理其他节点的交接请求，请求体均为区间列表：
+//
+//	POST _handoff/{group}      返回该分组中属于这些区间的值，按照快照的格式编码，发送速度受 HandoffRate 限制
+//	POST _handoff?from={node}  从 from 拉取所有分组中属于这些区间的值，from 离开哈希环时使用
+//
+// 交接请求只接受签名校验通过或者使用双向TLS的请求
+func (p *ConnectHTTPPool) serveHandoff(w http.ResponseWriter, r *http.Request, group string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ranges, err := decodeRanges(io.LimitReader(r.Body, maxRangesBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if group == "" {
+		p.servePull(w, r, ranges)
+		return
+	}
+	g := cache.GetGroup(group)
+	if g == nil {
+		http.Error(w, "no such group: "+group, http.StatusNotFound)
+		return
+	}
+	ring := newRing()
+	lw := &limitedWriter{ctx: r.Context(), w: w, limiter: p.limiter}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	stats, err := g.ExportEntries(lw, func(key string) bool { return ranges.Contains(ring.Hash(key)) })
+	if err != nil {
+		// 响应已经开始发送，只能中断连接，接收方会发现数据不完整
+		p.logger.Warn("export handoff entries failed", "group", group, "ranges", len(ranges), "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	p.logger.Debug("export handoff entries", "group", group, "ranges", len(ranges), "entries", stats.Entries, "bytes", stats.Bytes)
+}
+
+// servePull 处理离开哈希环的节点发送的通知，拉取完成后返回拉取的结果。from 必须是当前哈希环上的其他节点，
+// 不会向任意地址发送请求，因此其他节点需要在离开的节点完成交接之后再将它从配置中删除
+func (p *ConnectHTTPPool) servePull(w http.ResponseWriter, r *http.Request, ranges hashes.Ranges) {
+	from := r.URL.Query().Get("from")
+	if from == p.self || !contains(p.Peers(), from) {
+		http.Error(w, "not a peer: "+from, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), p.opts.HandoffTimeout)
+	defer cancel()
+	entries, n, err := p.pull(ctx, from, ranges)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	p.logger.Info("pulled handoff entries", "from", from, "ranges", len(ranges), "entries", entries, "bytes", n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handoffResult{Entries: entries, Bytes: n})
+}
+
+// encodeRanges 将区间编码为文本，每行一个区间：起点 终点
+func encodeRanges(ranges hashes.Ranges) []byte {
+	var b []byte
+	for _, r := range ranges {
+		b = strconv.AppendUint(b, uint64(r.Start), 10)
+		b = append(b, ' ')
+		b = strconv.AppendUint(b, uint64(r.End), 10)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// decodeRanges 解析 encodeRanges 编码的区间，区间需要按照起点排列且互不重叠
+func decodeRanges(r io.Reader) (hashes.Ranges, error) {
+	var ranges hashes.Ranges
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		start, end, ok := strings.Cut(strings.TrimSpace(s.Text()), " ")
+		if !ok {
+			return nil, fmt.Errorf("bad range %q", s.Text())
+		}
+		a, err1 := strconv.ParseUint(start, 10, 32)
+		b, err2 := strconv.ParseUint(end, 10, 32)
+		if err1 != nil || err2 != nil || a > b {
+			return nil, fmt.Errorf("bad range %q", s.Text())
+		}
+		if n := len(ranges); n > 0 && uint64(ranges[n-1].End) >= a {
+			return nil, fmt.Errorf("ranges should be sorted and disjoint, got %q", s.Text())
+		}
+		ranges = append(ranges, hashes.Range{Start: uint32(a), End: uint32(b)})
+	}
+	return ranges, s.Err()
+}
+
+// newRing 新建包含 nodes 的哈希环，所有节点使用相同的虚拟节点数量和哈希函数
+func newRing(nodes ...string) *hashes.Map {
+	m := hashes.New(defaultReplicas, nil)
+	m.Add(nodes...)
+	return m
+}
+
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// without 返回去掉 node 之后的节点列表
+func without(nodes []string, node string) []string {
+	out := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if n != node {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// rateLimiter 令牌桶，限制每秒传输的字节数，所有交接共享同一个令牌桶。令牌可以透支，透支后等待令牌补足
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // rate 每秒补充的令牌数
+	tokens float64   // tokens 当前的令牌数，最多为 rate，可以为负数
+	last   time.Time // last 上一次补充令牌的时间
+}
+
+// newRateLimiter 新建令牌桶，rate 小于等于 0 时返回空，表示不限制
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait 取出 n 个令牌，令牌不足时等待，ctx 被取消时提前返回
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+	return sleep(ctx, d)
+}
+
+// limitedWriter 写入前从令牌桶中取出令牌
+type limitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if err := w.limiter.wait(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// countingReader 记录读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
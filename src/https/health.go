@@ -0,0 +1,220 @@
+package https
+
+import (
+	"context"
+	"jw-cache/src/metrics"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthPath       = "_health"       // 表示健康检查的路径，位于 basePath 之下
+	defaultFailureThreshold = 3               // 表示连续失败多少次后熔断该节点
+	defaultOpenTimeout      = 5 * time.Second // 表示熔断后经过多久允许再次尝试该节点
+	defaultProbeTimeout     = time.Second     // 表示一次健康检查请求的超时时间
+)
+
+type breakerState int
+
+const (
+	stateClosed   breakerState = iota // 关闭状态，请求正常通过
+	stateOpen                         // 打开状态，请求被拒绝
+	stateHalfOpen                     // 半开状态，只允许一个请求试探节点是否恢复
+)
+
+// circuitBreaker 节点熔断器，连续失败达到阈值后熔断，经过一段时间后进入半开状态试探节点是否恢复
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       breakerState  // state 熔断器当前的状态
+	failures    int           // failures 连续失败的次数
+	threshold   int           // threshold 连续失败多少次后熔断
+	openTimeout time.Duration // openTimeout 熔断持续的时间
+	openedAt    time.Time     // openedAt 最近一次熔断的时间
+	trial       bool          // trial 半开状态下是否已经放行了试探请求，试探请求完成之前拒绝其他请求
+	trialAt     time.Time     // trialAt 放行试探请求的时间
+}
+
+func newCircuitBreaker(threshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, openTimeout: openTimeout}
+}
+
+// Allow 判断当前是否允许向节点发送请求，熔断时间结束后会进入半开状态。半开状态下只放行一个试探请求，
+// 在它调用 Success 或 Failure 之前拒绝其他请求，避免仍然不可用的节点一次收到所有的请求；
+// 试探请求没有结果（例如被调用方取消）超过 openTimeout 时再放行一个
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = stateHalfOpen
+	case stateHalfOpen:
+		if b.trial && time.Since(b.trialAt) < b.openTimeout {
+			return false
+		}
+	default:
+		return true
+	}
+	b.trial = true
+	b.trialAt = time.Now()
+	return true
+}
+
+// Healthy 判断节点是否健康，与 Allow 不同，该方法不会改变熔断器的状态
+func (b *circuitBreaker) Healthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == stateClosed
+}
+
+// Success 记录一次成功的请求，关闭熔断器
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = stateClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure 记录一次失败的请求，失败次数达到阈值或处于半开状态时熔断
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		b.state = stateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// isPeerFailure 判断响应状态码是否说明节点本身不可用，业务错误不应该导致熔断
+func isPeerFailure(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// StartHealthCheck 开启健康检查，每隔 interval 主动探测一次所有其他节点，探测结果会更新节点的熔断器
+func (p *ConnectHTTPPool) StartHealthCheck(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopHealth != nil {
+		return
+	}
+	stop := make(chan struct{})
+	p.stopHealth = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.probeAll()
+			}
+		}
+	}()
+}
+
+// StopHealthCheck 停止健康检查
+func (p *ConnectHTTPPool) StopHealthCheck() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopHealth != nil {
+		close(p.stopHealth)
+		p.stopHealth = nil
+	}
+}
+
+// probeAll 并发探测所有其他节点
+func (p *ConnectHTTPPool) probeAll() {
+	p.mu.Lock()
+	getters := make([]*httpGetter, 0, len(p.httpGetter))
+	for node, getter := range p.httpGetter {
+		if node != p.self {
+			getters = append(getters, getter)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, getter := range getters {
+		wg.Add(1)
+		go func(getter *httpGetter) {
+			defer wg.Done()
+			if getter.probe() {
+				getter.breaker.Success()
+			} else {
+				getter.breaker.Failure()
+			}
+		}(getter)
+	}
+	wg.Wait()
+}
+
+// probe 向节点发送一次健康检查请求
+func (p *httpGetter) probe() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+defaultHealthPath, nil)
+	if err != nil {
+		return false
+	}
+	res, err := p.opts.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	return res.StatusCode == http.StatusOK
+}
+
+// Healthy 返回节点当前是否健康，未知的节点视为不健康
+func (p *ConnectHTTPPool) Healthy(node string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if node == p.self {
+		return true
+	}
+	getter, ok := p.httpGetter[node]
+	return ok && getter.breaker.Healthy()
+}
+
+// Collect 输出节点相关的指标，包括哈希环上的节点、每个节点是否健康以及请求延迟的直方图
+func (p *ConnectHTTPPool) Collect(w *metrics.Writer) {
+	p.mu.Lock()
+	nodes := make([]string, 0, len(p.httpGetter))
+	for node := range p.httpGetter {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	getters := make([]*httpGetter, len(nodes))
+	for i, node := range nodes {
+		getters[i] = p.httpGetter[node]
+	}
+	p.mu.Unlock()
+
+	w.Family("jwcache_ring_members", "gauge", "Number of nodes in the hash ring, including self.")
+	w.Sample("jwcache_ring_members", float64(len(nodes)))
+	w.Family("jwcache_peer_up", "gauge", "Whether the node is considered healthy by the circuit breaker.")
+	for i, node := range nodes {
+		up := 0.0
+		if node == p.self || getters[i].breaker.Healthy() {
+			up = 1
+		}
+		w.Sample("jwcache_peer_up", up, "peer", node)
+	}
+	w.Family("jwcache_peer_request_duration_seconds", "histogram", "Latency of requests sent to peers, including failed attempts.")
+	for i, node := range nodes {
+		if node != p.self {
+			w.Histogram("jwcache_peer_request_duration_seconds", getters[i].latency, "peer", node)
+		}
+	}
+}
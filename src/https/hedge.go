@@ -0,0 +1,89 @@
+package https
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	pb "jw-cache/src/cachepb"
+	"time"
+)
+
+// hedgedHeader 对冲请求的请求头，收到对冲请求的节点直接在本地加载数据，不再转发给其他节点，加载的值也不会放入它的缓存
+const hedgedHeader = "X-JWCache-Hedged"
+
+// hedgedGetter 对冲请求的实现，主节点在 delay 时间内没有返回时，同时向备用节点发送请求，取先成功返回的结果
+type hedgedGetter struct {
+	primary   *httpGetter   // primary 负责该 key 的节点
+	secondary *httpGetter   // secondary 哈希环上的下一个节点
+	delay     time.Duration // delay 发送对冲请求前等待的时间
+}
+
+type hedgedResult struct {
+	res *pb.Response
+	err error
+}
+
+// Get 先向主节点发送请求，超过 delay 时间或主节点请求失败时再向备用节点发送请求
+func (h *hedgedGetter) Get(in *pb.Request, out *pb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+// GetContext 与 Get 相同，两个请求都会携带 ctx 中的追踪上下文
+func (h *hedgedGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // 返回时取消还未完成的请求
+
+	results := make(chan hedgedResult, 2)
+	fetch := func(getter *httpGetter, hedged bool) {
+		res := &pb.Response{}
+		err := getter.get(ctx, in, res, hedged)
+		results <- hedgedResult{res: res, err: err}
+	}
+	go fetch(h.primary, false)
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	var firstErr error
+	for {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				proto.Merge(out, result.res)
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if !hedged {
+				hedged = true
+				pending++
+				go fetch(h.secondary, true)
+			}
+			if pending == 0 {
+				return firstErr
+			}
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				go fetch(h.secondary, true)
+			}
+		}
+	}
+}
+
+// String 返回主节点的地址，用于日志
+func (h *hedgedGetter) String() string {
+	return h.primary.String()
+}
+
+// Set 写入请求只发送给主节点
+func (h *hedgedGetter) Set(group string, key string, value []byte, ttl time.Duration) error {
+	return h.primary.Set(group, key, value, ttl)
+}
+
+// Delete 删除请求只发送给主节点
+func (h *hedgedGetter) Delete(group string, key string) error {
+	return h.primary.Delete(group, key)
+}
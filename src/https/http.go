@@ -1,18 +1,26 @@
 package https
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
 	"io"
 	"jw-cache/src/cache"
 	pb "jw-cache/src/cachepb"
 	"jw-cache/src/hashes"
+	"jw-cache/src/metrics"
 	"jw-cache/src/nodes"
-	"log"
+	"jw-cache/src/pgk/log"
+	"jw-cache/src/trace"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -20,36 +28,97 @@ const (
 	defaultReplicas = 50            // 表示默认的虚拟节点数，即每个节点在哈希环上的虚拟节点数，默认为50
 )
 
+// ttlHeader 写入请求中值的过期时间，单位为毫秒
+const ttlHeader = "X-JWCache-TTL-Ms"
+
+// maxValueBytes 其他节点一次写入的值的最大字节数，与客户端接口的限制相同
+const maxValueBytes = 32 << 20
+
 // ConnectHTTPPool HTTP连接池
 type ConnectHTTPPool struct {
 	self       string                 // self 表示该池的连接的URL地址，即当前节点的地址
 	basePath   string                 // basePath 表示该池的连接的基础路径，即缓存池中缓存项的URL前缀
 	mu         sync.Mutex             // mu 互斥锁，用于保护节点列表的并发访问
 	nodes      *hashes.Map            // nodes 哈希表，用于记录哈希值与节点的对应关系
+	peers      []string               // peers 哈希环上的所有节点
 	httpGetter map[string]*httpGetter // httpGetter 在当前节点获取不到缓存时，调用回调函数中其他节点获取
+	stopHealth chan struct{}          // stopHealth 用于停止健康检查
+	opts       HTTPPoolOptions        // opts 连接池的配置项
+	auth       *hmacAuth              // auth 节点之间请求的签名校验，为空时不校验
+	logger     log.Logger             // logger 日志，每条日志都带有当前节点的地址
+	limiter    *rateLimiter           // limiter 限制交接数据的发送速度，为空时不限制
 }
 
-// NewHTTPPool 新建连接池
+// NewHTTPPool 新建连接池，使用默认的配置项
 func NewHTTPPool(self string) *ConnectHTTPPool {
-	return &ConnectHTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+	return NewHTTPPoolOpts(self, nil)
+}
+
+// NewHTTPPoolOpts 根据配置项新建连接池，opts 为空时使用默认的配置项
+func NewHTTPPoolOpts(self string, opts *HTTPPoolOptions) *ConnectHTTPPool {
+	p := &ConnectHTTPPool{self: self}
+	if opts != nil {
+		p.opts = *opts
+	}
+	p.opts = p.opts.withDefaults()
+	p.basePath = p.opts.BasePath
+	p.logger = log.With(p.opts.Logger, "self", self)
+	if len(p.opts.SharedSecret) > 0 {
+		p.auth = newHMACAuth(p.opts.SharedSecret, p.opts.ReplayWindow)
 	}
+	p.limiter = newRateLimiter(p.opts.HandoffRate)
+	return p
 }
 
-// Log 打印日志
+// BasePath 返回连接池处理的请求前缀
+func (p *ConnectHTTPPool) BasePath() string {
+	return p.basePath
+}
+
+// Mount 将连接池挂载到已有的 mux 上，连接池只处理 basePath 下的请求
+func (p *ConnectHTTPPool) Mount(mux *http.ServeMux) {
+	mux.Handle(p.basePath, p)
+}
+
+// Log 打印 info 级别的日志，需要附加字段时使用 HTTPPoolOptions.Logger
 func (p *ConnectHTTPPool) Log(format string, v ...interface{}) {
-	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+	p.logger.Info(fmt.Sprintf(format, v...))
 }
 
 // ServerHTTP HTTP 请求解析
 func (p *ConnectHTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 请求需要请求前缀
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
-		panic("ConnectHTTPPool serving unexpected path: " + r.URL.Path)
+		http.Error(w, "unexpected path: "+r.URL.Path, http.StatusNotFound)
+		return
 	}
-	p.Log("%s %s", r.Method, r.URL.Path)
-	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
+	p.logger.Debug("serve peer request", "method", r.Method, "path", r.URL.Path)
+	if r.URL.Path[len(p.basePath):] == defaultHealthPath {
+		w.Write([]byte("ok"))
+		return
+	}
+	// 校验签名时需要读取请求体，先限制大小，避免未经认证的请求让节点读取任意大小的数据
+	r.Body = http.MaxBytesReader(w, r.Body, maxValueBytes)
+	if p.auth != nil {
+		if err := p.auth.Verify(r); err != nil {
+			if tooLarge(w, err) {
+				return
+			}
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	rest := r.URL.Path[len(p.basePath):]
+	if rest == defaultHandoffPath || strings.HasPrefix(rest, defaultHandoffPath+"/") {
+		if !p.authenticated(r) {
+			// 交接请求可以导出所有的值，或者让当前节点从其他节点拉取数据写入所有分组，必须确认请求来自其他节点
+			http.Error(w, "handoff requires shared secret or mutual TLS", http.StatusForbidden)
+			return
+		}
+		p.serveHandoff(w, r, strings.TrimPrefix(strings.TrimPrefix(rest, defaultHandoffPath), "/"))
+		return
+	}
+	parts := strings.SplitN(rest, "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -62,69 +131,278 @@ func (p *ConnectHTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key)
+	switch r.Method {
+	case http.MethodGet:
+		p.serveGet(w, r, group, key)
+	case http.MethodPut:
+		p.servePut(w, r, group, key)
+	case http.MethodDelete:
+		if err := group.DeleteLocal(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticated 判断请求是否来自其他节点：配置了共享密钥时签名已经校验通过，或者请求使用了校验通过的客户端证书
+func (p *ConnectHTTPPool) authenticated(r *http.Request) bool {
+	return p.auth != nil || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0)
+}
 
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()}) // 将消息对象序列化成二进制数据
+const (
+	valueField    protowire.Number = 1 // pb.Response 中 value 字段的编号
+	encodingField protowire.Number = 2 // pb.Response 中 encoding 字段的编号
+)
+
+// serveGet 处理其他节点获取值的请求
+func (p *ConnectHTTPPool) serveGet(w http.ResponseWriter, r *http.Request, group *cache.Group, key string) {
+	// 请求头中携带了追踪上下文时，该节点上的 span 与请求方属于同一个调用链
+	ctx, span := trace.Start(trace.Extract(r.Context(), r.Header), "jwcache.peer.serve")
+	defer span.End()
+	var view cache.ByteView
+	var err error
+	if r.Header.Get(hedgedHeader) != "" {
+		// 当前节点不是 key 的所有者，加载的值不放入缓存
+		view, err = group.GetLocalUncachedContext(ctx, key)
+	} else {
+		view, err = group.GetContext(ctx, key)
+	}
 	if err != nil {
+		span.RecordError(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 手动编码 pb.Response，先写入字段头，再直接将缓存中的数据写入响应，避免为每个请求拷贝一次数据，
+	// 压缩后的数据原样发送，由接收方解压
+	header := protowire.AppendTag(nil, valueField, protowire.BytesType)
+	header = protowire.AppendVarint(header, uint64(view.Len()))
+	var trailer []byte
+	if encoding := view.Encoding(); encoding != cache.EncodingIdentity {
+		trailer = protowire.AppendTag(nil, encodingField, protowire.VarintType)
+		trailer = protowire.AppendVarint(trailer, uint64(encoding))
 	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
-	//w.Write(view.ByteSlice())
-	w.Write(body)
+	w.Header().Set("Content-Length", strconv.Itoa(len(header)+view.Len()+len(trailer)))
+	w.Write(header)
+	view.WriteEncodedTo(w)
+	w.Write(trailer)
+}
+
+// servePut 处理其他节点写入值的请求，请求体为值本身，过期时间通过请求头传递
+func (p *ConnectHTTPPool) servePut(w http.ResponseWriter, r *http.Request, group *cache.Group, key string) {
+	var ttl time.Duration
+	if s := r.Header.Get(ttlHeader); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || ms < 0 {
+			http.Error(w, "bad ttl: "+s, http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(ms) * time.Millisecond
+	}
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		if !tooLarge(w, err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if err := group.SetLocal(key, value, ttl); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tooLarge 请求体超过 maxValueBytes 时返回 413 和 true
+func tooLarge(w http.ResponseWriter, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("value is larger than %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+	return true
 }
 
 // Set 设置节点(初始化传入节点)，建立节点与哈希值的映射关系
 func (p *ConnectHTTPPool) Set(nodes ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.nodes = hashes.New(defaultReplicas, nil)
-	p.nodes.Add(nodes...)
-	p.httpGetter = make(map[string]*httpGetter, len(nodes))
+	p.nodes = newRing(nodes...)
+	p.peers = append([]string(nil), nodes...)
+	getters := make(map[string]*httpGetter, len(nodes))
 	for _, node := range nodes {
-		p.httpGetter[node] = &httpGetter{baseURL: node + p.basePath}
+		// 节点列表变化时保留已有节点的熔断器和请求延迟，重新加载配置不会让已经熔断的节点恢复
+		if old, ok := p.httpGetter[node]; ok {
+			getters[node] = old
+			continue
+		}
+		getters[node] = &httpGetter{
+			node:    node,
+			baseURL: node + p.basePath,
+			breaker: newCircuitBreaker(defaultFailureThreshold, defaultOpenTimeout),
+			opts:    p.opts,
+			auth:    p.auth,
+			latency: metrics.NewHistogram(nil),
+		}
 	}
+	p.httpGetter = getters
 }
 
-// PickNode 当在当前节点获取不到值时，选择一个最可能获取到值的节点
+// PickNode 当在当前节点获取不到值时，选择一个最可能获取到值的节点，被熔断的节点会被跳过，由哈希环上的下一个节点代替
 func (p *ConnectHTTPPool) PickNode(key string) (nodes.NodeGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if node := p.nodes.Get(key); node != "" && node != p.self {
-		p.Log("Pick Node %s", node)
-		return p.httpGetter[node], true
+	if p.nodes == nil {
+		return nil, false
+	}
+	node := p.nodes.GetWithFilter(key, func(node string) bool {
+		return node == p.self || p.httpGetter[node].breaker.Allow()
+	})
+	if node == "" || node == p.self {
+		return nil, false
+	}
+	p.logger.Debug("pick node", "key", key, "node", node)
+	if p.opts.HedgeDelay > 0 {
+		// 备用节点为哈希环上的下一个健康的其他节点
+		secondary := p.nodes.GetWithFilter(key, func(n string) bool {
+			return n != node && n != p.self && p.httpGetter[n].breaker.Healthy()
+		})
+		if secondary != "" {
+			return &hedgedGetter{
+				primary:   p.httpGetter[node],
+				secondary: p.httpGetter[secondary],
+				delay:     p.opts.HedgeDelay,
+			}, true
+		}
 	}
-	return nil, false
+	return p.httpGetter[node], true
 }
 
 // httpGetter 主要实现实现实际的发送请求到真实节点去获取值的操作
 type httpGetter struct {
-	baseURL string
+	node    string             // node 节点的地址
+	baseURL string             // baseURL 节点的地址加上路径前缀
+	breaker *circuitBreaker    // breaker 节点的熔断器
+	opts    HTTPPoolOptions    // opts 连接池的配置项
+	auth    *hmacAuth          // auth 请求签名，为空时不签名
+	latency *metrics.Histogram // latency 每次请求的延迟，包括失败的请求
+}
+
+// String 返回节点的地址，用于日志
+func (p *httpGetter) String() string {
+	return p.node
 }
 
 // Get 发送http请求去其他节点获取值
 func (p *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	// /baseURL?group=group&key=key
-	u := fmt.Sprintf("%v%v/%v",
-		p.baseURL,
-		url.QueryEscape(in.Group),
-		url.QueryEscape(in.Key))
-	res, err := http.Get(u)
+	return p.GetContext(context.Background(), in, out)
+}
+
+// GetContext 发送http请求去其他节点获取值，ctx 中的追踪上下文通过请求头传递给该节点
+func (p *httpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return p.get(ctx, in, out, false)
+}
+
+// Set 发送http请求将值写入其他节点，ttl 为 0 时表示永不过期
+func (p *httpGetter) Set(group string, key string, value []byte, ttl time.Duration) error {
+	header := http.Header{}
+	if ttl > 0 {
+		header.Set(ttlHeader, strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+	_, err := p.do(context.Background(), http.MethodPut, group, key, value, header)
+	return err
+}
+
+// Delete 发送http请求删除其他节点中的值
+func (p *httpGetter) Delete(group string, key string) error {
+	_, err := p.do(context.Background(), http.MethodDelete, group, key, nil, nil)
+	return err
+}
+
+// get 发送http请求去其他节点获取值
+func (p *httpGetter) get(ctx context.Context, in *pb.Request, out *pb.Response, hedged bool) error {
+	var header http.Header
+	if hedged {
+		header = http.Header{hedgedHeader: []string{"1"}}
+	}
+	bytes, err := p.do(ctx, http.MethodGet, in.Group, in.Key, nil, header)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
-	}
-
-	bytes, err := io.ReadAll(res.Body)
 	if err = proto.Unmarshal(bytes, out); err != nil { // proto.Unmarshal() 将二进制数据反序列化为消息对象
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 	return nil
 }
 
+// do 发送http请求，GET 请求在连接失败或节点不可用时按照退避时间重试，节点被熔断后不再重试。
+// PUT 和 DELETE 不重试：超时的请求可能已经生效，重试的 DELETE 可能会删除之后写入的值
+func (p *httpGetter) do(ctx context.Context, method, group, key string, body []byte, header http.Header) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		res, retry, err := p.send(ctx, method, group, key, body, header)
+		if err == nil || !retry || method != http.MethodGet || attempt >= p.opts.Retries || !p.breaker.Allow() {
+			return res, err
+		}
+		if err := sleep(ctx, p.opts.backoff(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send 发送一次http请求并返回响应体，返回的 retry 表示该错误是否可以重试
+func (p *httpGetter) send(ctx context.Context, method, group, key string, body []byte, header http.Header) (res []byte, retry bool, err error) {
+	// /baseURL/group/key
+	u := fmt.Sprintf("%v%v/%v",
+		p.baseURL,
+		url.PathEscape(group),
+		url.PathEscape(key))
+	reqCtx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	trace.Inject(ctx, req.Header)
+	if p.auth != nil {
+		if err := p.auth.Sign(req, body); err != nil {
+			return nil, false, err
+		}
+	}
+	start := time.Now()
+	response, err := p.opts.Client.Do(req)
+	p.latency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		// 请求被调用方主动取消时，不能说明节点不可用
+		if ctx.Err() == nil {
+			p.breaker.Failure()
+		}
+		return nil, true, err
+	}
+	defer response.Body.Close()
+	if isPeerFailure(response.StatusCode) {
+		p.breaker.Failure()
+		return nil, true, fmt.Errorf("server returned: %v", response.Status)
+	}
+	p.breaker.Success()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		return nil, false, fmt.Errorf("server returned: %v", response.Status)
+	}
+
+	res, err = io.ReadAll(response.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("reading response body: %v", err)
+	}
+	return res, false, nil
+}
+
 // Get 发送http请求去其他节点获取值
 //func (p *httpGetter) Get(group string, key string) ([]byte, error) {
 //	// /baseURL?group=group&key=key
@@ -0,0 +1,106 @@
+package https
+
+import (
+	"context"
+	"crypto/tls"
+	"jw-cache/src/pgk/log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout         = 3 * time.Second        // 表示默认的单次请求超时时间
+	defaultRetryBackoff    = 20 * time.Millisecond  // 表示默认的重试基础退避时间
+	defaultMaxRetryBackoff = 500 * time.Millisecond // 表示默认的最大重试退避时间
+	defaultMaxIdleConns    = 64                     // 表示默认的每个节点的最大空闲连接数
+)
+
+// HTTPPoolOptions 连接池的配置项，零值表示使用默认配置
+type HTTPPoolOptions struct {
+	BasePath        string          // BasePath 节点之间请求的路径前缀，所有节点需要保持一致，默认为 "/_jw_cache/"
+	Transport       *http.Transport // Transport 自定义的连接配置，只有 Client 为空时生效
+	Client          *http.Client    // Client 自定义的HTTP客户端，用于向其他节点发送请求
+	Timeout         time.Duration   // Timeout 单次请求的超时时间，包括每一次重试
+	Retries         int             // Retries 读取失败后的最大重试次数，只有连接失败或节点不可用时才会重试，写入和删除不重试
+	RetryBackoff    time.Duration   // RetryBackoff 重试的基础退避时间，每次重试翻倍并加入随机抖动
+	MaxRetryBackoff time.Duration   // MaxRetryBackoff 重试的最大退避时间
+	HedgeDelay      time.Duration   // HedgeDelay 请求超过该时间仍未返回时，向哈希环上的下一个节点发送对冲请求，为 0 时不开启
+	TLSConfig       *tls.Config     // TLSConfig 向其他节点发送请求时使用的TLS配置，用于双向TLS认证，只有 Client 为空时生效
+	SharedSecret    []byte          // SharedSecret 节点之间共享的密钥，不为空时对节点之间的请求进行签名和校验
+	ReplayWindow    time.Duration   // ReplayWindow 签名时间戳的有效窗口，默认为 30 秒
+	HandoffRate     int64           // HandoffRate 向其他节点交接数据时每秒发送的最大字节数，默认为 16MB，小于 0 时不限制
+	HandoffTimeout  time.Duration   // HandoffTimeout 一次交接的最长时间，默认为 1 分钟
+	Logger          log.Logger      // Logger 连接池的日志，默认为 log.Default()
+}
+
+// withDefaults 返回填充了默认值的配置项
+func (o HTTPPoolOptions) withDefaults() HTTPPoolOptions {
+	if o.BasePath == "" {
+		o.BasePath = defaultBasePath
+	}
+	// 路径前缀需要以 "/" 开头和结尾
+	if !strings.HasPrefix(o.BasePath, "/") {
+		o.BasePath = "/" + o.BasePath
+	}
+	if !strings.HasSuffix(o.BasePath, "/") {
+		o.BasePath += "/"
+	}
+	if o.Client == nil {
+		transport := o.Transport
+		if transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+			transport.MaxIdleConnsPerHost = defaultMaxIdleConns
+		}
+		if o.TLSConfig != nil {
+			transport = transport.Clone()
+			transport.TLSClientConfig = o.TLSConfig
+		}
+		o.Client = &http.Client{Transport: transport}
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if o.HandoffRate == 0 {
+		o.HandoffRate = defaultHandoffRate
+	}
+	if o.HandoffTimeout <= 0 {
+		o.HandoffTimeout = defaultHandoffTimeout
+	}
+	if o.Logger == nil {
+		o.Logger = log.Default()
+	}
+	return o
+}
+
+// backoff 计算第 attempt 次重试前需要等待的时间，在 [d/2, d) 之间随机抖动，避免多个请求同时重试
+func (o HTTPPoolOptions) backoff(attempt int) time.Duration {
+	d := o.RetryBackoff << attempt
+	if d <= 0 || d > o.MaxRetryBackoff {
+		d = o.MaxRetryBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// sleep 等待 d 时间，ctx 被取消时提前返回
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import "jw-cache/src/cache"
+
+// groupMetric 分组的一个指标
+type groupMetric struct {
+	name  string
+	typ   string
+	help  string
+	value func(s cache.Stats) float64
+}
+
+var groupMetrics = []groupMetric{
+	{"jwcache_group_gets_total", "counter", "Number of Get calls, including requests from peers.",
+		func(s cache.Stats) float64 { return float64(s.Gets) }},
+	{"jwcache_group_hits_total", "counter", "Number of Get calls served from the local cache.",
+		func(s cache.Stats) float64 { return float64(s.Hits) }},
+	{"jwcache_group_misses_total", "counter", "Number of Get calls that missed the local cache.",
+		func(s cache.Stats) float64 { return float64(s.Gets - s.Hits) }},
+	{"jwcache_group_l2_hits_total", "counter", "Number of Get calls served from the disk tier after a memory miss.",
+		func(s cache.Stats) float64 { return float64(s.L2Hits) }},
+	{"jwcache_group_loads_total", "counter", "Number of loads after a miss, including deduplicated ones.",
+		func(s cache.Stats) float64 { return float64(s.Loads) }},
+	{"jwcache_group_deduped_loads_total", "counter", "Number of loads that reused an in-flight load.",
+		func(s cache.Stats) float64 { return float64(s.DedupedLoads) }},
+	{"jwcache_group_peer_loads_total", "counter", "Number of values loaded from peers.",
+		func(s cache.Stats) float64 { return float64(s.PeerLoads) }},
+	{"jwcache_group_peer_errors_total", "counter", "Number of failed loads from peers.",
+		func(s cache.Stats) float64 { return float64(s.PeerErrors) }},
+	{"jwcache_group_local_loads_total", "counter", "Number of values loaded by the getter.",
+		func(s cache.Stats) float64 { return float64(s.LocalLoads) }},
+	{"jwcache_group_load_errors_total", "counter", "Number of failed loads by the getter.",
+		func(s cache.Stats) float64 { return float64(s.LoadErrors) }},
+	{"jwcache_group_inflight_loads", "gauge", "Number of loads currently in flight in singleflight.",
+		func(s cache.Stats) float64 { return float64(s.InFlightLoads) }},
+	{"jwcache_group_items", "gauge", "Number of items in the local cache.",
+		func(s cache.Stats) float64 { return float64(s.Items) }},
+	{"jwcache_group_bytes", "gauge", "Bytes used by the local cache.",
+		func(s cache.Stats) float64 { return float64(s.Bytes) }},
+	{"jwcache_group_max_bytes", "gauge", "Maximum bytes of the local cache, 0 means unlimited.",
+		func(s cache.Stats) float64 { return float64(s.MaxBytes) }},
+	{"jwcache_group_l2_items", "gauge", "Number of items in the disk tier.",
+		func(s cache.Stats) float64 { return float64(s.L2Items) }},
+	{"jwcache_group_l2_bytes", "gauge", "Bytes of segment files in the disk tier, including dead records.",
+		func(s cache.Stats) float64 { return float64(s.L2Bytes) }},
+	{"jwcache_group_l2_max_bytes", "gauge", "Maximum bytes of the disk tier, 0 means disabled.",
+		func(s cache.Stats) float64 { return float64(s.L2MaxBytes) }},
+}
+
+// Groups 收集所有分组的统计信息
+var Groups = CollectorFunc(func(w *Writer) {
+	names := cache.GroupNames()
+	stats := make([]cache.Stats, len(names))
+	for i, name := range names {
+		if g := cache.GetGroup(name); g != nil {
+			stats[i] = g.Stats()
+		}
+	}
+	for _, m := range groupMetrics {
+		w.Family(m.name, m.typ, m.help)
+		for i, name := range names {
+			w.Sample(m.name, m.value(stats[i]), "group", name)
+		}
+	}
+	w.Family("jwcache_group_evictions_total", "counter", "Number of evicted items by reason.")
+	for i, name := range names {
+		w.Sample("jwcache_group_evictions_total", float64(stats[i].EvictedCapacity), "group", name, "reason", "capacity")
+		w.Sample("jwcache_group_evictions_total", float64(stats[i].EvictedExpired), "group", name, "reason", "expired")
+	}
+})
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets 默认的延迟直方图分桶，单位为秒，从 0.5ms 到 5s
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Histogram 并发安全的直方图，分桶的上界在创建后不能修改
+type Histogram struct {
+	buckets []float64       // buckets 每个分桶的上界，按照从小到大排列
+	counts  []atomic.Uint64 // counts 落在每个分桶中的观测值数量，最后一个为 +Inf
+	sum     atomic.Uint64   // sum 所有观测值之和，以 float64 的二进制形式保存
+	count   atomic.Uint64   // count 观测值的数量
+}
+
+// NewHistogram 根据分桶的上界创建直方图，buckets 为空时使用 DefaultLatencyBuckets
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{buckets: b, counts: make([]atomic.Uint64, len(b)+1)}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+	h.count.Add(1)
+}
+
+// Collector 指标的收集器，每次抓取时调用 Collect 写入当前的指标
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc 函数类型，实现了 Collector 接口
+type CollectorFunc func(w *Writer)
+
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Writer 按照 Prometheus 文本格式写入指标，同一个指标的所有样本需要在 Family 之后连续写入
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter 创建写入 w 的 Writer
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Family 写入指标的说明和类型，typ 为 counter、gauge 或 histogram
+func (w *Writer) Family(name, typ, help string) {
+	io.WriteString(w.w, "# HELP "+name+" "+escapeHelp(help)+"\n# TYPE "+name+" "+typ+"\n")
+}
+
+// Sample 写入一个样本，labels 为标签名和标签值交替排列
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	io.WriteString(w.w, name+formatLabels(labels)+" "+formatValue(value)+"\n")
+}
+
+// Histogram 写入直方图的所有样本，包括每个分桶的累计数量、总和以及数量
+func (w *Writer) Histogram(name string, h *Histogram, labels ...string) {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := math.Inf(1)
+		if i < len(h.buckets) {
+			le = h.buckets[i]
+		}
+		w.Sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", formatValue(le))...)
+	}
+	w.Sample(name+"_sum", math.Float64frombits(h.sum.Load()), labels...)
+	w.Sample(name+"_count", float64(h.count.Load()), labels...)
+}
+
+// formatLabels 将标签格式化为 {name="value",...}
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// Handler 返回输出指标的 HTTP 处理器，每次请求时依次调用所有的收集器
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		writer := NewWriter(&buf)
+		for _, c := range collectors {
+			c.Collect(writer)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
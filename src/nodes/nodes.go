@@ -1,7 +1,9 @@
 package nodes
 
 import (
+	"context"
 	pb "jw-cache/src/cachepb"
+	"time"
 )
 
 type NodePicker interface { // 节点选择器接口
@@ -15,3 +17,12 @@ type NodePicker interface { // 节点选择器接口
 type NodeGetter interface { // 从远程节点获取值
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+type ContextNodeGetter interface { // 携带 context 从远程节点获取值，用于传递追踪上下文，NodeGetter 可以选择实现该接口
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+type NodeWriter interface { // 向远程节点写入或删除值，NodeGetter 可以选择实现该接口
+	Set(group string, key string, value []byte, ttl time.Duration) error
+	Delete(group string, key string) error
+}
@@ -0,0 +1,134 @@
+// Package log 可注入的结构化日志，接口与 log/slog 中的 *slog.Logger 兼容，
+// 导入时不会读取配置或打开文件，需要写入文件时通过 New 显式创建并调用 SetDefault
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Logger 结构化日志接口，args 为交替出现的键和值，例如 Warn("get from node failed", "group", "scores", "key", "Tom")，
+// *slog.Logger 直接实现了该接口
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// loggerHolder 用于在 atomic.Value 中保存不同类型的 Logger
+type loggerHolder struct {
+	logger Logger
+}
+
+var std atomic.Value
+
+func init() {
+	// 默认只输出到标准错误，不读取配置也不创建文件
+	std.Store(loggerHolder{logger: newText(os.Stderr, LevelInfo)})
+}
+
+// SetDefault 设置全局的 Logger，为 nil 时丢弃所有日志
+func SetDefault(l Logger) {
+	if l == nil {
+		l = Discard
+	}
+	std.Store(loggerHolder{logger: l})
+}
+
+// Default 返回转发到全局 Logger 的 Logger，之后调用 SetDefault 时同样生效，因此可以在初始化时保存下来
+func Default() Logger {
+	return defaultLogger{}
+}
+
+// current 返回当前的全局 Logger
+func current() Logger {
+	return std.Load().(loggerHolder).logger
+}
+
+// defaultLogger 转发到全局的 Logger
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, args ...interface{}) { current().Debug(msg, args...) }
+func (defaultLogger) Info(msg string, args ...interface{})  { current().Info(msg, args...) }
+func (defaultLogger) Warn(msg string, args ...interface{})  { current().Warn(msg, args...) }
+func (defaultLogger) Error(msg string, args ...interface{}) { current().Error(msg, args...) }
+
+// Discard 丢弃所有日志的 Logger
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...interface{}) {}
+func (discard) Info(string, ...interface{})  {}
+func (discard) Warn(string, ...interface{})  {}
+func (discard) Error(string, ...interface{}) {}
+
+// With 返回在每条日志中附加 args 的 Logger，适用于任意实现了 Logger 接口的日志
+func With(l Logger, args ...interface{}) Logger {
+	if len(args) == 0 {
+		return l
+	}
+	return withLogger{logger: l, args: args}
+}
+
+// withLogger 在每条日志前附加固定的键值对
+type withLogger struct {
+	logger Logger
+	args   []interface{}
+}
+
+func (l withLogger) join(args []interface{}) []interface{} {
+	all := make([]interface{}, 0, len(l.args)+len(args))
+	return append(append(all, l.args...), args...)
+}
+
+func (l withLogger) Debug(msg string, args ...interface{}) { l.logger.Debug(msg, l.join(args)...) }
+func (l withLogger) Info(msg string, args ...interface{})  { l.logger.Info(msg, l.join(args)...) }
+func (l withLogger) Warn(msg string, args ...interface{})  { l.logger.Warn(msg, l.join(args)...) }
+func (l withLogger) Error(msg string, args ...interface{}) { l.logger.Error(msg, l.join(args)...) }
+
+// LevelSetter 可以在运行时修改日志级别的 Logger，例如 *TextLogger
+type LevelSetter interface {
+	SetLevel(name string) error
+	Level() string
+}
+
+// SetLevel 修改全局 Logger 的日志级别，全局的 Logger 不支持修改时返回错误
+func SetLevel(name string) error {
+	setter, ok := current().(LevelSetter)
+	if !ok {
+		return fmt.Errorf("default logger %T does not support changing level", current())
+	}
+	return setter.SetLevel(name)
+}
+
+// GetLevel 返回全局 Logger 的日志级别，全局的 Logger 不支持修改时 ok 为 false
+func GetLevel() (name string, ok bool) {
+	setter, ok := current().(LevelSetter)
+	if !ok {
+		return "", false
+	}
+	return setter.Level(), true
+}
+
+// Debug 使用全局的 Logger 输出 debug 级别的日志
+func Debug(msg string, args ...interface{}) {
+	current().Debug(msg, args...)
+}
+
+// Info 使用全局的 Logger 输出 info 级别的日志
+func Info(msg string, args ...interface{}) {
+	current().Info(msg, args...)
+}
+
+// Warn 使用全局的 Logger 输出 warn 级别的日志
+func Warn(msg string, args ...interface{}) {
+	current().Warn(msg, args...)
+}
+
+// Error 使用全局的 Logger 输出 error 级别的日志
+func Error(msg string, args ...interface{}) {
+	current().Error(msg, args...)
+}
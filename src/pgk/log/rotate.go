@@ -0,0 +1,243 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logSuffix  = ".log" // 表示日志文件的后缀
+	gzipSuffix = ".gz"  // 表示压缩后的日志文件的后缀
+)
+
+// rotateWriter 按时间和大小切分的日志文件。文件名为 file_format 格式化后的当前时间，时间变化时切换到新的文件，
+// 例如 "20060102" 每天一个文件、"2006010215" 每小时一个文件；文件超过 maxSize 时重命名为 "<名称>.<序号>.log"。
+// 切分出的旧文件会在后台压缩，并且只保留最新的 maxBackups 个
+type rotateWriter struct {
+	dir        string
+	format     string
+	maxSize    int64 // 单个文件的最大字节数，为 0 时不按大小切分
+	maxBackups int   // 保留的旧文件数量，为 0 时全部保留
+	compress   bool  // 是否压缩旧文件
+
+	mu   sync.Mutex
+	file *os.File // 当前写入的文件
+	name string   // 当前文件按时间格式化后的名称，不包括目录和后缀
+	size int64    // 当前文件的大小
+
+	millMu sync.Mutex     // 保证同时只有一个 goroutine 在压缩和清理旧文件
+	millWG sync.WaitGroup // 等待后台的压缩和清理结束
+}
+
+// newRotateWriter 创建目录并打开当前的日志文件
+func newRotateWriter(opts Options) (*rotateWriter, error) {
+	w := &rotateWriter{
+		dir:        opts.Dir,
+		format:     opts.FileFormat,
+		maxSize:    opts.MaxSize,
+		maxBackups: opts.MaxBackups,
+		compress:   opts.Compress,
+	}
+	if w.format == "" {
+		w.format = defaultFileFormat
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating log dir: %v", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.open(time.Now().Format(w.format)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// path 返回当前时间对应的日志文件路径
+func (w *rotateWriter) path(name string) string {
+	return filepath.Join(w.dir, name+logSuffix)
+}
+
+// open 打开（或创建）name 对应的日志文件，需要持有 mu
+func (w *rotateWriter) open(name string) error {
+	file, err := os.OpenFile(w.path(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("opening log file: %v", err)
+	}
+	w.file, w.name, w.size = file, name, info.Size()
+	return nil
+}
+
+// Write 写入一条日志，写入前检查是否需要切换文件
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	name := time.Now().Format(w.format)
+	if name != w.name {
+		if err := w.rotate(name, false); err != nil {
+			return 0, err
+		}
+	} else if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(name, true); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate 关闭当前文件并打开 name 对应的文件，bySize 为 true 时先将当前文件重命名，需要持有 mu
+func (w *rotateWriter) rotate(name string, bySize bool) error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if bySize {
+		if err := os.Rename(w.path(w.name), w.backupPath(w.name)); err != nil {
+			return fmt.Errorf("rotating log file: %v", err)
+		}
+	}
+	if err := w.open(name); err != nil {
+		w.file = nil
+		return err
+	}
+	w.millWG.Add(1)
+	go w.mill()
+	return nil
+}
+
+// backupPath 返回按大小切分时旧文件的路径，序号从 1 开始，跳过已经存在（包括已经压缩）的文件
+func (w *rotateWriter) backupPath(name string) string {
+	for i := 1; ; i++ {
+		path := filepath.Join(w.dir, name+"."+strconv.Itoa(i)+logSuffix)
+		if !exists(path) && !exists(path+gzipSuffix) {
+			return path
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// mill 压缩旧文件并删除超过保留数量的文件，错误会被忽略，下一次切分时重试
+func (w *rotateWriter) mill() {
+	defer w.millWG.Done()
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+	backups := w.backups()
+	if w.maxBackups > 0 && len(backups) > w.maxBackups {
+		for _, b := range backups[w.maxBackups:] {
+			os.Remove(b.path)
+		}
+		backups = backups[:w.maxBackups]
+	}
+	if w.compress {
+		for _, b := range backups {
+			if strings.HasSuffix(b.path, logSuffix) {
+				compressFile(b.path)
+			}
+		}
+	}
+}
+
+// backup 切分出的旧文件
+type backup struct {
+	path    string
+	modTime time.Time
+}
+
+// backups 返回目录中除当前文件之外的日志文件，按照修改时间从新到旧排列
+func (w *rotateWriter) backups() []backup {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil
+	}
+	w.mu.Lock()
+	active := w.name + logSuffix
+	w.mu.Unlock()
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || name == active || !(strings.HasSuffix(name, logSuffix) || strings.HasSuffix(name, logSuffix+gzipSuffix)) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(w.dir, name), modTime: info.ModTime()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].modTime.Equal(backups[j].modTime) {
+			return backups[i].modTime.After(backups[j].modTime)
+		}
+		return backups[i].path > backups[j].path
+	})
+	return backups
+}
+
+// compressFile 将文件压缩为 "<path>.gz" 并删除原文件，压缩后的文件保留原文件的修改时间
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := path + gzipSuffix + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if e := zw.Close(); err == nil {
+		err = e
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, path+gzipSuffix); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close 关闭当前文件，并等待后台的压缩和清理结束
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.millWG.Wait()
+	return err
+}
@@ -0,0 +1,198 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Level 日志级别
+type Level = logrus.Level
+
+const (
+	LevelDebug = logrus.DebugLevel
+	LevelInfo  = logrus.InfoLevel
+	LevelWarn  = logrus.WarnLevel
+	LevelError = logrus.ErrorLevel
+)
+
+const defaultFileFormat = "20060102" // 表示默认的日志文件名格式，每天一个文件
+
+var levels = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+// ParseLevel 根据名称返回日志级别，空字符串表示 info
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	if level, ok := levels[strings.ToLower(name)]; ok {
+		return level, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, want debug, info, warn or error", name)
+}
+
+// Options 日志的配置项，零值表示只输出 info 及以上级别的日志到 Output
+type Options struct {
+	Level      string    // Level 日志级别，可选 debug、info、warn、error，默认为 info
+	Dir        string    // Dir 日志文件的目录，不存在时会自动创建，为空时不写入文件，目录中的 .log 文件都会被视为旧的日志文件
+	FileFormat string    // FileFormat 日志文件名的时间格式，时间变化时切换到新的文件，默认为 "20060102"，即每天一个文件
+	MaxSize    int64     // MaxSize 单个日志文件的最大字节数，超过后切换到新的文件，为 0 时不限制
+	MaxBackups int       // MaxBackups 保留的旧日志文件数量，为 0 时全部保留
+	Compress   bool      // Compress 是否使用 gzip 压缩旧的日志文件
+	Output     io.Writer // Output 除文件之外的输出，例如 os.Stdout，为空时不输出
+}
+
+// TextLogger 基于 logrus 的文本日志，格式为 "[时间] [级别] 消息 key=value ..."
+type TextLogger struct {
+	logger *logrus.Logger
+	file   io.Closer // file 日志文件，没有写入文件时为空
+}
+
+// New 根据配置项创建日志，配置了 Dir 时写入按时间和大小切分的日志文件，使用完后需要调用 Close
+func New(opts Options) (*TextLogger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxSize < 0 || opts.MaxBackups < 0 {
+		return nil, fmt.Errorf("max size and max backups of log should not be negative")
+	}
+	var writers []io.Writer
+	var file *rotateWriter
+	if opts.Dir != "" {
+		if file, err = newRotateWriter(opts); err != nil {
+			return nil, err
+		}
+		writers = append(writers, file)
+	}
+	if opts.Output != nil {
+		writers = append(writers, opts.Output)
+	}
+	l := newText(io.MultiWriter(writers...), level)
+	if file != nil {
+		l.file = file
+	}
+	return l, nil
+}
+
+// newText 创建输出到 w 的日志
+func newText(w io.Writer, level Level) *TextLogger {
+	logger := logrus.New()
+	logger.SetFormatter(&CustomFormatter{})
+	logger.SetOutput(w)
+	logger.SetLevel(level)
+	return &TextLogger{logger: logger}
+}
+
+// SetLevel 修改日志级别，可以在运行时调用
+func (l *TextLogger) SetLevel(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	l.logger.SetLevel(level)
+	return nil
+}
+
+// Level 返回当前的日志级别
+func (l *TextLogger) Level() string {
+	return levelName(l.logger.GetLevel())
+}
+
+// levelName 返回日志级别的名称，与 ParseLevel 接受的名称一致
+func levelName(level Level) string {
+	for name, l := range levels {
+		if l == level {
+			return name
+		}
+	}
+	return level.String()
+}
+
+// Close 关闭日志文件
+func (l *TextLogger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func (l *TextLogger) log(level Level, msg string, args []interface{}) {
+	if !l.logger.IsLevelEnabled(level) {
+		return
+	}
+	l.logger.WithFields(fields(args)).Log(level, msg)
+}
+
+// Debug 输出 debug 级别的日志
+func (l *TextLogger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+
+// Info 输出 info 级别的日志
+func (l *TextLogger) Info(msg string, args ...interface{}) { l.log(LevelInfo, msg, args) }
+
+// Warn 输出 warn 级别的日志
+func (l *TextLogger) Warn(msg string, args ...interface{}) { l.log(LevelWarn, msg, args) }
+
+// Error 输出 error 级别的日志
+func (l *TextLogger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+// badKey 键不是字符串时使用的键，与 slog 保持一致
+const badKey = "!BADKEY"
+
+// fields 将交替出现的键和值转换为 logrus.Fields
+func fields(args []interface{}) logrus.Fields {
+	if len(args) == 0 {
+		return nil
+	}
+	f := make(logrus.Fields, (len(args)+1)/2)
+	for len(args) > 0 {
+		key, ok := args[0].(string)
+		if !ok || len(args) == 1 {
+			f[badKey] = args[0]
+			args = args[1:]
+			continue
+		}
+		f[key] = args[1]
+		args = args[2:]
+	}
+	return f
+}
+
+// CustomFormatter 日志格式，键值对按照键的字典序输出
+type CustomFormatter struct {
+}
+
+func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[%s] [%s] %s", entry.Time.Format("2006-01-02 15:04:05"), entry.Level.String(), entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteByte(' ')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(quote(fmt.Sprint(entry.Data[k])))
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// quote 值中包含空格、引号或等号时加上引号，便于按照空格切分
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
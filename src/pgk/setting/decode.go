@@ -0,0 +1,308 @@
+package setting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-ini/ini"
+	"gopkg.in/yaml.v3"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const groupSectionPrefix = "group." // 表示 ini 中分组配置的 section 前缀，例如 [group.scores]
+
+// parseTree 将不同格式的配置解析为相同结构的树，section 为 map[string]interface{}，分组为组名到配置项的映射
+func parseTree(data []byte, format string) (map[string]interface{}, error) {
+	tree := map[string]interface{}{}
+	switch format {
+	case FormatINI:
+		f, err := ini.Load(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ini: %v", err)
+		}
+		groups := map[string]interface{}{}
+		for _, s := range f.Sections() {
+			keys := map[string]interface{}{}
+			for _, k := range s.Keys() {
+				keys[k.Name()] = k.String()
+			}
+			switch {
+			case s.Name() == ini.DefaultSection:
+				// 没有 section 的配置项放在顶层，与 YAML 和 JSON 一致
+				for k, v := range keys {
+					tree[k] = v
+				}
+			case strings.HasPrefix(s.Name(), groupSectionPrefix):
+				groups[strings.TrimPrefix(s.Name(), groupSectionPrefix)] = keys
+			default:
+				tree[s.Name()] = keys
+			}
+		}
+		if len(groups) > 0 {
+			tree["groups"] = groups
+		}
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("parsing yaml: %v", err)
+		}
+	case FormatJSON:
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&tree); err != nil {
+			return nil, fmt.Errorf("parsing json: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	return tree, nil
+}
+
+// decodeConfig 将树中的配置写入 c，错误写入 errs
+func decodeConfig(c *Config, tree map[string]interface{}, errs *Errors) {
+	v := reflect.ValueOf(c).Elem()
+	for _, key := range sortedKeys(tree) {
+		if key == "groups" {
+			decodeGroups(c, tree[key], errs)
+			continue
+		}
+		field, ok := fieldByTag(v, key)
+		if !ok || field.Kind() != reflect.Struct {
+			errs.add("unknown section %q", key)
+			continue
+		}
+		decodeStruct(key, tree[key], field, errs)
+	}
+}
+
+// decodeGroups 解析分组，支持组名到配置项的映射，或者包含 name 的列表
+func decodeGroups(c *Config, raw interface{}, errs *Errors) {
+	switch groups := raw.(type) {
+	case map[string]interface{}:
+		for _, name := range sortedKeys(groups) {
+			decodeGroup(c, name, "groups."+name, groups[name], errs)
+		}
+	case []interface{}:
+		for i, body := range groups {
+			path := "groups[" + strconv.Itoa(i) + "]"
+			m, _ := body.(map[string]interface{})
+			name, _ := m["name"].(string)
+			if name == "" {
+				errs.add("%s: name is required", path)
+				continue
+			}
+			decodeGroup(c, name, path, body, errs)
+		}
+	case nil:
+	default:
+		errs.add("groups: want a table of groups, got %T", raw)
+	}
+	sort.Slice(c.Groups, func(i, j int) bool { return c.Groups[i].Name < c.Groups[j].Name })
+}
+
+// decodeGroup 解析一个分组，已经存在的同名分组会被覆盖
+func decodeGroup(c *Config, name, path string, body interface{}, errs *Errors) {
+	g, ok := c.Group(name)
+	if !ok {
+		c.Groups = append(c.Groups, DefaultGroup(name))
+		g = &c.Groups[len(c.Groups)-1]
+	}
+	decodeStruct(path, body, reflect.ValueOf(g).Elem(), errs)
+	g.Name = name
+}
+
+// decodeStruct 将 raw 中的配置项写入结构体 v
+func decodeStruct(path string, raw interface{}, v reflect.Value, errs *Errors) {
+	if raw == nil {
+		return
+	}
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		errs.add("%s: want a table, got %T", path, raw)
+		return
+	}
+	for _, key := range sortedKeys(m) {
+		field, ok := fieldByTag(v, key)
+		if !ok {
+			errs.add("unknown key %s.%s", path, key)
+			continue
+		}
+		if err := setValue(field, m[key]); err != nil {
+			errs.add("%s.%s: %v", path, key, err)
+		}
+	}
+}
+
+// fieldByTag 根据 conf 标签查找结构体的字段
+func fieldByTag(v reflect.Value, tag string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("conf") == tag {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// tags 返回结构体所有字段的 conf 标签
+func tags(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, t.Field(i).Tag.Get("conf"))
+	}
+	return names
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue 将配置项的值转换为字段的类型，值为空时保留默认值
+func setValue(field reflect.Value, raw interface{}) error {
+	if raw == nil {
+		return nil
+	}
+	if s, ok := raw.(string); ok && s == "" && field.Kind() != reflect.String {
+		return nil
+	}
+	switch {
+	case field.Type() == durationType:
+		d, err := toDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		s, err := toString(raw)
+		if err != nil {
+			return err
+		}
+		field.SetString(s)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := toInt(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Bool:
+		b, err := toBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		list, err := toStrings(raw)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+func toDuration(raw interface{}) (time.Duration, error) {
+	if s, ok := raw.(string); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return 0, fmt.Errorf("bad duration %q, want something like 2s or 500ms", s)
+		}
+		return d, nil
+	}
+	// 数字只接受 0，避免单位产生歧义
+	if n, err := toInt(raw, 64); err == nil && n == 0 {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("bad duration %v, want a string like 2s or 500ms", raw)
+}
+
+func toString(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, float64, json.Number:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("want a string, got %T", raw)
+}
+
+func toInt(raw interface{}, bits int) (int64, error) {
+	var n int64
+	switch v := raw.(type) {
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimSpace(v), 10, bits); err != nil {
+			return 0, fmt.Errorf("bad integer %q", v)
+		}
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case json.Number:
+		var err error
+		if n, err = v.Int64(); err != nil {
+			return 0, fmt.Errorf("bad integer %s", v)
+		}
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+			return 0, fmt.Errorf("bad integer %v", v)
+		}
+		n = int64(v)
+	default:
+		return 0, fmt.Errorf("want an integer, got %T", raw)
+	}
+	if bits < 64 && (n > 1<<(bits-1)-1 || n < -1<<(bits-1)) {
+		return 0, fmt.Errorf("integer %d out of range", n)
+	}
+	return n, nil
+}
+
+func toBool(raw interface{}) (bool, error) {
+	switch v := raw.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, fmt.Errorf("bad bool %q", v)
+		}
+		return b, nil
+	}
+	return false, fmt.Errorf("want a bool, got %T", raw)
+}
+
+// toStrings 字符串按逗号分隔，列表中的每一项都需要是字符串
+func toStrings(raw interface{}) ([]string, error) {
+	var list []string
+	switch v := raw.(type) {
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			s, err := toString(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, s)
+		}
+	default:
+		return nil, fmt.Errorf("want a list or a comma separated string, got %T", raw)
+	}
+	return list, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,97 @@
+package setting
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	envPrefix      = "JWCACHE_"     // 表示覆盖配置的环境变量前缀
+	envGroupPrefix = "GROUP_"       // 表示分组配置的环境变量前缀，例如 JWCACHE_GROUP_SCORES_CACHE_BYTES
+	EnvConfigPath  = "JWCACHE_CONF" // 表示配置文件路径的环境变量，不会被当作配置项
+)
+
+// ApplyEnv 使用 JWCACHE_* 环境变量覆盖配置，environ 的格式与 os.Environ() 相同。
+// 变量名为 JWCACHE_<SECTION>_<KEY>，例如 JWCACHE_SERVER_ADDR、JWCACHE_LOG_LEVEL；
+// 分组为 JWCACHE_GROUP_<组名>_<KEY>，组名不区分大小写，不存在时会新建该分组。
+// 无法识别的变量和无法解析的值会一起返回
+func (c *Config) ApplyEnv(environ []string) error {
+	var errs Errors
+	v := reflect.ValueOf(c).Elem()
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) || name == EnvConfigPath {
+			continue
+		}
+		rest := strings.TrimPrefix(name, envPrefix)
+		field, ok := c.envField(v, rest)
+		if !ok {
+			errs.add("unknown environment variable %s", name)
+			continue
+		}
+		if err := setValue(field, value); err != nil {
+			errs.add("%s: %v", name, err)
+		}
+	}
+	return errs.Err()
+}
+
+// envField 根据去掉前缀的变量名查找对应的字段
+func (c *Config) envField(v reflect.Value, rest string) (reflect.Value, bool) {
+	if strings.HasPrefix(rest, envGroupPrefix) {
+		return c.envGroupField(strings.TrimPrefix(rest, envGroupPrefix))
+	}
+	for _, section := range tags(v.Type()) {
+		prefix := strings.ToUpper(section) + "_"
+		if section == "groups" || !strings.HasPrefix(rest, prefix) {
+			continue
+		}
+		sv, _ := fieldByTag(v, section)
+		return fieldByTag(sv, strings.ToLower(strings.TrimPrefix(rest, prefix)))
+	}
+	return reflect.Value{}, false
+}
+
+// envGroupField 查找分组的字段，配置项名称按照长度从长到短匹配，避免 COMPRESS_THRESHOLD 被当作组名的一部分
+func (c *Config) envGroupField(rest string) (reflect.Value, bool) {
+	keys := tags(reflect.TypeOf(GroupConfig{}))
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, key := range keys {
+		suffix := "_" + strings.ToUpper(key)
+		if key == "name" || !strings.HasSuffix(rest, suffix) || len(rest) == len(suffix) {
+			continue
+		}
+		name := strings.TrimSuffix(rest, suffix)
+		g := c.envGroup(name)
+		field, _ := fieldByTag(reflect.ValueOf(g).Elem(), key)
+		return field, true
+	}
+	return reflect.Value{}, false
+}
+
+// envGroup 查找组名转换为环境变量格式后与 name 相同的分组，不存在时新建
+func (c *Config) envGroup(name string) *GroupConfig {
+	for i := range c.Groups {
+		if envName(c.Groups[i].Name) == name {
+			return &c.Groups[i]
+		}
+	}
+	c.Groups = append(c.Groups, DefaultGroup(strings.ToLower(name)))
+	sort.Slice(c.Groups, func(i, j int) bool { return c.Groups[i].Name < c.Groups[j].Name })
+	g, _ := c.Group(strings.ToLower(name))
+	return g
+}
+
+// envName 将组名转换为环境变量中的格式：大写，字母和数字之外的字符替换为下划线
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,126 @@
+package setting
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Changes 两份配置之间的差异，只有节点列表、分组和日志级别可以在运行时应用，其他变化需要重启节点
+type Changes struct {
+	AddedPeers    []string      // AddedPeers 新增的节点
+	RemovedPeers  []string      // RemovedPeers 删除的节点
+	AddedGroups   []GroupConfig // AddedGroups 新增的分组
+	ResizedGroups []GroupConfig // ResizedGroups 缓存容量变化的分组，值为新的配置
+	LogLevel      string        // LogLevel 新的日志级别，没有变化时为空
+	Restart       []string      // Restart 发生了变化但需要重启节点才能生效的配置项，例如 server.addr、groups.scores.evicter
+}
+
+// Empty 两份配置是否没有差异
+func (c Changes) Empty() bool {
+	return len(c.AddedPeers) == 0 && len(c.RemovedPeers) == 0 && len(c.AddedGroups) == 0 &&
+		len(c.ResizedGroups) == 0 && c.LogLevel == "" && len(c.Restart) == 0
+}
+
+// PeersChanged 哈希环上的节点是否发生了变化，只调整节点的顺序不算变化
+func (c Changes) PeersChanged() bool {
+	return len(c.AddedPeers) > 0 || len(c.RemovedPeers) > 0
+}
+
+// Diff 比较新旧两份配置
+func Diff(old, new *Config) Changes {
+	var c Changes
+	c.AddedPeers = subtract(new.Server.Peers, old.Server.Peers)
+	c.RemovedPeers = subtract(old.Server.Peers, new.Server.Peers)
+	if new.Log.Level != old.Log.Level {
+		c.LogLevel = new.Log.Level
+	}
+	c.Restart = append(c.Restart, diffFields("server", old.Server, new.Server, "peers", "handoff")...)
+	c.Restart = append(c.Restart, diffFields("transport", old.Transport, new.Transport)...)
+	c.Restart = append(c.Restart, diffFields("log", old.Log, new.Log, "level")...)
+	c.Restart = append(c.Restart, diffFields("snapshot", old.Snapshot, new.Snapshot)...)
+	c.Restart = append(c.Restart, diffFields("aof", old.AOF, new.AOF)...)
+	for _, g := range new.Groups {
+		o, ok := old.Group(g.Name)
+		if !ok {
+			c.AddedGroups = append(c.AddedGroups, g)
+			continue
+		}
+		if o.CacheBytes != g.CacheBytes {
+			c.ResizedGroups = append(c.ResizedGroups, g)
+		}
+		c.Restart = append(c.Restart, diffFields("groups."+g.Name, *o, g, "name", "cache_bytes")...)
+	}
+	for _, g := range old.Groups {
+		if _, ok := new.Group(g.Name); !ok {
+			// 分组是全局注册的，删除后仍然会继续提供服务，直到节点重启
+			c.Restart = append(c.Restart, "groups."+g.Name)
+		}
+	}
+	return c
+}
+
+// diffFields 返回两个相同类型的结构体中值不同的配置项名称，skip 中的配置项会被忽略
+func diffFields(section string, old, new interface{}, skip ...string) []string {
+	var names []string
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i, tag := range tags(ov.Type()) {
+		if contains(skip, tag) {
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			names = append(names, section+"."+tag)
+		}
+	}
+	return names
+}
+
+// subtract 返回在 a 中但不在 b 中的元素，按照字母顺序排列
+func subtract(a, b []string) []string {
+	var diff []string
+	for _, s := range a {
+		if !contains(b, s) && !contains(diff, s) {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// Watch 每隔 interval 检查一次配置文件，文件内容发生变化时调用 onChange，ctx 结束后返回。
+// 使用轮询而不是文件系统通知，编辑器先写入临时文件再重命名的保存方式同样可以被发现；
+// 文件暂时不存在或者无法读取时跳过本次检查
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := os.ReadFile(path)
+	lastInfo, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if lastInfo != nil && info.ModTime().Equal(lastInfo.ModTime()) && info.Size() == lastInfo.Size() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		lastInfo = info
+		// 只修改了时间时内容不变，不需要重新加载
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		onChange()
+	}
+}
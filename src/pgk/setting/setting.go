@@ -1,18 +1,231 @@
+// Package setting 节点的配置，支持 ini、YAML 和 JSON 格式，可以通过 JWCACHE_* 环境变量覆盖。
+// 导入时不会读取任何文件，使用 Load 从文件加载，或者在测试中直接构造 Config
 package setting
 
 import (
-	"github.com/go-ini/ini"
-	"log"
+	"errors"
+	"fmt"
+	"jw-cache/src/cache"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-var (
-	Cfg *ini.File
+const (
+	FormatINI  = "ini"  // ini 格式，分组的 section 名称为 group.<组名>
+	FormatYAML = "yaml" // YAML 格式
+	FormatJSON = "json" // JSON 格式
+
+	defaultAddr           = "http://localhost:8001"
+	defaultHealthInterval = 2 * time.Second
+	defaultWatchInterval  = 5 * time.Second
+	defaultCacheBytes     = 64 << 20 // 表示分组默认的缓存大小
+	defaultLogLevel       = "info"
+	defaultFileFormat     = "20060102"
+	defaultAOFCompactSize = 64 << 20 // 表示默认的日志压缩阈值
 )
 
-func init() {
-	var err error
-	Cfg, err = ini.Load("conf/conf.ini")
+// Config 节点的全部配置
+type Config struct {
+	Server    ServerConfig    `conf:"server"`    // Server 节点地址和哈希环
+	Transport TransportConfig `conf:"transport"` // Transport 节点之间的请求
+	Log       LogConfig       `conf:"log"`       // Log 日志
+	Snapshot  SnapshotConfig  `conf:"snapshot"`  // Snapshot 本地缓存的快照
+	AOF       AOFConfig       `conf:"aof"`       // AOF 本地缓存的追加日志，与 Snapshot 不能同时开启
+	Groups    []GroupConfig   `conf:"groups"`    // Groups 分组，按照组名排列
+}
+
+// ServerConfig 节点地址和哈希环的配置
+type ServerConfig struct {
+	Addr           string        `conf:"addr"`            // Addr 当前节点的地址，其他节点通过该地址访问当前节点
+	Peers          []string      `conf:"peers"`           // Peers 所有节点的地址（包括当前节点）
+	APIAddr        string        `conf:"api_addr"`        // APIAddr 客户端接口的监听地址，为空时不开启
+	HealthInterval time.Duration `conf:"health_interval"` // HealthInterval 健康检查的间隔
+	WatchInterval  time.Duration `conf:"watch_interval"`  // WatchInterval 检查配置文件是否变化的间隔，为 0 时只在收到 SIGHUP 时重新加载
+	Handoff        bool          `conf:"handoff"`         // Handoff 当前节点加入或离开哈希环时是否与其他节点交接数据
+}
+
+// TransportConfig 节点之间请求的配置，零值表示使用 https.HTTPPoolOptions 的默认值
+type TransportConfig struct {
+	BasePath        string        `conf:"base_path"`         // BasePath 节点之间请求的路径前缀
+	Timeout         time.Duration `conf:"timeout"`           // Timeout 单次请求的超时时间
+	Retries         int           `conf:"retries"`           // Retries 请求失败后的最大重试次数
+	RetryBackoff    time.Duration `conf:"retry_backoff"`     // RetryBackoff 重试的基础退避时间
+	MaxRetryBackoff time.Duration `conf:"max_retry_backoff"` // MaxRetryBackoff 重试的最大退避时间
+	HedgeDelay      time.Duration `conf:"hedge_delay"`       // HedgeDelay 发送对冲请求前等待的时间，为 0 时不开启
+	SharedSecret    string        `conf:"shared_secret"`     // SharedSecret 节点之间共享的签名密钥，为空时不签名
+	ReplayWindow    time.Duration `conf:"replay_window"`     // ReplayWindow 签名时间戳的有效窗口
+	CertFile        string        `conf:"cert_file"`         // CertFile 节点的证书，不为空时节点之间使用双向TLS认证
+	KeyFile         string        `conf:"key_file"`          // KeyFile 节点证书的私钥
+	CAFile          string        `conf:"ca_file"`           // CAFile 签发节点证书的CA
+	HandoffRate     int64         `conf:"handoff_rate"`      // HandoffRate 交接数据时每秒发送的最大字节数，小于 0 时不限制
+	HandoffTimeout  time.Duration `conf:"handoff_timeout"`   // HandoffTimeout 一次交接的最长时间
+}
+
+// LogConfig 日志的配置，与 log.Options 对应
+type LogConfig struct {
+	Level      string `conf:"level"`       // Level 日志级别
+	Dir        string `conf:"dir"`         // Dir 日志文件的目录，为空时只输出到标准输出
+	FileFormat string `conf:"file_format"` // FileFormat 日志文件名的时间格式
+	MaxSize    int64  `conf:"max_size"`    // MaxSize 单个日志文件的最大字节数，为 0 时不限制
+	MaxBackups int    `conf:"max_backups"` // MaxBackups 保留的旧日志文件数量，为 0 时全部保留
+	Compress   bool   `conf:"compress"`    // Compress 是否压缩旧的日志文件
+}
+
+// SnapshotConfig 快照的配置，Path 为空时不保存也不恢复快照
+type SnapshotConfig struct {
+	Path       string        `conf:"path"`        // Path 快照文件的路径，节点启动时从该文件恢复本地缓存
+	Interval   time.Duration `conf:"interval"`    // Interval 定时保存快照的间隔，为 0 时不定时保存
+	OnShutdown bool          `conf:"on_shutdown"` // OnShutdown 关闭节点时是否保存快照
+}
+
+// AOFConfig 追加日志的配置，与 cache.AOFOptions 对应，Dir 为空时不开启
+type AOFConfig struct {
+	Dir         string `conf:"dir"`          // Dir 快照和日志文件的目录，节点启动时从该目录恢复本地缓存
+	Fsync       string `conf:"fsync"`        // Fsync 同步到磁盘的策略：always、everysec 或 no
+	CompactSize int64  `conf:"compact_size"` // CompactSize 日志超过该大小时压缩为新的快照，小于 0 时不自动压缩
+}
+
+// GroupConfig 分组的配置，与 cache.GroupOptions 对应
+type GroupConfig struct {
+	Name              string        `conf:"name"`               // Name 组名
+	CacheBytes        int64         `conf:"cache_bytes"`        // CacheBytes 本地缓存的最大字节数，为 0 时不限制
+	Evicter           string        `conf:"evicter"`            // Evicter 淘汰策略
+	TTL               time.Duration `conf:"ttl"`                // TTL 通过 Getter 加载的值的过期时间，为 0 时永不过期
+	Compression       string        `conf:"compression"`        // Compression 值的压缩方式，为空时不压缩
+	CompressThreshold int           `conf:"compress_threshold"` // CompressThreshold 大于等于该大小的值才会被压缩，为 0 时使用默认值
+	Shards            int           `conf:"shards"`             // Shards 本地缓存的分片数量，为 0 时使用默认值
+	L2Dir             string        `conf:"l2_dir"`             // L2Dir 二级缓存段文件的目录，为空时不开启，每个分组需要使用不同的目录
+	L2Bytes           int64         `conf:"l2_bytes"`           // L2Bytes 二级缓存的最大字节数，为 0 时使用默认值
+}
+
+// Default 返回默认配置，不包含任何分组
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:           defaultAddr,
+			HealthInterval: defaultHealthInterval,
+			WatchInterval:  defaultWatchInterval,
+			Handoff:        true,
+		},
+		Log: LogConfig{
+			Level:      defaultLogLevel,
+			FileFormat: defaultFileFormat,
+		},
+		Snapshot: SnapshotConfig{OnShutdown: true},
+		AOF: AOFConfig{
+			Fsync:       cache.FsyncEverySec,
+			CompactSize: defaultAOFCompactSize,
+		},
+	}
+}
+
+// DefaultGroup 返回分组的默认配置
+func DefaultGroup(name string) GroupConfig {
+	return GroupConfig{Name: name, CacheBytes: defaultCacheBytes}
+}
+
+// Group 根据组名返回分组的配置
+func (c *Config) Group(name string) (*GroupConfig, bool) {
+	for i := range c.Groups {
+		if c.Groups[i].Name == name {
+			return &c.Groups[i], true
+		}
+	}
+	return nil, false
+}
+
+// FormatOf 根据文件扩展名返回配置的格式
+func FormatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ini", ".conf":
+		return FormatINI, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unknown config format of %s, want .ini, .yaml, .yml or .json", path)
+}
+
+// Load 从文件中加载配置，格式由扩展名决定，再使用 environ 中的 JWCACHE_* 环境变量覆盖，
+// environ 通常为 os.Environ()。返回的配置还没有校验，调用方在应用命令行参数之后需要调用 Validate
+func Load(path string, environ []string) (*Config, error) {
+	format, err := FormatOf(path)
 	if err != nil {
-		log.Fatalf("Fail to parse 'conf/conf.ini': %v", err)
+		return nil, err
 	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.ApplyEnv(environ); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Parse 解析配置，没有出现的配置项使用默认值，所有无法解析的配置项会一起返回
+func Parse(data []byte, format string) (*Config, error) {
+	tree, err := parseTree(data, format)
+	if err != nil {
+		return nil, err
+	}
+	c := Default()
+	var errs Errors
+	decodeConfig(c, tree, &errs)
+	return c, errs.Err()
+}
+
+// Errors 多个配置错误，每个错误一行
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap 返回所有的错误，Go 1.20 及以上的 errors 包会通过该方法遍历每个错误
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Is 任意一个错误匹配 target 时返回 true，go.mod 中的 Go 1.19 不识别 Unwrap() []error，errors.Is 通过该方法遍历每个错误
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 找到第一个可以赋值给 target 的错误，与 Is 相同，用于支持 errors.As
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Err 没有错误时返回 nil
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *Errors) add(format string, v ...interface{}) {
+	*e = append(*e, fmt.Errorf(format, v...))
 }
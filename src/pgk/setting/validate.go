@@ -0,0 +1,171 @@
+package setting
+
+import (
+	"jw-cache/src/cache"
+	"jw-cache/src/pgk/log"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Validate 校验配置，返回所有的问题而不是第一个
+func (c *Config) Validate() error {
+	var errs Errors
+	c.Server.validate(&errs)
+	c.Transport.validate(&errs)
+	c.Log.validate(&errs)
+	if c.Snapshot.Interval < 0 {
+		errs.add("snapshot.interval: should not be negative")
+	}
+	if !contains(cache.FsyncPolicies(), c.AOF.Fsync) {
+		errs.add("aof.fsync: unknown policy %q, want one of %s", c.AOF.Fsync, strings.Join(cache.FsyncPolicies(), ", "))
+	}
+	if c.AOF.Dir != "" && c.Snapshot.Path != "" {
+		errs.add("aof.dir: snapshot.path and aof.dir should not be set together")
+	}
+	if c.Transport.CertFile != "" && !strings.HasPrefix(c.Server.Addr, "https://") {
+		errs.add("server.addr: should use https when transport.cert_file is set")
+	}
+	if len(c.Groups) == 0 {
+		errs.add("groups: no group configured")
+	}
+	seen := make(map[string]bool, len(c.Groups))
+	l2Dirs := make(map[string]string, len(c.Groups))
+	for _, g := range c.Groups {
+		if seen[g.Name] {
+			errs.add("groups.%s: duplicate group", g.Name)
+		}
+		seen[g.Name] = true
+		if g.L2Dir != "" {
+			// 打开二级缓存时会删除目录中已有的段文件，分组之间不能共用目录
+			dir := filepath.Clean(g.L2Dir)
+			if other, ok := l2Dirs[dir]; ok {
+				errs.add("groups.%s.l2_dir: already used by group %s", g.Name, other)
+			}
+			l2Dirs[dir] = g.Name
+		}
+		g.validate(&errs)
+	}
+	return errs.Err()
+}
+
+// validNodeURL 判断节点地址是否为带有 host 的 http(s) 地址
+func validNodeURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (s *ServerConfig) validate(errs *Errors) {
+	if !validNodeURL(s.Addr) {
+		errs.add("server.addr: bad node addr %q, want something like http://localhost:8001", s.Addr)
+	}
+	seen := make(map[string]bool, len(s.Peers))
+	for _, peer := range s.Peers {
+		if !validNodeURL(peer) {
+			errs.add("server.peers: bad node addr %q", peer)
+		}
+		if seen[peer] {
+			errs.add("server.peers: duplicate node %s", peer)
+		}
+		seen[peer] = true
+	}
+	if s.APIAddr != "" {
+		if _, _, err := net.SplitHostPort(s.APIAddr); err != nil {
+			errs.add("server.api_addr: bad listen addr %q, want something like :9999", s.APIAddr)
+		}
+	}
+	if s.HealthInterval <= 0 {
+		errs.add("server.health_interval: should be positive")
+	}
+	if s.WatchInterval < 0 {
+		errs.add("server.watch_interval: should not be negative")
+	}
+}
+
+func (t *TransportConfig) validate(errs *Errors) {
+	if t.BasePath != "" && strings.Contains(strings.Trim(t.BasePath, "/"), "//") {
+		errs.add("transport.base_path: bad path %q", t.BasePath)
+	}
+	if t.Retries < 0 {
+		errs.add("transport.retries: should not be negative")
+	}
+	durations := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"timeout", t.Timeout},
+		{"retry_backoff", t.RetryBackoff},
+		{"max_retry_backoff", t.MaxRetryBackoff},
+		{"hedge_delay", t.HedgeDelay},
+		{"replay_window", t.ReplayWindow},
+		{"handoff_timeout", t.HandoffTimeout},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			errs.add("transport.%s: should not be negative", d.name)
+		}
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs.add("transport: cert_file and key_file should be set together")
+	}
+	if t.CAFile != "" && t.CertFile == "" {
+		errs.add("transport.ca_file: requires cert_file and key_file")
+	}
+}
+
+func (l *LogConfig) validate(errs *Errors) {
+	if _, err := log.ParseLevel(l.Level); err != nil {
+		errs.add("log.level: %v", err)
+	}
+	if l.MaxSize < 0 {
+		errs.add("log.max_size: should not be negative")
+	}
+	if l.MaxBackups < 0 {
+		errs.add("log.max_backups: should not be negative")
+	}
+}
+
+func (g *GroupConfig) validate(errs *Errors) {
+	path := "groups." + g.Name
+	if g.Name == "" || strings.Contains(g.Name, "/") {
+		errs.add("groups: bad group name %q", g.Name)
+	}
+	if g.CacheBytes < 0 {
+		errs.add("%s.cache_bytes: should not be negative", path)
+	}
+	if g.Evicter != "" && !contains(cache.EvicterPolicies(), g.Evicter) {
+		errs.add("%s.evicter: unknown policy %q, want one of %s", path, g.Evicter, strings.Join(cache.EvicterPolicies(), ", "))
+	}
+	if g.Evicter == cache.PolicyArena && g.CacheBytes == 0 {
+		errs.add("%s.cache_bytes: evicter %s requires a positive cache size", path, g.Evicter)
+	}
+	if g.TTL < 0 {
+		errs.add("%s.ttl: should not be negative", path)
+	}
+	if _, err := cache.ParseCompression(g.Compression); err != nil {
+		errs.add("%s.compression: %v", path, err)
+	}
+	if g.CompressThreshold < 0 {
+		errs.add("%s.compress_threshold: should not be negative", path)
+	}
+	if g.Shards < 0 {
+		errs.add("%s.shards: should not be negative", path)
+	}
+	if g.L2Bytes < 0 {
+		errs.add("%s.l2_bytes: should not be negative", path)
+	}
+	if g.L2Bytes > 0 && g.L2Dir == "" {
+		errs.add("%s.l2_bytes: requires l2_dir", path)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,11 +1,23 @@
 package singleflight
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+)
 
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	wg    sync.WaitGroup
+	val   interface{}
+	err   error
+	chans []chan<- Result // DoChan 的调用方，请求完成时将结果发送给它们
+	async bool            // 请求是否由 DoChan 发起，此时第一个 channel 属于发起请求的调用方
+}
+
+// Result DoChan 返回的结果
+type Result struct {
+	Val    interface{} // Val fn 返回的值
+	Err    error       // Err fn 返回的错误
+	Shared bool        // Shared 是否复用了其他请求的结果
 }
 
 type Group struct {
@@ -15,26 +27,74 @@ type Group struct {
 
 // Do 防止缓存击穿的实现，当相同的key并发的请求时，该方法可以保证fn函数只被调用一次
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	val, err, _ := g.DoShared(key, fn)
+	return val, err
+}
+
+// DoShared 与 Do 相同，shared 表示是否复用了其他请求的结果，即 fn 没有被当前请求调用
+func (g *Group) DoShared(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
 	g.mu.Lock()           // 先上锁
 	if g.callMap == nil { // 延迟加载
 		g.callMap = make(map[string]*call)
 	}
 	if c, ok := g.callMap[key]; ok { // 如果有相同的key正在请求，则等待
-		g.mu.Unlock()       // 解锁
-		c.wg.Wait()         // 等待key请求完成
-		return c.val, c.err // 返回key请求的结果
+		g.mu.Unlock()             // 解锁
+		c.wg.Wait()               // 等待key请求完成
+		return c.val, c.err, true // 返回key请求的结果
 	}
 	aCall := new(call)
 	aCall.wg.Add(1)        // 发起请求前加锁，使请求结束前的所有与该请求相同的key阻塞
 	g.callMap[key] = aCall // 添加到 g.callMap
 	g.mu.Unlock()
 
-	aCall.val, aCall.err = fn() // 调用方法获取key的值
-	aCall.wg.Done()             // 请求解锁
+	g.doCall(aCall, key, fn)
+	return aCall.val, aCall.err, false
+}
 
+// DoChan 与 DoShared 相同，但不阻塞调用方，结果通过返回的 channel 发送，
+// fn 在新的 goroutine 中执行，调用方可以同时等待其他事件，放弃等待不会中断 fn
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mu.Lock()
-	delete(g.callMap, key) // 更新 g.callMap
+	if g.callMap == nil {
+		g.callMap = make(map[string]*call)
+	}
+	if c, ok := g.callMap[key]; ok {
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	aCall := &call{chans: []chan<- Result{ch}, async: true}
+	aCall.wg.Add(1)
+	g.callMap[key] = aCall
 	g.mu.Unlock()
 
-	return aCall.val, aCall.err
+	go g.doCall(aCall, key, fn)
+	return ch
+}
+
+// doCall 调用 fn 并将结果交给所有等待的调用方，fn 发生 panic 时转换为错误，避免等待的调用方永远阻塞
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, fmt.Errorf("singleflight: panic in fn for key %q: %v", key, r)
+		}
+		c.wg.Done() // 请求解锁
+
+		g.mu.Lock()
+		delete(g.callMap, key) // 更新 g.callMap
+		chans := c.chans
+		g.mu.Unlock()
+		for i, ch := range chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: !c.async || i > 0}
+		}
+	}()
+	c.val, c.err = fn() // 调用方法获取key的值
+}
+
+// InFlight 返回正在进行中的请求数量
+func (g *Group) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.callMap)
 }
@@ -0,0 +1,72 @@
+// Package otel 将 OpenTelemetry 的 Tracer 适配为 trace.Tracer，
+// 单独放在子包中，不使用 OpenTelemetry 的程序不会引入该依赖
+package otel
+
+import (
+	"context"
+	"net/http"
+
+	"jw-cache/src/trace"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Tracer 使用 OpenTelemetry 创建 span 的 trace.Tracer
+type Tracer struct {
+	tracer     oteltrace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer 创建 Tracer，propagator 为 nil 时使用 otel.GetTextMapPropagator 返回的全局传播器，
+// 全局传播器默认不传递任何数据，需要设置为 propagation.TraceContext 才能与其他节点串联
+func NewTracer(tracer oteltrace.Tracer, propagator propagation.TextMapPropagator) *Tracer {
+	return &Tracer{tracer: tracer, propagator: propagator}
+}
+
+// Start 创建子 span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, otelSpan{span: span}
+}
+
+// Inject 将追踪上下文写入请求头
+func (t *Tracer) Inject(ctx context.Context, header http.Header) {
+	t.textMap().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract 从请求头中读取追踪上下文
+func (t *Tracer) Extract(ctx context.Context, header http.Header) context.Context {
+	return t.textMap().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+func (t *Tracer) textMap() propagation.TextMapPropagator {
+	if t.propagator != nil {
+		return t.propagator
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// otelSpan 将 OpenTelemetry 的 span 适配为 trace.Span
+type otelSpan struct {
+	span oteltrace.Span
+}
+
+func (s otelSpan) SetAttributes(attrs ...trace.Attribute) {
+	kvs := make([]attribute.KeyValue, len(attrs))
+	for i, a := range attrs {
+		kvs[i] = attribute.String(a.Key, a.Value)
+	}
+	s.span.SetAttributes(kvs...)
+}
+
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) IsRecording() bool { return s.span.IsRecording() }
+func (s otelSpan) End()              { s.span.End() }
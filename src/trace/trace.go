@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// Attribute span 的属性
+type Attribute struct {
+	Key   string // Key 属性名
+	Value string // Value 属性值
+}
+
+// String 创建属性
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span 一次操作的追踪记录，方法与 OpenTelemetry 的 trace.Span 保持一致，方便适配
+type Span interface {
+	SetAttributes(attrs ...Attribute) // SetAttributes 设置属性
+	RecordError(err error)            // RecordError 记录错误
+	IsRecording() bool                // IsRecording 是否记录数据，为 false 时调用方可以跳过构造属性
+	End()                             // End 结束该 span
+}
+
+// Tracer 创建 span 并在节点之间传递追踪上下文
+type Tracer interface {
+	// Start 创建一个子 span，返回的 context 中包含该 span，用于创建下一级 span
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject 将 ctx 中的追踪上下文写入请求头
+	Inject(ctx context.Context, header http.Header)
+	// Extract 从请求头中读取追踪上下文，返回的 context 用于创建远程 span 的子 span
+	Extract(ctx context.Context, header http.Header) context.Context
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) IsRecording() bool          { return false }
+func (noopSpan) End()                       {}
+
+// noopTracer 默认的 Tracer，不记录任何数据
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopTracer) Inject(ctx context.Context, header http.Header) {}
+
+func (noopTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	return ctx
+}
+
+// tracerHolder 用于在 atomic.Value 中保存不同类型的 Tracer
+type tracerHolder struct {
+	tracer Tracer
+}
+
+var global atomic.Value
+
+func init() {
+	global.Store(tracerHolder{tracer: noopTracer{}})
+}
+
+// SetTracer 设置全局的 Tracer，为 nil 时恢复为不记录任何数据的默认实现
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	global.Store(tracerHolder{tracer: t})
+}
+
+// GetTracer 返回全局的 Tracer
+func GetTracer() Tracer {
+	return global.Load().(tracerHolder).tracer
+}
+
+// Start 使用全局的 Tracer 创建 span
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	return GetTracer().Start(ctx, name)
+}
+
+// Inject 使用全局的 Tracer 将追踪上下文写入请求头
+func Inject(ctx context.Context, header http.Header) {
+	GetTracer().Inject(ctx, header)
+}
+
+// Extract 使用全局的 Tracer 从请求头中读取追踪上下文
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return GetTracer().Extract(ctx, header)
+}
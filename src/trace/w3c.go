@@ -0,0 +1,170 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader W3C Trace Context 规范中的请求头，与 OpenTelemetry 默认的传播格式相同
+const TraceparentHeader = "traceparent"
+
+// SpanContext span 的标识，在节点之间通过 traceparent 请求头传递
+type SpanContext struct {
+	TraceID [16]byte // TraceID 整个调用链的标识
+	SpanID  [8]byte  // SpanID 当前 span 的标识
+	Sampled bool     // Sampled 是否采样
+}
+
+// IsValid 判断标识是否有效，全零的标识是无效的
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent 返回 traceparent 请求头的值
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent 解析 traceparent 请求头
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("malformed traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("malformed trace id: %v", err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("malformed span id: %v", err)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("malformed trace flags: %v", err)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext 返回包含 sc 的 context
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext 返回 ctx 中当前 span 的标识
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// SpanData 结束后的 span，交给导出函数处理
+type SpanData struct {
+	Name       string      // Name span 的名称
+	Context    SpanContext // Context span 的标识
+	Parent     SpanContext // Parent 父 span 的标识，没有父 span 时无效
+	Remote     bool        // Remote 父 span 是否来自其他节点
+	Start      time.Time   // Start 开始时间
+	End        time.Time   // End 结束时间
+	Attributes []Attribute // Attributes 属性
+	Err        error       // Err 记录的错误
+}
+
+// W3CTracer 按照 W3C Trace Context 规范生成标识并传递追踪上下文的 Tracer，
+// 可以与使用 OpenTelemetry 的服务出现在同一个调用链中，结束的 span 交给 export 导出
+type W3CTracer struct {
+	export func(SpanData)
+}
+
+// NewW3CTracer 创建 W3CTracer，export 在每个 span 结束时被调用，需要保证并发安全
+func NewW3CTracer(export func(SpanData)) *W3CTracer {
+	return &W3CTracer{export: export}
+}
+
+type remoteKey struct{}
+
+// Start 创建子 span，ctx 中没有 span 时开始一个新的调用链
+func (t *W3CTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := SpanContextFromContext(ctx)
+	s := &w3cSpan{tracer: t, data: SpanData{Name: name, Parent: parent, Start: time.Now()}}
+	s.data.Remote, _ = ctx.Value(remoteKey{}).(bool)
+	s.data.Context.Sampled = true
+	if parent.IsValid() {
+		s.data.Context.TraceID, s.data.Context.Sampled = parent.TraceID, parent.Sampled
+	} else {
+		rand.Read(s.data.Context.TraceID[:])
+	}
+	rand.Read(s.data.Context.SpanID[:])
+	ctx = context.WithValue(ctx, remoteKey{}, false)
+	return ContextWithSpanContext(ctx, s.data.Context), s
+}
+
+// Inject 将当前 span 的标识写入 traceparent 请求头
+func (t *W3CTracer) Inject(ctx context.Context, header http.Header) {
+	if sc, ok := SpanContextFromContext(ctx); ok && sc.IsValid() {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// Extract 读取 traceparent 请求头，请求头不存在或格式错误时返回原来的 ctx
+func (t *W3CTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(context.WithValue(ctx, remoteKey{}, true), sc)
+}
+
+// w3cSpan W3CTracer 创建的 span
+type w3cSpan struct {
+	tracer *W3CTracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *w3cSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+func (s *w3cSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err
+}
+
+func (s *w3cSpan) IsRecording() bool {
+	return s.data.Context.Sampled
+}
+
+// End 结束 span 并导出，重复调用只导出一次
+func (s *w3cSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if data.Context.Sampled && s.tracer.export != nil {
+		s.tracer.export(data)
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"jw-cache/src/api"
+	"jw-cache/src/cache"
+	"jw-cache/src/pgk/log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	cache.NewGroup("users", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "Tom" {
+			return []byte("123"), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+	}))
+	mux := http.NewServeMux()
+	api.NewServer().Mount(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func do(t *testing.T, method, url string, body string, header map[string]string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res, string(b)
+}
+
+func TestGetSetDelete(t *testing.T) {
+	server := newServer(t)
+	url := server.URL + "/v1/groups/users/keys/"
+
+	res, body := do(t, http.MethodGet, url+"Tom", "", nil)
+	if res.StatusCode != http.StatusOK || body != "123" {
+		t.Fatalf("get Tom: %d %s", res.StatusCode, body)
+	}
+	etag := res.Header.Get("ETag")
+	if res, _ := do(t, http.MethodGet, url+"Tom", "", map[string]string{"If-None-Match": etag}); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("matched etag should return 304, but %d got", res.StatusCode)
+	}
+
+	res, body = do(t, http.MethodGet, url+"Jack", "", nil)
+	var e struct {
+		Code  int    `json:"code"`
+		Error string `json:"error"`
+	}
+	if res.StatusCode != http.StatusNotFound || json.Unmarshal([]byte(body), &e) != nil || e.Code != http.StatusNotFound {
+		t.Fatalf("unknown key should return a json 404: %d %s", res.StatusCode, body)
+	}
+
+	if res, _ := do(t, http.MethodPut, url+"a/b", "456", map[string]string{"X-JWCache-TTL": "60"}); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("put should return 204, but %d got", res.StatusCode)
+	}
+	res, body = do(t, http.MethodGet, url+"a/b", "", nil)
+	if res.StatusCode != http.StatusOK || body != "456" || res.Header.Get("X-JWCache-TTL") != "60" {
+		t.Fatalf("get a/b: %d %s ttl %s", res.StatusCode, body, res.Header.Get("X-JWCache-TTL"))
+	}
+	if res.Header.Get("ETag") == etag {
+		t.Fatalf("different values should have different etags")
+	}
+
+	if res, _ := do(t, http.MethodDelete, url+"a/b", "", nil); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete should return 204, but %d got", res.StatusCode)
+	}
+	if res, _ := do(t, http.MethodGet, url+"a/b", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("deleted key should return 404, but %d got", res.StatusCode)
+	}
+
+	if res, _ := do(t, http.MethodPut, url+"c", "1", map[string]string{"X-JWCache-TTL": "-1"}); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad ttl should return 400, but %d got", res.StatusCode)
+	}
+}
+
+// TestGetCompressed 压缩的值返回解压后的内容，ETag 与没有压缩时相同
+func TestGetCompressed(t *testing.T) {
+	server := newServer(t)
+	value := strings.Repeat("Tom", 1000)
+	getter := cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	})
+	cache.NewGroupOpts("zipped", 64<<10, getter, &cache.GroupOptions{Compression: cache.CompressionGzip})
+	cache.NewGroup("plain", 64<<10, getter)
+
+	res, body := do(t, http.MethodGet, server.URL+"/v1/groups/zipped/keys/Tom", "", nil)
+	if res.StatusCode != http.StatusOK || body != value || res.ContentLength != int64(len(value)) {
+		t.Fatalf("compressed value should be decompressed: %d, %d bytes, content length %d", res.StatusCode, len(body), res.ContentLength)
+	}
+	etag := res.Header.Get("ETag")
+	if plain, _ := do(t, http.MethodGet, server.URL+"/v1/groups/plain/keys/Tom", "", nil); plain.Header.Get("ETag") != etag {
+		t.Fatalf("etag should be computed on the decompressed value")
+	}
+	if res, _ := do(t, http.MethodGet, server.URL+"/v1/groups/zipped/keys/Tom", "", map[string]string{"If-None-Match": etag}); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("matched etag should return 304, but %d got", res.StatusCode)
+	}
+}
+
+func TestListGroups(t *testing.T) {
+	server := newServer(t)
+	res, body := do(t, http.MethodGet, server.URL+"/v1/groups", "", nil)
+	var groups struct {
+		Groups []string `json:"groups"`
+	}
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &groups) != nil {
+		t.Fatalf("list groups: %d %s", res.StatusCode, body)
+	}
+	for _, name := range groups.Groups {
+		if name == "users" {
+			return
+		}
+	}
+	t.Fatalf("users should be listed: %s", body)
+}
+
+func TestStatsAndKeys(t *testing.T) {
+	server := newServer(t)
+	do(t, http.MethodPut, server.URL+"/v1/groups/users/keys/Sam", "345", nil)
+
+	res, body := do(t, http.MethodGet, server.URL+"/v1/groups/users/keys", "", nil)
+	var keys struct {
+		Keys []string `json:"keys"`
+	}
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &keys) != nil || len(keys.Keys) == 0 {
+		t.Fatalf("list keys: %d %s", res.StatusCode, body)
+	}
+
+	res, body = do(t, http.MethodGet, server.URL+"/v1/stats", "", nil)
+	var stats struct {
+		Groups []api.GroupStats `json:"groups"`
+	}
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &stats) != nil {
+		t.Fatalf("stats: %d %s", res.StatusCode, body)
+	}
+	for _, g := range stats.Groups {
+		if g.Name == "users" && g.Items == len(keys.Keys) && g.Bytes > 0 && g.MaxBytes == 2<<10 {
+			return
+		}
+	}
+	t.Fatalf("unexpected stats: %s", body)
+}
+
+func TestLogLevel(t *testing.T) {
+	server := newServer(t)
+	defer log.SetDefault(nil)
+	logger, _ := log.New(log.Options{Level: "info"})
+	log.SetDefault(logger)
+
+	if res, body := do(t, http.MethodGet, server.URL+"/v1/log/level", "", nil); res.StatusCode != http.StatusOK || !strings.Contains(body, `"level":"info"`) {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, body)
+	}
+	if res, body := do(t, http.MethodPut, server.URL+"/v1/log/level", `{"level":"debug"}`, nil); res.StatusCode != http.StatusOK || !strings.Contains(body, `"level":"debug"`) {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, body)
+	}
+	if logger.Level() != "debug" {
+		t.Fatalf("level should be changed")
+	}
+	if res, _ := do(t, http.MethodPut, server.URL+"/v1/log/level", `{"level":"loud"}`, nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown level should be rejected, got %d", res.StatusCode)
+	}
+
+	log.SetDefault(log.Discard)
+	if res, _ := do(t, http.MethodGet, server.URL+"/v1/log/level", "", nil); res.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("loggers without levels should return 501, got %d", res.StatusCode)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	server := newServer(t)
+	if res, _ := do(t, http.MethodPost, server.URL+"/v1/snapshot", "", nil); res.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("snapshot without snapshotter should return 501, got %d", res.StatusCode)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwcache.snap")
+	mux := http.NewServeMux()
+	api.NewServerOpts(&api.ServerOptions{Snapshotter: cache.NewSnapshotter(path)}).Mount(mux)
+	withSnapshot := httptest.NewServer(mux)
+	defer withSnapshot.Close()
+	do(t, http.MethodPut, withSnapshot.URL+"/v1/groups/users/keys/Jack", "456", nil)
+
+	if res, _ := do(t, http.MethodGet, withSnapshot.URL+"/v1/snapshot", "", nil); res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("snapshot only accepts POST, got %d", res.StatusCode)
+	}
+	res, body := do(t, http.MethodPost, withSnapshot.URL+"/v1/snapshot", "", nil)
+	var snapshot api.Snapshot
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &snapshot) != nil {
+		t.Fatalf("unexpected response %d %s", res.StatusCode, body)
+	}
+	if snapshot.Path != path || snapshot.Entries == 0 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("snapshot file should be written: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"jw-cache/src/cache"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// openAOF 打开 dir 中的追加日志，恢复同名的新分组后关联该分组
+func openAOF(t *testing.T, dir, name string, opts *cache.AOFOptions) (*cache.AOF, *cache.Group, cache.AOFStats) {
+	t.Helper()
+	group := cache.NewGroup(name, 1<<20, notFound)
+	aof, err := cache.OpenAOF(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := aof.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Attach(group)
+	t.Cleanup(func() { aof.Close() })
+	return aof, group, stats
+}
+
+// checkState 检查分组中 keys 的值与 want 相同，want 中没有的 key 不应该存在
+func checkState(t *testing.T, group *cache.Group, keys []string, want map[string]string) {
+	t.Helper()
+	for _, key := range keys {
+		view, err := group.GetLocal(key)
+		w, ok := want[key]
+		switch {
+		case ok && (err != nil || view.String() != w):
+			t.Fatalf("%s: want %q, got %q, %v", key, w, view.String(), err)
+		case !ok && err == nil:
+			t.Fatalf("%s: should be deleted, got %q", key, view.String())
+		}
+	}
+}
+
+func TestAOFReplay(t *testing.T) {
+	dir := t.TempDir()
+	aof, group, _ := openAOF(t, dir, "aof", nil)
+	group.Set("a", []byte("1"), 0)
+	group.Set("b", []byte("2"), time.Hour)
+	group.Set("c", []byte("3"), 20*time.Millisecond)
+	group.Delete("b")
+	group.Set("a", []byte("1b"), 0)
+	if err := aof.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := group.Set("d", []byte("4"), 0); err == nil {
+		t.Fatalf("writing to a closed aof should fail")
+	}
+	// 追加失败时缓存不变，缓存中不会出现日志中没有的修改
+	if err := group.Delete("a"); err == nil {
+		t.Fatalf("deleting with a closed aof should fail")
+	}
+	checkState(t, group, []string{"a", "d"}, map[string]string{"a": "1b"})
+
+	time.Sleep(30 * time.Millisecond)
+	_, restored, stats := openAOF(t, dir, "aof", nil)
+	if stats.Records != 5 || stats.Skipped != 1 || stats.Truncated != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if view, err := restored.GetLocal("a"); err != nil || view.String() != "1b" || restored.Stats().Loads != 0 {
+		t.Fatalf("a should be replayed without loading from getter, got %q, %v", view.String(), err)
+	}
+	checkState(t, restored, []string{"a", "b", "c"}, map[string]string{"a": "1b"})
+}
+
+func TestAOFLogsExpire(t *testing.T) {
+	dir := t.TempDir()
+	aof, group, _ := openAOF(t, dir, "aof-expire", &cache.AOFOptions{Fsync: cache.FsyncNo})
+	group.Set("x", []byte("1"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := group.GetLocal("x"); err == nil {
+		t.Fatalf("x should be expired")
+	}
+	aof.Close()
+	_, _, stats := openAOF(t, dir, "aof-expire", nil)
+	if stats.Records != 2 {
+		t.Fatalf("expiry should be logged, got %+v", stats)
+	}
+}
+
+func TestAOFBadFsync(t *testing.T) {
+	if _, err := cache.OpenAOF(t.TempDir(), &cache.AOFOptions{Fsync: "sometimes"}); err == nil {
+		t.Fatalf("unknown fsync policy should fail")
+	}
+}
+
+// TestAOFCrashConsistency 在随机的位置截断日志，模拟写入过程中崩溃，恢复的状态应该等于截断位置之前所有完整记录的结果
+func TestAOFCrashConsistency(t *testing.T) {
+	const name = "aof-crash"
+	dir := t.TempDir()
+	aof, group, _ := openAOF(t, dir, name, &cache.AOFOptions{Fsync: cache.FsyncAlways})
+	logPath := filepath.Join(dir, "jwcache.1.aof")
+
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	model := map[string]string{}
+	// sizes[i] 为执行 i 次操作后日志的大小，states[i] 为此时的状态
+	sizes := []int64{fileSize(t, logPath)}
+	states := []map[string]string{{}}
+	for i := 0; i < 200; i++ {
+		key := keys[rnd.Intn(len(keys))]
+		if rnd.Intn(4) == 0 {
+			if err := group.Delete(key); err != nil {
+				t.Fatal(err)
+			}
+			delete(model, key)
+		} else {
+			value := fmt.Sprintf("value-%d-%s", i, string(make([]byte, rnd.Intn(64))))
+			if err := group.Set(key, []byte(value), 0); err != nil {
+				t.Fatal(err)
+			}
+			model[key] = value
+		}
+		sizes = append(sizes, fileSize(t, logPath))
+		states = append(states, copyState(model))
+	}
+	aof.Close()
+
+	offsets := []int64{0, 3, sizes[0], sizes[0] + 1, sizes[len(sizes)-1] - 1, sizes[len(sizes)-1]}
+	for i := 0; i < 50; i++ {
+		offsets = append(offsets, rnd.Int63n(sizes[len(sizes)-1]+1))
+	}
+	for _, offset := range offsets {
+		crashed := t.TempDir()
+		copyFile(t, logPath, filepath.Join(crashed, "jwcache.1.aof"), offset)
+		// 截断位置之前最后一条完整的记录
+		n := sort.Search(len(sizes), func(i int) bool { return sizes[i] > offset }) - 1
+		good := int64(0)
+		want := map[string]string{}
+		if n >= 0 {
+			good, want = sizes[n], states[n]
+		}
+
+		aof, restored, stats := openAOF(t, crashed, name, nil)
+		checkState(t, restored, keys, want)
+		if stats.Truncated != offset-good {
+			t.Fatalf("offset %d: want %d bytes truncated, got %+v", offset, offset-good, stats)
+		}
+		// 截断之后追加的记录可以继续被恢复
+		restored.Set("after", []byte("crash"), 0)
+		aof.Close()
+		_, again, stats := openAOF(t, crashed, name, nil)
+		want = copyState(want)
+		want["after"] = "crash"
+		checkState(t, again, append(keys, "after"), want)
+		if stats.Truncated != 0 {
+			t.Fatalf("offset %d: log should be clean after truncation, got %+v", offset, stats)
+		}
+	}
+}
+
+func TestAOFCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	aof, group, _ := openAOF(t, dir, "aof-corrupt", &cache.AOFOptions{Fsync: cache.FsyncAlways})
+	logPath := filepath.Join(dir, "jwcache.1.aof")
+	group.Set("a", []byte("1"), 0)
+	size := fileSize(t, logPath)
+	group.Set("b", []byte("2"), 0)
+	group.Set("c", []byte("3"), 0)
+	aof.Close()
+
+	// 破坏 b 的值，b 和之后的记录都不会被恢复
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[size+20] ^= 0xff
+	if err := os.WriteFile(logPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, restored, stats := openAOF(t, dir, "aof-corrupt", nil)
+	checkState(t, restored, []string{"a", "b", "c"}, map[string]string{"a": "1"})
+	if stats.Records != 1 || stats.Truncated != int64(len(data))-size {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAOFCompact(t *testing.T) {
+	dir := t.TempDir()
+	aof, group, _ := openAOF(t, dir, "aof-compact", &cache.AOFOptions{CompactSize: -1})
+	for i := 0; i < 100; i++ {
+		group.Set(fmt.Sprintf("key%d", i%10), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	before := aof.Size()
+	stats, err := aof.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 10 || aof.Size() >= before {
+		t.Fatalf("unexpected compaction %+v, log size %d -> %d", stats, before, aof.Size())
+	}
+	if aof.Path() != filepath.Join(dir, "jwcache.2.snap") {
+		t.Fatalf("unexpected snapshot path %s", aof.Path())
+	}
+	// 压缩之后的修改写入新的日志
+	group.Delete("key0")
+	group.Set("key1", []byte("changed"), 0)
+	aof.Close()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "jwcache.2.aof" || names[1] != "jwcache.2.snap" {
+		t.Fatalf("old files should be removed, got %v", names)
+	}
+
+	_, restored, replayed := openAOF(t, dir, "aof-compact", nil)
+	if replayed.Snapshot.Entries != 10 || replayed.Records != 2 {
+		t.Fatalf("unexpected stats %+v", replayed)
+	}
+	want := map[string]string{"key1": "changed"}
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		if i > 1 {
+			want[key] = fmt.Sprintf("value%d", 90+i)
+		}
+	}
+	checkState(t, restored, keys, want)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// copyFile 复制文件的前 n 个字节
+func copyFile(t *testing.T, src, dst string, n int64) {
+	t.Helper()
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := io.CopyN(out, in, n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyState(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"fmt"
+	"jw-cache/src/cache"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 用于构造 ByteView 的分组，Getter 直接返回 key 本身
+var views = cache.NewGroup("arena-views", 0, cache.GetterFunc(func(key string) ([]byte, error) {
+	return []byte(key), nil
+}))
+
+func viewOf(s string) cache.ByteView {
+	view, _ := views.GetLocal(s)
+	return view
+}
+
+func TestArena(t *testing.T) {
+	const maxBytes = 64 << 10
+	group := cache.NewGroupOpts("arena", maxBytes, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}), &cache.GroupOptions{Evicter: cache.PolicyArena})
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 2000; i++ {
+		group.Set(fmt.Sprintf("key-%d", i), []byte(value), 0)
+	}
+	if stats := group.Stats(); stats.Bytes > maxBytes || stats.Items == 0 {
+		t.Fatalf("arena should not exceed %d bytes, but %d got", maxBytes, stats.Bytes)
+	}
+	if _, err := group.Get("key-0"); err != cache.ErrNotFound {
+		t.Fatalf("the earliest key should be evicted")
+	}
+	if view, err := group.Get("key-1999"); err != nil || view.String() != value {
+		t.Fatalf("the latest key should be kept")
+	}
+
+	group.Set("key-1999", []byte("new value"), 0)
+	if view, _ := group.Get("key-1999"); view.String() != "new value" {
+		t.Fatalf("value should be overwritten")
+	}
+	group.Delete("key-1999")
+	if _, err := group.Get("key-1999"); err != cache.ErrNotFound {
+		t.Fatalf("deleted key should not be found")
+	}
+
+	group.Set("ttl", []byte("value"), 30*time.Millisecond)
+	if view, err := group.Get("ttl"); err != nil || view.Expire().IsZero() {
+		t.Fatalf("value with ttl should be found before expiration")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := group.Get("ttl"); err != cache.ErrNotFound {
+		t.Fatalf("expired key should not be found")
+	}
+}
+
+// TestArenaRequiresCapacity arena 为每个分片预先分配内存，不能不限制容量
+func TestArenaRequiresCapacity(t *testing.T) {
+	if _, err := cache.NewGroupE("arena-unlimited", 0, notFound, &cache.GroupOptions{Evicter: cache.PolicyArena}); err == nil {
+		t.Fatalf("arena group without cache size should be rejected")
+	}
+
+	group := cache.NewGroupOpts("arena-resize", 64<<10, notFound, &cache.GroupOptions{Evicter: cache.PolicyArena})
+	if err := group.SetCacheBytes(0); err == nil || group.CacheBytes() != 64<<10 {
+		t.Fatalf("arena group should not become unlimited, got %d, %v", group.CacheBytes(), err)
+	}
+	if err := cache.NewArena(4<<10, nil).SetMaxCapacity(0); err == nil {
+		t.Fatalf("arena should not become unlimited")
+	}
+}
+
+// TestArenaTooLarge 比整个缓冲区还大的值写入后立即被淘汰
+func TestArenaTooLarge(t *testing.T) {
+	var evicted []string
+	arena := cache.NewArena(1<<10, func(key string, value cache.Value) {
+		evicted = append(evicted, fmt.Sprintf("%s:%d", key, value.Len()))
+	})
+	arena.Add("small", viewOf("v"))
+	arena.Add("large", viewOf(strings.Repeat("v", 2<<10)))
+	if _, ok := arena.Get("large"); ok || arena.Len() != 1 {
+		t.Fatalf("large value should not be stored, %d items", arena.Len())
+	}
+	if fmt.Sprint(evicted) != "[large:2048]" {
+		t.Fatalf("large value should be evicted, got %v", evicted)
+	}
+	group := cache.NewGroupOpts("arena-too-large", 4<<10, notFound, &cache.GroupOptions{Evicter: cache.PolicyArena, Shards: 1})
+	group.SetLocal("large", []byte(strings.Repeat("v", 8<<10)), 0)
+	if stats := group.Stats(); stats.Items != 0 || stats.EvictedCapacity != 1 {
+		t.Fatalf("dropped value should be counted as evicted, got %+v", stats)
+	}
+}
+
+func TestArenaRandomOps(t *testing.T) {
+	var evicted int
+	arena := cache.NewArena(4<<10, func(key string, value cache.Value) { evicted++ })
+	expected := make(map[string]string)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key-%d", r.Intn(200))
+		switch r.Intn(4) {
+		case 0, 1:
+			value := strings.Repeat(key, 1+r.Intn(8))
+			arena.Add(key, viewOf(value))
+			expected[key] = value
+		case 2:
+			arena.Delete(key)
+			delete(expected, key)
+		case 3:
+			// 数据可能已经被淘汰，但返回的数据一定是最后一次写入的值
+			if v, ok := arena.Get(key); ok && v.(cache.ByteView).String() != expected[key] {
+				t.Fatalf("unexpected value of %s after %d operations", key, i)
+			}
+		}
+		if arena.NowSize() > arena.MaxCapacity() || arena.Len() != len(arena.Keys()) {
+			t.Fatalf("inconsistent arena after %d operations", i)
+		}
+	}
+	if evicted == 0 {
+		t.Fatalf("older entries should be evicted when the arena is full")
+	}
+}
+
+// 对比大量较小的值保存在 LRU 和 arena 中时 GC 的耗时
+func benchmarkGCWith(b *testing.B, policy string) {
+	group := cache.NewGroupOpts("gc-"+policy, 256<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &cache.GroupOptions{Evicter: policy})
+	for i := 0; i < 1000000; i++ {
+		group.Get(fmt.Sprintf("key-%d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+}
+
+func BenchmarkGCWithLRU(b *testing.B)   { benchmarkGCWith(b, cache.PolicyLRU) }
+func BenchmarkGCWithArena(b *testing.B) { benchmarkGCWith(b, cache.PolicyArena) }
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"jw-cache/src/cache"
+	"strings"
+	"testing"
+)
+
+func TestByteViewHelpers(t *testing.T) {
+	value := strings.Repeat("0123456789", 200)
+	plain := cache.NewGroup("view-plain", 1<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}))
+	compressed := cache.NewGroupOpts("view-compressed", 1<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}), &cache.GroupOptions{Compression: cache.CompressionFlate})
+
+	for _, group := range []*cache.Group{plain, compressed} {
+		view, err := group.Get("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.At(11) != '1' || !view.Slice(10, 20).EqualString("0123456789") || view.SliceFrom(1990).String() != "0123456789" {
+			t.Fatalf("%s: unexpected slice of view", group.Name())
+		}
+		if !view.EqualBytes([]byte(value)) || !view.Equal(view.Slice(0, len(value))) || view.EqualString("other") {
+			t.Fatalf("%s: unexpected equality of view", group.Name())
+		}
+		var buf bytes.Buffer
+		if n, err := view.WriteTo(&buf); err != nil || n != int64(len(value)) || buf.String() != value {
+			t.Fatalf("%s: WriteTo wrote %d bytes, %v", group.Name(), n, err)
+		}
+		if b, err := io.ReadAll(view.Reader()); err != nil || string(b) != value {
+			t.Fatalf("%s: Reader returned unexpected data", group.Name())
+		}
+	}
+}
@@ -34,3 +34,31 @@ func TestRemove(t *testing.T) {
 		t.Fatalf("缓存失败")
 	}
 }
+
+func TestFIFO(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "key3"
+	v1, v2, v3 := "value1", "value2", "value3"
+	fifo := cache.NewFIFO(int64(len(k1+k2+v1+v2)), nil)
+	fifo.Add(k1, String(v1))
+	fifo.Add(k2, String(v2))
+	// 访问 key1 不会改变淘汰顺序，key1 仍然最先被淘汰
+	fifo.Get(k1)
+	fifo.Add(k3, String(v3))
+	if _, ok := fifo.Get(k1); ok || fifo.Len() != 2 {
+		t.Fatalf("key1 should be evicted first")
+	}
+	if _, ok := fifo.Get(k2); !ok {
+		t.Fatalf("key2 should not be evicted")
+	}
+}
+
+func TestNewEvicter(t *testing.T) {
+	for _, policy := range []string{"", cache.PolicyLRU, cache.PolicyFIFO, cache.PolicyArena} {
+		if _, err := cache.NewEvicter(policy, 1024, nil); err != nil {
+			t.Fatalf("policy %q should be supported: %v", policy, err)
+		}
+	}
+	if _, err := cache.NewEvicter("unknown", 1024, nil); err == nil {
+		t.Fatalf("unknown policy should return an error")
+	}
+}
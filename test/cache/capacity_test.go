@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"fmt"
+	"jw-cache/src/cache"
+	"jw-cache/src/cache/cache_evicter"
+	k "jw-cache/src/cache/cache_key"
+	v "jw-cache/src/cache/cache_value"
+	"testing"
+)
+
+func TestSetMaxCapacity(t *testing.T) {
+	factories := map[string]func(onEvicted func(string, cache.Value)) cache.Evicter{
+		"lru": func(onEvicted func(string, cache.Value)) cache.Evicter { return cache.New(1<<10, onEvicted) },
+		"fifo": func(onEvicted func(string, cache.Value)) cache.Evicter {
+			return cache.NewFIFO(1<<10, onEvicted)
+		},
+		"arena": func(onEvicted func(string, cache.Value)) cache.Evicter {
+			return cache.NewArena(1<<10, onEvicted)
+		},
+	}
+	for name, factory := range factories {
+		var evicted []string
+		c := factory(func(key string, _ cache.Value) { evicted = append(evicted, key) })
+		for i := 10; i < 34; i++ {
+			key := fmt.Sprintf("key%d", i)
+			c.Add(key, viewOf(key))
+		}
+		setter, ok := c.(cache.CapacitySetter)
+		if !ok {
+			t.Fatalf("%s: built-in evicter should implement CapacitySetter", name)
+		}
+		// 每条数据的键和值共 10 字节，24 条数据超过了 100 字节，arena 还需要保存数据头部
+		if err := setter.SetMaxCapacity(100); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.MaxCapacity() != 100 || c.NowSize() > 100 {
+			t.Fatalf("%s: should shrink to 100 bytes, got %d/%d", name, c.NowSize(), c.MaxCapacity())
+		}
+		if len(evicted) == 0 || evicted[0] != "key10" {
+			t.Fatalf("%s: oldest keys should be evicted immediately, got %v", name, evicted)
+		}
+		if _, ok := c.Peek("key33"); !ok {
+			t.Fatalf("%s: newest key should be kept", name)
+		}
+		if err := setter.AdjustCapacity(100); err != nil || c.MaxCapacity() != 200 {
+			t.Fatalf("%s: adjust capacity failed: %v, %d", name, err, c.MaxCapacity())
+		}
+		if err := setter.AdjustCapacity(-200); err == nil {
+			t.Fatalf("%s: capacity should stay positive", name)
+		}
+		if err := setter.SetMaxCapacity(-1); err == nil {
+			t.Fatalf("%s: negative capacity should be rejected", name)
+		}
+	}
+}
+
+func TestGroupSetCacheBytes(t *testing.T) {
+	group := cache.NewGroupOpts("resize", 4<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}), &cache.GroupOptions{Shards: 4})
+	value := make([]byte, 1024)
+	for i := 0; i < 4000; i++ {
+		group.Set(fmt.Sprintf("key-%d", i), value, 0)
+	}
+	if err := group.SetCacheBytes(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	stats := group.Stats()
+	if group.CacheBytes() != 1<<20 || stats.MaxBytes != 1<<20 || stats.Bytes > 1<<20 {
+		t.Fatalf("cache should shrink to 1MB immediately, got %d/%d", stats.Bytes, stats.MaxBytes)
+	}
+	if stats.EvictedCapacity == 0 {
+		t.Fatalf("shrinking should be counted as capacity evictions")
+	}
+	if err := group.SetCacheBytes(2); err == nil {
+		t.Fatalf("capacity less than the number of shards should be rejected")
+	}
+	if err := group.SetCacheBytes(0); err != nil || group.CacheBytes() != 0 {
+		t.Fatalf("capacity 0 should mean unlimited: %v", err)
+	}
+}
+
+func TestLRUCacheEvictCapacity(t *testing.T) {
+	var evicted []string
+	lru := cache_evicter.NewLRUCache(100, func(key *k.Key, value v.CacheValue) {
+		evicted = append(evicted, key.String())
+	})
+	var setter cache.CapacitySetter = lru
+	keys := make([]*k.Key, 5)
+	for i := range keys {
+		keys[i] = k.NewKey(fmt.Sprintf("key%d", i))
+		lru.Add(keys[i], v.NewStringValue("0123456789", 0)) // 每个值占用 14 字节
+	}
+	lru.Get(keys[0])
+	if lru.NowSize() != 70 {
+		t.Fatalf("unexpected size %d", lru.NowSize())
+	}
+	// 容量变小时立即淘汰最近最少使用的数据
+	if err := setter.SetMaxCapacity(30); err != nil || lru.NowSize() != 28 || lru.MaxCapacity() != 30 {
+		t.Fatalf("unexpected size %d/%d, %v", lru.NowSize(), lru.MaxCapacity(), err)
+	}
+	if fmt.Sprint(evicted) != "[key1 key2 key3]" {
+		t.Fatalf("unexpected evicted keys %v", evicted)
+	}
+	if err := setter.AdjustCapacity(-20); err != nil || lru.NowSize() != 0 || lru.MaxCapacity() != 10 {
+		t.Fatalf("unexpected size %d/%d, %v", lru.NowSize(), lru.MaxCapacity(), err)
+	}
+	if setter.AdjustCapacity(-10) == nil || setter.SetMaxCapacity(0) == nil {
+		t.Fatalf("capacity should be positive")
+	}
+}
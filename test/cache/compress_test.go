@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"strings"
+	"testing"
+)
+
+func TestGroupCompression(t *testing.T) {
+	group := cache.NewGroupOpts("compress", 1<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}), &cache.GroupOptions{Compression: cache.CompressionGzip, CompressThreshold: 64})
+
+	large := strings.Repeat(`{"name":"jw-cache","score":100}`, 200)
+	group.Set("large", []byte(large), 0)
+	group.Set("small", []byte("tiny"), 0)
+
+	view, err := group.Get("large")
+	if err != nil || view.String() != large || string(view.ByteSlice()) != large {
+		t.Fatalf("compressed value should be read transparently")
+	}
+	if view.Encoding() != cache.EncodingGzip || view.Len() >= len(large) {
+		t.Fatalf("large value should be stored compressed, encoding %s, len %d", view.Encoding(), view.Len())
+	}
+	if stats := group.Stats(); stats.Bytes >= int64(len(large)) {
+		t.Fatalf("cache should account compressed size, but %d bytes got", stats.Bytes)
+	}
+	if view, _ := group.Get("small"); view.Encoding() != cache.EncodingIdentity || view.String() != "tiny" {
+		t.Fatalf("value below threshold should not be compressed")
+	}
+}
+
+// 模拟一个返回固定响应的节点
+type staticNode struct {
+	res *pb.Response
+}
+
+func (n staticNode) Get(in *pb.Request, out *pb.Response) error {
+	out.Value, out.Encoding = n.res.Value, n.res.Encoding
+	return nil
+}
+
+func TestGetFromNodeCompressed(t *testing.T) {
+	group := cache.NewGroup("compress-node", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}))
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("remote value"))
+	w.Close()
+
+	node := staticNode{res: &pb.Response{Value: buf.Bytes(), Encoding: uint32(cache.EncodingGzip)}}
+	if view, err := group.GetFromNode(node, "key"); err != nil || view.String() != "remote value" {
+		t.Fatalf("compressed response should be decoded, %q %v got", view.String(), err)
+	}
+	corrupt := staticNode{res: &pb.Response{Value: []byte("not gzip"), Encoding: uint32(cache.EncodingGzip)}}
+	if _, err := group.GetFromNode(corrupt, "key"); err == nil {
+		t.Fatalf("corrupt response should return an error")
+	}
+}
@@ -3,9 +3,11 @@ package cache
 import (
 	"fmt"
 	"jw-cache/src/cache"
+	"jw-cache/src/nodes"
 	"log"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -51,3 +53,80 @@ func TestGroupGet(t *testing.T) {
 		t.Fatalf("the value of unknown should be emtry, but %s got", view)
 	}
 }
+
+type fakePicker struct{}
+
+func (fakePicker) PickNode(key string) (nodes.NodeGetter, bool) {
+	return nil, false
+}
+
+func TestRegisterNodes(t *testing.T) {
+	group := cache.NewGroup("register", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	if err := group.RegisterNodes(fakePicker{}); err != nil {
+		t.Fatalf("first registration should succeed: %v", err)
+	}
+	if err := group.RegisterNodes(fakePicker{}); err == nil {
+		t.Fatalf("second registration should return an error")
+	}
+}
+
+func TestNewGroupE(t *testing.T) {
+	for name, opts := range map[string]*cache.GroupOptions{
+		"bad-evicter":     {Evicter: "random"},
+		"bad-compression": {Compression: "zip"},
+	} {
+		if g, err := cache.NewGroupE(name, 2<<10, notFound, opts); err == nil || g != nil {
+			t.Fatalf("%s: bad options should be rejected", name)
+		}
+		if cache.GetGroup(name) != nil {
+			t.Fatalf("%s: rejected group should not be registered", name)
+		}
+	}
+	if _, err := cache.NewGroupE("nil-getter", 2<<10, nil, nil); err == nil {
+		t.Fatalf("nil getter should be rejected")
+	}
+	if g, err := cache.NewGroupE("good", 2<<10, notFound, nil); err != nil || cache.GetGroup("good") != g {
+		t.Fatalf("group should be created and registered, got %v", err)
+	}
+}
+
+func TestGroupSetWithTTL(t *testing.T) {
+	group := cache.NewGroup("ttl", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}))
+	if err := group.Set("key", []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := group.Get("key"); err != nil || view.String() != "value" || view.Expire().IsZero() {
+		t.Fatalf("failed to get value of key")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := group.Get("key"); err != cache.ErrNotFound {
+		t.Fatalf("expired key should not be found, but %v got", err)
+	}
+	group.Set("key", []byte("value"), 0)
+	group.Delete("key")
+	if _, err := group.Get("key"); err != cache.ErrNotFound {
+		t.Fatalf("deleted key should not be found, but %v got", err)
+	}
+}
+
+func TestGroupTTL(t *testing.T) {
+	loads := 0
+	group := cache.NewGroupOpts("group-ttl", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), &cache.GroupOptions{TTL: 50 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		if view, err := group.Get("key"); err != nil || view.Expire().IsZero() {
+			t.Fatalf("loaded value should expire, got %v %v", view, err)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	group.Get("key")
+	if loads != 2 {
+		t.Fatalf("expired value should be loaded again, loads = %d", loads)
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"bytes"
+	"jw-cache/src/cache"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportEntries(t *testing.T) {
+	group := cache.NewGroup("handoff", 1<<20, notFound)
+	group.Set("a1", []byte("1"), 0)
+	group.Set("a2", []byte("2"), time.Hour)
+	group.Set("a3", []byte("3"), 10*time.Millisecond)
+	group.Set("b1", []byte("4"), 0)
+	var buf bytes.Buffer
+	stats, err := group.ExportEntries(&buf, func(key string) bool { return strings.HasPrefix(key, "a") })
+	if err != nil || stats.Entries != 3 {
+		t.Fatalf("unexpected export %+v, %v", stats, err)
+	}
+
+	// 新的节点上已经写入的值比交接的值更新，不会被覆盖
+	time.Sleep(20 * time.Millisecond)
+	restored := cache.NewGroup("handoff", 1<<20, notFound)
+	restored.Set("a1", []byte("newer"), 0)
+	stats, err = restored.ImportEntries(&buf)
+	if err != nil || stats.Entries != 1 || stats.Skipped != 2 {
+		t.Fatalf("unexpected import %+v, %v", stats, err)
+	}
+	checkState(t, restored, []string{"a1", "a2", "a3", "b1"}, map[string]string{"a1": "newer", "a2": "2"})
+}
+
+func TestImportSkipsTrackedWrites(t *testing.T) {
+	group := cache.NewGroup("handoff-tracked", 1<<20, notFound)
+	group.Set("a1", []byte("1"), 0)
+	group.Set("a2", []byte("2"), 0)
+	group.Set("a3", []byte("3"), 0)
+	var buf bytes.Buffer
+	if stats, err := group.ExportEntries(&buf, nil); err != nil || stats.Entries != 3 {
+		t.Fatalf("unexpected export %+v, %v", stats, err)
+	}
+
+	// 交接期间在新的节点删除或写入的键不会被旧节点的值覆盖
+	restored := cache.NewGroup("handoff-tracked", 1<<20, notFound)
+	stop := restored.TrackWrites()
+	restored.SetLocal("a1", []byte("deleted"), 0)
+	restored.DeleteLocal("a1")
+	restored.SetLocal("a2", []byte("newer"), 0)
+	stats, err := restored.ImportEntries(&buf)
+	stop()
+	if err != nil || stats.Entries != 1 || stats.Skipped != 2 {
+		t.Fatalf("unexpected import %+v, %v", stats, err)
+	}
+	checkState(t, restored, []string{"a1", "a2", "a3"}, map[string]string{"a2": "newer", "a3": "3"})
+
+	// 记录结束之后删除的键可以再次导入
+	restored.DeleteLocal("a3")
+	buf.Reset()
+	group.ExportEntries(&buf, nil)
+	if stats, err := restored.ImportEntries(&buf); err != nil || stats.Entries != 2 {
+		t.Fatalf("unexpected import %+v, %v", stats, err)
+	}
+	checkState(t, restored, []string{"a1", "a2", "a3"}, map[string]string{"a1": "1", "a2": "newer", "a3": "3"})
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"jw-cache/src/cache"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	hitRatioKeys     = 10000 // 访问的 key 的数量
+	hitRatioAccesses = 200000
+	hitRatioCapacity = 500 // 缓存能容纳的值的数量
+	hitRatioValueLen = 100
+)
+
+// zipfKeys 生成服从 Zipf 分布的访问序列，少量热点 key 占据了大部分访问
+func zipfKeys(seed int64, n int) []string {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.1, 1, hitRatioKeys-1)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	return keys
+}
+
+// capacityBytes 按照最长的 key 计算能容纳 hitRatioCapacity 个值的容量
+func capacityBytes() int64 {
+	return int64(hitRatioCapacity * (len(strconv.Itoa(hitRatioKeys)) + hitRatioValueLen))
+}
+
+// fixedValue 固定大小的值
+type fixedValue int
+
+func (v fixedValue) Len() int {
+	return int(v)
+}
+
+// referenceHitRatio 直接使用 LRU 计算命中率，每次命中都会立即更新访问记录
+func referenceHitRatio(keys []string) float64 {
+	lru := cache.New(capacityBytes(), nil)
+	value := fixedValue(hitRatioValueLen)
+	hits := 0
+	for _, key := range keys {
+		if _, ok := lru.Get(key); ok {
+			hits++
+		} else {
+			lru.Add(key, value)
+		}
+	}
+	return float64(hits) / float64(len(keys))
+}
+
+func newHitRatioGroup(name string, loads *int64) *cache.Group {
+	value := make([]byte, hitRatioValueLen)
+	return cache.NewGroupOpts(name, capacityBytes(), cache.GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt64(loads, 1)
+		return value, nil
+	}), &cache.GroupOptions{Shards: 1})
+}
+
+func TestHitRatio(t *testing.T) {
+	keys := zipfKeys(1, hitRatioAccesses)
+	expected := referenceHitRatio(keys)
+
+	var loads int64
+	group := newHitRatioGroup("hit-ratio", &loads)
+	for _, key := range keys {
+		group.Get(key)
+	}
+	ratio := 1 - float64(loads)/float64(len(keys))
+	if ratio < expected-0.02 {
+		t.Fatalf("buffered reads should preserve hit ratio, %.4f got, %.4f expected", ratio, expected)
+	}
+	t.Logf("hit ratio %.4f, strict LRU %.4f", ratio, expected)
+}
+
+func TestConcurrentHitRatio(t *testing.T) {
+	const workers = 8
+	var loads int64
+	group := newHitRatioGroup("concurrent-hit-ratio", &loads)
+	var wg sync.WaitGroup
+	var expected float64
+	for w := 0; w < workers; w++ {
+		keys := zipfKeys(int64(w), hitRatioAccesses/workers)
+		expected += referenceHitRatio(keys) / workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range keys {
+				group.Get(key)
+			}
+		}()
+	}
+	wg.Wait()
+	// 并发时部分访问记录会被丢弃，同时缓存被所有 goroutine 共享，允许有少量偏差
+	ratio := 1 - float64(loads)/float64(hitRatioAccesses)
+	if ratio < expected-0.05 {
+		t.Fatalf("concurrent buffered reads should preserve hit ratio, %.4f got, %.4f expected", ratio, expected)
+	}
+	t.Logf("hit ratio %.4f, strict LRU %.4f", ratio, expected)
+}
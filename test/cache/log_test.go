@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"fmt"
+	"jw-cache/src/cache"
+	"sync"
+	"testing"
+)
+
+// recordLogger 记录每条日志的消息和键值对
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordLogger) record(msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(append([]interface{}{msg}, args...)...))
+}
+
+func (l *recordLogger) Debug(msg string, args ...interface{}) { l.record(msg, args) }
+func (l *recordLogger) Info(msg string, args ...interface{})  { l.record(msg, args) }
+func (l *recordLogger) Warn(msg string, args ...interface{})  { l.record(msg, args) }
+func (l *recordLogger) Error(msg string, args ...interface{}) { l.record(msg, args) }
+
+func TestGroupLogger(t *testing.T) {
+	logger := &recordLogger{}
+	g := cache.NewGroupOpts("logged", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}), &cache.GroupOptions{Logger: logger})
+	g.RegisterNodes(onePicker{node: failingNode{}})
+	g.Get("Tom")
+
+	if len(logger.entries) != 1 {
+		t.Fatalf("peer failure should be logged once, got %v", logger.entries)
+	}
+	want := fmt.Sprint("get from node failed", "group", "logged", "key", "Tom", "node", "cache.failingNode", "err", "peer is down")
+	if logger.entries[0] != want {
+		t.Fatalf("unexpected log %q", logger.entries[0])
+	}
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"fmt"
+	"jw-cache/src/cache"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestShardedCapacity(t *testing.T) {
+	const maxBytes = 4 << 20
+	group := cache.NewGroupOpts("sharded", maxBytes, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}), &cache.GroupOptions{Shards: 4})
+
+	value := make([]byte, 1024)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				group.Set(fmt.Sprintf("key-%d-%d", w, i), value, 0)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stats := group.Stats()
+	if stats.Bytes > maxBytes || stats.MaxBytes != maxBytes {
+		t.Fatalf("cache should not exceed %d bytes, but %d got", maxBytes, stats.Bytes)
+	}
+	// 每个分片都应该被填满，说明 key 被均匀地分配到各个分片
+	if stats.Bytes < maxBytes*9/10 {
+		t.Fatalf("shards should be nearly full, but only %d bytes used", stats.Bytes)
+	}
+	if len(group.Keys()) != stats.Items {
+		t.Fatalf("keys should be collected from every shard")
+	}
+}
+
+func TestSmallCacheSingleShard(t *testing.T) {
+	// 容量较小时只使用一个分片，否则 1KB 的值放不进平分后的分片
+	group := cache.NewGroupOpts("small", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return nil, cache.ErrNotFound
+	}), &cache.GroupOptions{Shards: 16})
+	group.Set("key", make([]byte, 1024), 0)
+	if _, err := group.Get("key"); err != nil {
+		t.Fatalf("value should fit into a small cache: %v", err)
+	}
+}
+
+// 在 32 个以上的 goroutine 下对比单个分片和多个分片的并发读取性能
+func benchmarkParallelGet(b *testing.B, shards int) {
+	group := cache.NewGroupOpts("bench-"+strconv.Itoa(shards), 64<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &cache.GroupOptions{Shards: shards})
+	const keys = 1024
+	for i := 0; i < keys; i++ {
+		group.Get(strconv.Itoa(i))
+	}
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			group.Get(strconv.Itoa(i % keys))
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelGet1Shard(b *testing.B)   { benchmarkParallelGet(b, 1) }
+func BenchmarkParallelGet16Shards(b *testing.B) { benchmarkParallelGet(b, 16) }
+func BenchmarkParallelGet64Shards(b *testing.B) { benchmarkParallelGet(b, 64) }
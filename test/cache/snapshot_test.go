@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"jw-cache/src/cache"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var notFound = cache.GetterFunc(func(key string) ([]byte, error) {
+	return nil, cache.ErrNotFound
+})
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	group := cache.NewGroupOpts("snapshot", 1<<20, notFound, &cache.GroupOptions{Compression: "gzip", CompressThreshold: 64})
+	group.Set("a", []byte("1"), 0)
+	group.Set("b", []byte("2"), time.Hour)
+	group.Set("c", []byte(strings.Repeat("compressed ", 100)), 0)
+	group.Set("d", []byte("4"), 20*time.Millisecond)
+	group.Set("a", []byte("1"), 0) // a 变为最近使用的值
+	path := filepath.Join(t.TempDir(), "data", "jwcache.snap")
+	stats, err := cache.SaveSnapshot(path, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Groups != 1 || stats.Entries != 4 || stats.Bytes == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 重新创建同名的分组，相当于节点重启，d 在恢复之前过期
+	time.Sleep(30 * time.Millisecond)
+	restored := cache.NewGroup("snapshot", 1<<20, notFound)
+	stats, err = cache.LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 3 || stats.Skipped != 1 {
+		t.Fatalf("expired value should be skipped, got %+v", stats)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": strings.Repeat("compressed ", 100)} {
+		view, err := restored.GetLocal(key)
+		if err != nil || view.String() != want {
+			t.Fatalf("%s: got %q, %v", key, view.String(), err)
+		}
+	}
+	if view, _ := restored.GetLocal("b"); time.Until(view.Expire()) < 59*time.Minute {
+		t.Fatalf("remaining ttl should be kept, got %v", time.Until(view.Expire()))
+	}
+	if restored.Stats().Loads != 0 {
+		t.Fatalf("restoring should not load from getter")
+	}
+}
+
+func TestSnapshotKeepsLRUOrder(t *testing.T) {
+	group := cache.NewGroup("snapshot-order", 1<<20, notFound)
+	for _, key := range []string{"k1", "k2", "k3", "k1"} {
+		group.Set(key, []byte("value"), 0)
+	}
+	path := filepath.Join(t.TempDir(), "order.snap")
+	if _, err := cache.SaveSnapshot(path, group); err != nil {
+		t.Fatal(err)
+	}
+	// 容量只能放下两个值，最先被淘汰的 k2 不会被恢复
+	restored := cache.NewGroup("snapshot-order", 15, notFound)
+	if _, err := cache.LoadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.GetLocal("k2"); err == nil {
+		t.Fatalf("least recently used key should be evicted first")
+	}
+	for _, key := range []string{"k1", "k3"} {
+		if _, err := restored.GetLocal(key); err != nil {
+			t.Fatalf("%s should be restored: %v", key, err)
+		}
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	group := cache.NewGroup("snapshot-corrupt", 1<<20, notFound)
+	group.Set("key", []byte("value"), 0)
+	var buf bytes.Buffer
+	if _, err := cache.WriteSnapshot(&buf, group); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	dir := t.TempDir()
+	cases := map[string][]byte{
+		"flipped":   append(append([]byte{}, data[:20]...), append([]byte{data[20] ^ 0xff}, data[21:]...)...),
+		"truncated": data[:len(data)-3],
+		"version":   append(append([]byte("JWCS"), 9, 0), data[6:]...),
+		"magic":     append([]byte("NOPE"), data[4:]...),
+	}
+	for name, corrupt := range cases {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		restored := cache.NewGroup("snapshot-corrupt", 1<<20, notFound)
+		if _, err := cache.LoadSnapshot(path); !errors.Is(err, cache.ErrBadSnapshot) {
+			t.Fatalf("%s: expect ErrBadSnapshot, got %v", name, err)
+		}
+		if len(restored.Keys()) != 0 {
+			t.Fatalf("%s: nothing should be restored from a bad snapshot", name)
+		}
+	}
+	if _, err := cache.LoadSnapshot(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("missing snapshot should be reported as not exist, got %v", err)
+	}
+}
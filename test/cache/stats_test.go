@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"errors"
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/nodes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupStats(t *testing.T) {
+	group := cache.NewGroup("stats", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "bad" {
+			return nil, cache.ErrNotFound
+		}
+		return make([]byte, 512), nil
+	}))
+	group.Get("a")
+	group.Get("a")
+	group.Get("bad")
+	// 容量只能容纳 3 个值，第 4 个值会淘汰最早的值
+	group.Get("b")
+	group.Get("c")
+	group.Get("d")
+	group.Set("ttl", []byte("value"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	group.Get("ttl") // 过期的值被删除后重新加载
+
+	stats := group.Stats()
+	expected := cache.Stats{
+		Gets: 7, Hits: 1, Loads: 6, LocalLoads: 5, LoadErrors: 1,
+		EvictedCapacity: stats.EvictedCapacity, EvictedExpired: 1,
+		Items: stats.Items, Bytes: stats.Bytes, MaxBytes: 2 << 10,
+	}
+	if stats != expected || stats.EvictedCapacity == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDedupedLoadStats(t *testing.T) {
+	release := make(chan struct{})
+	group := cache.NewGroup("stats-dedup", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group.Get("key")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if stats := group.Stats(); stats.LocalLoads != 1 || stats.Loads != 10 || stats.DedupedLoads != 9 {
+		t.Fatalf("concurrent loads should be deduplicated, %+v got", stats)
+	}
+}
+
+// 模拟一个总是返回错误的节点
+type failingNode struct{}
+
+func (failingNode) Get(in *pb.Request, out *pb.Response) error {
+	return errors.New("peer is down")
+}
+
+type onePicker struct {
+	node nodes.NodeGetter
+}
+
+func (p onePicker) PickNode(key string) (nodes.NodeGetter, bool) {
+	return p.node, true
+}
+
+func TestPeerStats(t *testing.T) {
+	getter := cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	ok := cache.NewGroup("stats-peer", 2<<10, getter)
+	ok.RegisterNodes(onePicker{node: staticNode{res: &pb.Response{Value: []byte("remote")}}})
+	if view, _ := ok.Get("key"); view.String() != "remote" {
+		t.Fatalf("value should be loaded from peer")
+	}
+	if stats := ok.Stats(); stats.PeerLoads != 1 || stats.PeerErrors != 0 || stats.LocalLoads != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	failing := cache.NewGroup("stats-peer-failing", 2<<10, getter)
+	failing.RegisterNodes(onePicker{node: failingNode{}})
+	if view, _ := failing.Get("key"); view.String() != "local" {
+		t.Fatalf("value should be loaded locally when peer fails")
+	}
+	if stats := failing.Stats(); stats.PeerLoads != 0 || stats.PeerErrors != 1 || stats.LocalLoads != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"jw-cache/src/cache"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openTier 打开二级缓存，测试结束时关闭
+func openTier(t *testing.T, dir string, opts *cache.DiskTierOptions) *cache.DiskTier {
+	t.Helper()
+	tier, err := cache.OpenDiskTier(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tier.Close() })
+	return tier
+}
+
+// segmentFiles 返回目录中段文件的数量
+func segmentFiles(t *testing.T, dir string) int {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "l2.*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func TestTierDemoteAndPromote(t *testing.T) {
+	const n = 200
+	tier := openTier(t, t.TempDir(), nil)
+	var loads int64
+	group := cache.NewGroupOpts("tier", 8<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt64(&loads, 1)
+		return nil, cache.ErrNotFound
+	}), &cache.GroupOptions{L2: tier})
+	value := strings.Repeat("v", 256)
+	for i := 0; i < n; i++ {
+		if err := group.SetLocal(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("%s-%d", value, i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := tier.Stats(); stats.Demoted == 0 || stats.Items+group.Stats().Items != n {
+		t.Fatalf("values evicted from memory should be demoted, got %+v", stats)
+	}
+
+	// 所有的值都可以读到，被淘汰的值从二级缓存取出后放回本地缓存
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		view, err := group.GetLocal(key)
+		if err != nil || view.String() != fmt.Sprintf("%s-%d", value, i) {
+			t.Fatalf("%s: unexpected value %q, %v", key, view.String(), err)
+		}
+	}
+	stats := group.Stats()
+	if loads != 0 || stats.L2Hits == 0 || stats.Hits != n || stats.L2Items+stats.Items != n {
+		t.Fatalf("values should be promoted from l2 without loading, got %+v with %d loads", stats, loads)
+	}
+	if tier.Stats().Hits != stats.L2Hits {
+		t.Fatalf("tier hits %d should equal group l2 hits %d", tier.Stats().Hits, stats.L2Hits)
+	}
+}
+
+// TestTierExport 快照和交接导出的数据包括二级缓存中的值
+func TestTierExport(t *testing.T) {
+	const n = 200
+	tier := openTier(t, t.TempDir(), nil)
+	group := cache.NewGroupOpts("tier-export", 8<<10, notFound, &cache.GroupOptions{L2: tier})
+	value := strings.Repeat("v", 256)
+	for i := 0; i < n; i++ {
+		group.SetLocal(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("%s-%d", value, i)), 0)
+	}
+	if tier.Stats().Items == 0 {
+		t.Fatalf("values should be demoted, got %+v", tier.Stats())
+	}
+	var buf bytes.Buffer
+	if stats, err := group.ExportEntries(&buf, nil); err != nil || stats.Entries != n {
+		t.Fatalf("all %d values should be exported, got %+v, %v", n, stats, err)
+	}
+	restored := cache.NewGroup("tier-export", 1<<20, notFound)
+	if stats, err := restored.ImportEntries(&buf); err != nil || stats.Entries != n {
+		t.Fatalf("all %d values should be imported, got %+v, %v", n, stats, err)
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if view, err := restored.GetLocal(key); err != nil || view.String() != fmt.Sprintf("%s-%d", value, i) {
+			t.Fatalf("%s: unexpected value %q, %v", key, view.String(), err)
+		}
+	}
+	if stats := tier.Stats(); stats.Hits != 0 || stats.Items+group.Stats().Items != n {
+		t.Fatalf("exporting should not move values out of l2, got %+v", stats)
+	}
+}
+
+func TestTierInvalidate(t *testing.T) {
+	tier := openTier(t, t.TempDir(), nil)
+	group := cache.NewGroupOpts("tier-invalidate", 4<<10, notFound, &cache.GroupOptions{L2: tier})
+	value := []byte(strings.Repeat("v", 512))
+	group.SetLocal("deleted", value, 0)
+	group.SetLocal("expired", value, 20*time.Millisecond)
+	group.SetLocal("updated", value, 0)
+	for i := 0; i < 20; i++ {
+		group.SetLocal(fmt.Sprintf("fill%d", i), value, 0)
+	}
+	if tier.Stats().Items < 3 {
+		t.Fatalf("values should be demoted, got %+v", tier.Stats())
+	}
+
+	// 删除和覆盖本地缓存时同时删除二级缓存中的旧值
+	group.Delete("deleted")
+	group.SetLocal("updated", []byte("new"), 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	for _, key := range []string{"deleted", "expired", "updated"} {
+		if view, err := group.GetLocal(key); err == nil {
+			t.Fatalf("%s should not be read from l2, got %q", key, view.String())
+		}
+	}
+}
+
+func TestTierCollect(t *testing.T) {
+	dir := t.TempDir()
+	tier := openTier(t, dir, &cache.DiskTierOptions{MaxBytes: 64 << 10, SegmentSize: 4 << 10})
+	value := strings.Repeat("v", 200)
+	// 反复覆盖同一批键，旧的段中只剩下无效的记录
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 20; i++ {
+			if err := tier.Put(fmt.Sprintf("key%d", i), viewOf(fmt.Sprintf("%s-%d-%d", value, round, i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tier.Collect()
+	stats := tier.Stats()
+	if stats.Items != 20 || stats.Bytes > 2*stats.LiveBytes+4<<10 || stats.Segments != segmentFiles(t, dir) {
+		t.Fatalf("dead segments should be reclaimed, got %+v", stats)
+	}
+	// 被压缩的段中有效的值重新写入后仍然可以读到
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if view, ok := tier.Take(key); !ok || view.String() != fmt.Sprintf("%s-9-%d", value, i) {
+			t.Fatalf("%s: unexpected value %q, %v", key, view.String(), ok)
+		}
+	}
+
+	// 超过容量时淘汰最早写入的值
+	for i := 0; i < 1000; i++ {
+		tier.Put(fmt.Sprintf("many%d", i), viewOf(value))
+	}
+	tier.Collect()
+	stats = tier.Stats()
+	if stats.Bytes > stats.MaxBytes || stats.Dropped == 0 || stats.Segments != segmentFiles(t, dir) {
+		t.Fatalf("tier should be capped at %d bytes, got %+v", stats.MaxBytes, stats)
+	}
+	if _, ok := tier.Take("many0"); ok {
+		t.Fatalf("the oldest value should be dropped")
+	}
+	if view, ok := tier.Take("many999"); !ok || view.String() != value {
+		t.Fatalf("the newest value should be kept, got %q, %v", view.String(), ok)
+	}
+}
+
+func TestTierOpenAndClose(t *testing.T) {
+	dir := t.TempDir()
+	// 上次运行留下的段文件在打开时删除，其他文件保留
+	for _, name := range []string{"l2.00000007.seg", "other.seg", "jwcache.1.aof"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("stale"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tier := openTier(t, dir, nil)
+	if _, err := os.Stat(filepath.Join(dir, "l2.00000007.seg")); !os.IsNotExist(err) {
+		t.Fatalf("stale segment should be removed, got %v", err)
+	}
+	tier.Put("key", viewOf("value"))
+	if err := tier.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("segments should be removed on close, got %d files", len(entries))
+	}
+	if err := tier.Put("key", viewOf("value")); err == nil {
+		t.Fatalf("writing to a closed tier should fail")
+	}
+	if _, ok := tier.Take("key"); ok {
+		t.Fatalf("reading from a closed tier should miss")
+	}
+	if _, err := cache.OpenDiskTier(dir, &cache.DiskTierOptions{GCRatio: 1}); err == nil {
+		t.Fatalf("gc ratio 1 should be rejected")
+	}
+}
+
+// TestTierConcurrent 并发读取时值不断在本地缓存和二级缓存之间移动，同时后台回收段文件，读到的值应该总是正确的
+func TestTierConcurrent(t *testing.T) {
+	tier := openTier(t, t.TempDir(), &cache.DiskTierOptions{MaxBytes: 1 << 20, SegmentSize: 4 << 10})
+	padding := strings.Repeat("v", 100)
+	group := cache.NewGroupOpts("tier-concurrent", 16<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(padding + key), nil
+	}), &cache.GroupOptions{L2: tier})
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("key%d", (i*7+w*13)%500)
+				view, err := group.GetLocal(key)
+				if err != nil || view.String() != padding+key {
+					errs <- fmt.Errorf("%s: unexpected value %q, %v", key, view.String(), err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if stats := group.Stats(); stats.L2Hits == 0 {
+		t.Fatalf("some reads should be served from l2, got %+v", stats)
+	}
+}
+
+func TestTierImportKeepsNewer(t *testing.T) {
+	const n = 200
+	tier := openTier(t, t.TempDir(), nil)
+	group := cache.NewGroupOpts("tier-import", 8<<10, notFound, &cache.GroupOptions{L2: tier})
+	value := strings.Repeat("v", 256)
+	for i := 0; i < n; i++ {
+		group.SetLocal(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("%s-%d", value, i)), 0)
+	}
+	if tier.Stats().Items == 0 {
+		t.Fatalf("values should be demoted, got %+v", tier.Stats())
+	}
+	old := cache.NewGroup("tier-import", 1<<20, notFound)
+	for i := 0; i < n; i++ {
+		old.SetLocal(fmt.Sprintf("key%d", i), []byte("stale"), 0)
+	}
+	var buf bytes.Buffer
+	if _, err := old.ExportEntries(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 二级缓存中的值同样比交接的值更新
+	if stats, err := group.ImportEntries(&buf); err != nil || stats.Entries != 0 || stats.Skipped != n {
+		t.Fatalf("no values should be imported, got %+v, %v", stats, err)
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if view, err := group.GetLocal(key); err != nil || view.String() != fmt.Sprintf("%s-%d", value, i) {
+			t.Fatalf("%s: unexpected value %q, %v", key, view.String(), err)
+		}
+	}
+}
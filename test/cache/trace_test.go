@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/trace"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spanRecorder 记录导出的 span
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (r *spanRecorder) export(data trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, data)
+}
+
+// names 返回按照结束顺序排列的 span 名称
+func (r *spanRecorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.spans))
+	for i, s := range r.spans {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func TestGroupTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	trace.SetTracer(trace.NewW3CTracer(recorder.export))
+	defer trace.SetTracer(nil)
+
+	getter := cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	g := cache.NewGroup("traced", 2<<10, getter)
+	g.RegisterNodes(onePicker{node: failingNode{}})
+
+	ctx, root := trace.Start(context.Background(), "request")
+	if view, err := g.GetContext(ctx, "key"); err != nil || view.String() != "local" {
+		t.Fatalf("value should be loaded locally when peer fails")
+	}
+	root.End()
+
+	want := []string{"jwcache.cache.lookup", "jwcache.peer.get", "jwcache.getter.load", "jwcache.singleflight", "jwcache.Group.Get", "request"}
+	got := recorder.names()
+	if len(got) != len(want) {
+		t.Fatalf("unexpected spans %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected spans %v", got)
+		}
+	}
+	spans := recorder.spans
+	traceID := spans[len(spans)-1].Context.TraceID
+	for _, s := range spans {
+		if s.Context.TraceID != traceID {
+			t.Fatalf("%s should belong to the request trace", s.Name)
+		}
+	}
+	if spans[1].Err == nil {
+		t.Fatalf("peer span should record the peer error")
+	}
+	if get := spans[4]; len(get.Attributes) != 2 || get.Attributes[0] != trace.String("group", "traced") {
+		t.Fatalf("unexpected attributes %v", get.Attributes)
+	}
+
+	recorder.spans = nil
+	g.GetContext(ctx, "key")
+	if got := recorder.names(); len(got) != 2 || got[0] != "jwcache.cache.lookup" {
+		t.Fatalf("hits should only trace the lookup, got %v", got)
+	}
+}
+
+// blockingNode 等待 release 关闭后返回值，ctx 被取消时返回 ctx.Err()
+type blockingNode struct {
+	release chan struct{}
+	traced  chan bool // traced 收到的 ctx 中是否带有追踪上下文
+}
+
+func (n blockingNode) Get(in *pb.Request, out *pb.Response) error {
+	return n.GetContext(context.Background(), in, out)
+}
+
+func (n blockingNode) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	_, ok := trace.SpanContextFromContext(ctx)
+	n.traced <- ok
+	select {
+	case <-n.release:
+		out.Value = []byte("remote")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TestSharedLoadIgnoresCancel 第一个调用方取消后，共享的加载不会被中断，其他调用方仍然拿到其他节点的值
+func TestSharedLoadIgnoresCancel(t *testing.T) {
+	trace.SetTracer(trace.NewW3CTracer(nil))
+	defer trace.SetTracer(nil)
+
+	node := blockingNode{release: make(chan struct{}), traced: make(chan bool, 1)}
+	g := cache.NewGroup("cancel-shared", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	g.RegisterNodes(onePicker{node: node})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(ctx, "key")
+		first <- err
+	}()
+	if traced := <-node.traced; !traced {
+		t.Fatalf("the shared load should keep the trace context")
+	}
+	second := make(chan cache.ByteView, 1)
+	go func() {
+		view, _ := g.Get("key")
+		second <- view
+	}()
+	for g.Stats().Loads < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 第一个调用方取消后立即返回，不需要等待加载完成
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("canceled caller should get context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("canceled caller should not wait for the shared load")
+	}
+
+	close(node.release)
+	if view := <-second; view.String() != "remote" {
+		t.Fatalf("other callers should get the peer value, got %q", view.String())
+	}
+	if stats := g.Stats(); stats.PeerLoads != 1 || stats.PeerErrors != 0 || stats.LocalLoads != 0 {
+		t.Fatalf("the peer load should not be canceled, got %+v", stats)
+	}
+}
+
+func BenchmarkNoopTracingHit(b *testing.B) {
+	g := cache.NewGroup("noop-traced", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}))
+	g.Get("key")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		g.Get("key")
+	}
+}
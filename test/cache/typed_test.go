@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"encoding/json"
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"strings"
+	"testing"
+)
+
+type user struct {
+	Name  string
+	Score int
+}
+
+func TestTypedGroup(t *testing.T) {
+	codecs := map[string]cache.Codec[user]{
+		"typed-json":   cache.JSONCodec[user](),
+		"typed-gob":    cache.GobCodec[user](),
+		"typed-custom": cache.NewCodec[user](json.Marshal, json.Unmarshal),
+	}
+	for name, codec := range codecs {
+		loads := 0
+		group := cache.NewTypedGroup[user](name, 2<<10, codec, cache.TypedGetterFunc[user](func(key string) (user, error) {
+			loads++
+			return user{Name: key, Score: len(key)}, nil
+		}))
+		for i := 0; i < 2; i++ {
+			if u, err := group.Get("Tom"); err != nil || u.Name != "Tom" || u.Score != 3 {
+				t.Fatalf("%s: get Tom: %v %v", name, u, err)
+			}
+		}
+		if loads != 1 {
+			t.Fatalf("%s: value should be cached, but loaded %d times", name, loads)
+		}
+		if err := group.Set("Jack", user{Name: "Jack", Score: 100}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := group.Get("Jack"); err != nil || u.Score != 100 {
+			t.Fatalf("%s: get Jack: %v %v", name, u, err)
+		}
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	group := cache.NewTypedGroup[*pb.Request]("typed-proto", 2<<10, cache.ProtoCodec[*pb.Request]{},
+		cache.TypedGetterFunc[*pb.Request](func(key string) (*pb.Request, error) {
+			return &pb.Request{Group: "typed-proto", Key: key}, nil
+		}))
+	if req, err := group.Get("Tom"); err != nil || req.Key != "Tom" || req.Group != "typed-proto" {
+		t.Fatalf("get Tom: %v %v", req, err)
+	}
+	// 缓存中保存的是编码后的字节
+	if view, err := group.Group().Get("Tom"); err != nil || view.Len() == 0 {
+		t.Fatalf("encoded bytes should be cached: %v", err)
+	}
+}
+
+func TestTypedGroupCompressed(t *testing.T) {
+	name := strings.Repeat("Tom", 500) // 编码后超过默认的 1KB 压缩阈值
+	group := cache.NewTypedGroupOpts[user]("typed-gzip", 64<<10, cache.JSONCodec[user](),
+		cache.TypedGetterFunc[user](func(key string) (user, error) {
+			return user{Name: key, Score: len(key)}, nil
+		}), &cache.GroupOptions{Compression: "gzip"})
+	for i := 0; i < 2; i++ {
+		if u, err := group.Get(name); err != nil || u.Name != name || u.Score != len(name) {
+			t.Fatalf("get compressed value: %v %v", u.Score, err)
+		}
+	}
+	if err := group.Set("Jack", user{Name: name, Score: 100}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := group.Get("Jack"); err != nil || u.Name != name || u.Score != 100 {
+		t.Fatalf("get compressed Jack: %v %v", u.Score, err)
+	}
+	// 缓存中保存的是压缩后的字节
+	if view, err := group.Group().Get(name); err != nil || view.Len() >= len(name) {
+		t.Fatalf("value should be compressed, got %d bytes, %v", view.Len(), err)
+	}
+}
@@ -0,0 +1,132 @@
+package getters
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"jw-cache/src/cache"
+	"jw-cache/src/getters"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// 一个只支持单参数查询的数据库驱动，用 map 模拟数据表
+type fakeDriver struct {
+	rows map[string]string
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+type fakeStmt struct{ d *fakeDriver }
+
+type fakeRows struct {
+	values []string
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error)   { return &fakeConn{d}, nil }
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c.d}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+func (s *fakeStmt) Close() error                              { return nil }
+func (s *fakeStmt) NumInput() int                             { return 1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &fakeRows{}
+	if v, ok := s.d.rows[args[0].(string)]; ok {
+		rows.values = append(rows.values, v)
+	}
+	return rows, nil
+}
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = []byte(r.values[0]), r.values[1:]
+	return nil
+}
+
+func TestSQLGetter(t *testing.T) {
+	sql.Register("fake", &fakeDriver{rows: map[string]string{"Tom": "630"}})
+	db, err := sql.Open("fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	getter := getters.NewSQLGetter(db, "SELECT score FROM scores WHERE name = ?")
+	if v, err := getter.Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("get Tom: %s %v", v, err)
+	}
+	if _, err := getter.Get("Jack"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("unknown key should return ErrNotFound, but %v got", err)
+	}
+}
+
+func TestFileGetter(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "b.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "secret"), []byte("secret"), 0644)
+
+	getter := getters.NewFileGetter(dir)
+	if v, err := getter.Get("a/b.txt"); err != nil || string(v) != "hello" {
+		t.Fatalf("get a/b.txt: %s %v", v, err)
+	}
+	for _, key := range []string{"missing", "a"} {
+		if _, err := getter.Get(key); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("%s should return ErrNotFound, but %v got", key, err)
+		}
+	}
+	// 目录中的符号链接只能指向目录之中的文件
+	os.Symlink(filepath.Join(dir, "a", "b.txt"), filepath.Join(dir, "inside"))
+	os.Symlink(filepath.Join(filepath.Dir(dir), "secret"), filepath.Join(dir, "escape"))
+	os.Symlink(filepath.Dir(dir), filepath.Join(dir, "a", "parent"))
+	if v, err := getter.Get("inside"); err != nil || string(v) != "hello" {
+		t.Fatalf("get inside: %s %v", v, err)
+	}
+	for _, key := range []string{"../secret", "a/../../secret", "/etc/passwd", "escape", "a/parent/secret"} {
+		if v, err := getter.Get(key); err == nil || errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("%s should be rejected, but %s got", key, v)
+		}
+	}
+}
+
+func TestHTTPGetter(t *testing.T) {
+	var full, notModified int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/objects/a b/c" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Write([]byte("object"))
+	}))
+	defer origin.Close()
+
+	getter := getters.NewHTTPGetter(origin.URL + "/objects/")
+	for i := 0; i < 2; i++ {
+		if v, err := getter.Get("a b/c"); err != nil || string(v) != "object" {
+			t.Fatalf("get a b/c: %s %v", v, err)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Fatalf("second request should be conditional, full %d, not modified %d", full, notModified)
+	}
+	if _, err := getter.Get("missing"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("missing object should return ErrNotFound, but %v got", err)
+	}
+}
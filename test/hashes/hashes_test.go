@@ -37,3 +37,68 @@ func TestHashes(t *testing.T) {
 	testCases["27"] = "8"
 	startTest()
 }
+
+func TestHashesWithFilter(t *testing.T) {
+	hash := hashes.New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 2 4 6 12 14 16 22 24 26
+	hash.Add("6", "4", "2")
+
+	// 节点 4 不可用时，原本属于它的 key 由哈希环上的下一个节点接管
+	accept := func(node string) bool { return node != "4" }
+	testCases := map[string]string{
+		"2":  "2",
+		"3":  "6",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if node := hash.GetWithFilter(k, accept); node != v {
+			t.Errorf("该 %s 对应的value值应当是 %s, 实际是 %s", k, v, node)
+		}
+	}
+	if node := hash.GetWithFilter("3", func(string) bool { return false }); node != "" {
+		t.Errorf("所有节点都不可用时应当返回空字符串, 实际是 %s", node)
+	}
+}
+
+func TestMoved(t *testing.T) {
+	ring := func(nodes ...string) *hashes.Map {
+		m := hashes.New(50, nil)
+		m.Add(nodes...)
+		return m
+	}
+	old, new := ring("a", "b", "c"), ring("a", "b", "c", "d")
+	moved := hashes.Moved(old, new)
+	if len(moved) == 0 {
+		t.Fatalf("adding a node should move some ranges")
+	}
+	for i, r := range moved {
+		if r.Start > r.End || (i > 0 && moved[i-1].End >= r.Start) {
+			t.Fatalf("ranges should be sorted and disjoint: %+v", moved)
+		}
+		if r.To != "d" || r.From == "d" {
+			t.Fatalf("only ranges moved to the new node expected, got %+v", r)
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		from, to := old.Get(key), new.Get(key)
+		hash := new.Hash(key)
+		if moved.Contains(hash) != (from != to) {
+			t.Fatalf("%s moved from %s to %s, but ranges contain it: %v", key, from, to, moved.Contains(hash))
+		}
+	}
+
+	// 移除节点时，该节点的所有区间都会移动到其他节点
+	for _, r := range hashes.Moved(new, old) {
+		if r.From != "d" || r.To == "d" {
+			t.Fatalf("only ranges of the removed node expected, got %+v", r)
+		}
+	}
+	if hashes.Moved(ring(), new) != nil || len(hashes.Moved(new, ring("d", "c", "b", "a"))) != 0 {
+		t.Fatalf("empty or identical rings should move nothing")
+	}
+}
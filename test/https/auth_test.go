@@ -0,0 +1,176 @@
+package https
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/https"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 生成一个由 parent 签发的证书，parent 为空时生成自签名的CA证书，返回证书和私钥的文件路径
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (string, string, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile, cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	newEchoGroup("mtls")
+	dir := t.TempDir()
+	caFile, _, ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	serverCert, serverKey, _, _ := writeCert(t, dir, "server", ca, caKey)
+	clientCert, clientKey, _, _ := writeCert(t, dir, "client", ca, caKey)
+
+	serverConfig, err := https.NewServerTLSConfig(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pool *https.ConnectHTTPPool
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pool.ServeHTTP(w, r)
+	}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+	pool = https.NewHTTPPool(server.URL)
+
+	get := func(certFile, keyFile string) error {
+		clientConfig, err := https.NewClientTLSConfig(certFile, keyFile, caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		self := "https://localhost:1"
+		client := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{TLSConfig: clientConfig})
+		client.Set(self, server.URL)
+		key := keyOwnedBy(t, server.URL, self, server.URL)
+		node, _ := client.PickNode(key)
+		return node.Get(&pb.Request{Group: "mtls", Key: key}, &pb.Response{})
+	}
+	if err := get(clientCert, clientKey); err != nil {
+		t.Fatalf("client with certificate should be accepted: %v", err)
+	}
+	if err := get("", ""); err == nil {
+		t.Fatalf("client without certificate should be rejected")
+	}
+
+	// 使用客户端证书的交接请求不需要共享密钥
+	clientConfig, err := https.NewClientTLSConfig(clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	res, err := client.Post(server.URL+"/_jw_cache/_handoff/mtls", "text/plain", strings.NewReader("0 4294967295\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("handoff over mutual TLS should be accepted, got %d", res.StatusCode)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	newEchoGroup("hmac")
+	var pool *https.ConnectHTTPPool
+	var captured *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured = r
+		pool.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	pool = https.NewHTTPPoolOpts(server.URL, &https.HTTPPoolOptions{SharedSecret: []byte("secret")})
+
+	get := func(secret string) error {
+		self := "http://localhost:1"
+		client := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{SharedSecret: []byte(secret)})
+		client.Set(self, server.URL)
+		key := keyOwnedBy(t, server.URL, self, server.URL)
+		node, _ := client.PickNode(key)
+		return node.Get(&pb.Request{Group: "hmac", Key: key}, &pb.Response{})
+	}
+	if err := get("secret"); err != nil {
+		t.Fatalf("request with the shared secret should be accepted: %v", err)
+	}
+	if err := get("wrong"); err == nil {
+		t.Fatalf("request with a wrong secret should be rejected")
+	}
+
+	// 重放一个已经成功的请求
+	if err := get("secret"); err != nil {
+		t.Fatal(err)
+	}
+	replay, _ := http.NewRequest(http.MethodGet, server.URL+captured.URL.Path, nil)
+	replay.Header = captured.Header.Clone()
+	if res, err := http.DefaultClient.Do(replay); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed request should be rejected")
+	}
+
+	// 修改查询参数或者对冲请求头后签名不再有效
+	for _, tamper := range []func(r *http.Request){
+		func(r *http.Request) { r.URL.RawQuery = "from=http://evil" },
+		func(r *http.Request) { r.Header.Set("X-JWCache-Hedged", "1") },
+	} {
+		r, _ := http.NewRequest(http.MethodGet, server.URL+captured.URL.Path, nil)
+		if err := https.SignRequest(r, nil, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		tamper(r)
+		if res, err := http.DefaultClient.Do(r); err != nil || res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("tampered request should be rejected")
+		}
+	}
+	signed, _ := http.NewRequest(http.MethodGet, server.URL+captured.URL.Path+"?from=x", nil)
+	signed.Header.Set("X-JWCache-Hedged", "1")
+	if err := https.SignRequest(signed, nil, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := http.DefaultClient.Do(signed); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("signed request with query and hedged header should be accepted")
+	}
+
+	// 修改时间戳后签名不再有效
+	replay.Header.Set("X-JWCache-Timestamp", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	replay.Header.Set("X-JWCache-Nonce", "another")
+	if res, err := http.DefaultClient.Do(replay); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request with a stale timestamp should be rejected")
+	}
+}
@@ -0,0 +1,143 @@
+package https
+
+import (
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/https"
+	"jw-cache/src/nodes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 开启一个节点，handler 在交给连接池处理之前调用，返回 false 时不再交给连接池处理
+func startNode(handler func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	var pool *https.ConnectHTTPPool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler(w, r) {
+			pool.ServeHTTP(w, r)
+		}
+	}))
+	pool = https.NewHTTPPool(server.URL)
+	return server
+}
+
+func newEchoGroup(name string) *cache.Group {
+	return cache.NewGroup(name, 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value-" + key), nil
+	}))
+}
+
+func TestRetry(t *testing.T) {
+	newEchoGroup("retry")
+	var calls int32
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	})
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{Retries: 2, RetryBackoff: time.Millisecond})
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, ok := pool.PickNode(key)
+	if !ok {
+		t.Fatalf("key %s should be picked to %s", key, server.URL)
+	}
+	res := &pb.Response{}
+	if err := node.Get(&pb.Request{Group: "retry", Key: key}, res); err != nil {
+		t.Fatalf("get should succeed after retries: %v", err)
+	}
+	if string(res.Value) != "value-"+key || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("unexpected value %q after %d calls", res.Value, calls)
+	}
+}
+
+func TestNoRetryWrites(t *testing.T) {
+	newEchoGroup("retry-write")
+	var calls int32
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return false
+	})
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{Retries: 2, RetryBackoff: time.Millisecond})
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := pool.PickNode(key)
+	writer := node.(nodes.NodeWriter)
+	// 写入和删除可能已经生效，不能重试
+	if err := writer.Set("retry-write", key, []byte("v"), 0); err == nil {
+		t.Fatalf("set should fail")
+	}
+	if err := writer.Delete("retry-write", key); err == nil {
+		t.Fatalf("delete should fail")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("writes should not be retried, but %d calls got", n)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool {
+		time.Sleep(200 * time.Millisecond)
+		return false
+	})
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{Timeout: 20 * time.Millisecond})
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := pool.PickNode(key)
+	start := time.Now()
+	if err := node.Get(&pb.Request{Group: "timeout", Key: key}, &pb.Response{}); err == nil {
+		t.Fatalf("get should time out")
+	}
+	if time.Since(start) > 150*time.Millisecond {
+		t.Fatalf("get should return after timeout, took %v", time.Since(start))
+	}
+}
+
+func TestHedgedRequest(t *testing.T) {
+	newEchoGroup("hedge")
+	slow := startNode(func(w http.ResponseWriter, r *http.Request) bool {
+		time.Sleep(500 * time.Millisecond)
+		return true
+	})
+	defer slow.Close()
+	var hedged int32
+	fast := startNode(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("X-JWCache-Hedged") != "" {
+			atomic.AddInt32(&hedged, 1)
+		}
+		return true
+	})
+	defer fast.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{HedgeDelay: 20 * time.Millisecond})
+	pool.Set(self, slow.URL, fast.URL)
+	key := keyOwnedBy(t, slow.URL, self, slow.URL, fast.URL)
+	node, _ := pool.PickNode(key)
+	start := time.Now()
+	res := &pb.Response{}
+	if err := node.Get(&pb.Request{Group: "hedge", Key: key}, res); err != nil {
+		t.Fatalf("hedged get failed: %v", err)
+	}
+	if time.Since(start) > 300*time.Millisecond {
+		t.Fatalf("hedged request should return before the slow node, took %v", time.Since(start))
+	}
+	if string(res.Value) != "value-"+key || atomic.LoadInt32(&hedged) != 1 {
+		t.Fatalf("unexpected value %q, hedged requests %d", res.Value, hedged)
+	}
+}
@@ -0,0 +1,279 @@
+package https
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jw-cache/src/cache"
+	"jw-cache/src/hashes"
+	"jw-cache/src/https"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var notFound = cache.GetterFunc(func(key string) ([]byte, error) {
+	return nil, cache.ErrNotFound
+})
+
+// fillGroup 新建分组并写入 n 个值
+func fillGroup(t *testing.T, name string, n int) *cache.Group {
+	g := cache.NewGroup(name, 1<<20, notFound)
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := g.SetLocal(key, []byte("value-"+key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+// parseRanges 解析交接请求中的区间列表
+func parseRanges(t *testing.T, r io.Reader) hashes.Ranges {
+	var ranges hashes.Ranges
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var rg hashes.Range
+		if _, err := fmt.Sscan(s.Text(), &rg.Start, &rg.End); err != nil {
+			t.Errorf("bad range %q: %v", s.Text(), err)
+		}
+		ranges = append(ranges, rg)
+	}
+	return ranges
+}
+
+// signedRequest 新建使用 secret 签名的请求
+func signedRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if err := https.SignRequest(r, []byte(body), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// checkOwned 检查分组中只有在 ring 上属于 node 的值，返回值的数量
+func checkOwned(t *testing.T, g *cache.Group, n int, node string, ring *hashes.Map) int {
+	t.Helper()
+	owned := 0
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		view, err := g.GetLocal(key)
+		if ring.Get(key) != node {
+			if err == nil {
+				t.Fatalf("%s belongs to %s, should not be handed off to %s", key, ring.Get(key), node)
+			}
+			continue
+		}
+		if err != nil || view.String() != "value-"+key {
+			t.Fatalf("%s should be handed off to %s, got %q, %v", key, node, view.String(), err)
+		}
+		owned++
+	}
+	return owned
+}
+
+func TestServeHandoff(t *testing.T) {
+	const n = 2000
+	fillGroup(t, "handoff-serve", n)
+	pool := https.NewHTTPPoolOpts("http://localhost:1", &https.HTTPPoolOptions{HandoffRate: 20 << 10, SharedSecret: []byte("secret")})
+	pool.Set("http://localhost:1", "http://localhost:2")
+	// 请求哈希环的前半部分
+	start := time.Now()
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, signedRequest(t, http.MethodPost, "/_jw_cache/_handoff/handoff-serve", "0 2147483647\n"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	// 令牌桶最初有一秒的令牌，超过的部分需要等待
+	if size := w.Body.Len(); size < 30<<10 || time.Since(start) < time.Duration(float64(size-20<<10)/(20<<10)*float64(time.Second))*9/10 {
+		t.Fatalf("handoff of %d bytes should be rate limited, took %v", size, time.Since(start))
+	}
+
+	// 写入新的同名分组，相当于另一个节点
+	restored := cache.NewGroup("handoff-serve", 1<<20, notFound)
+	stats, err := restored.ImportEntries(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := hashes.New(replicas, nil)
+	for i := 0; i < n; i++ {
+		key := "key" + strconv.Itoa(i)
+		_, err := restored.GetLocal(key)
+		if (ring.Hash(key) <= 1<<31-1) != (err == nil) {
+			t.Fatalf("%s with hash %d: unexpected result %v", key, ring.Hash(key), err)
+		}
+	}
+	if stats.Entries == 0 || stats.Entries == n {
+		t.Fatalf("only part of the entries should be handed off, got %+v", stats)
+	}
+
+	testCases := map[string]int{
+		http.MethodGet + " /_jw_cache/_handoff/handoff-serve":                    http.StatusMethodNotAllowed,
+		http.MethodPost + " /_jw_cache/_handoff/unknown":                         http.StatusNotFound,
+		http.MethodPost + " /_jw_cache/_handoff?from=localhost":                  http.StatusBadRequest,
+		http.MethodPost + " /_jw_cache/_handoff?from=http%3A%2F%2Fattacker%3A80": http.StatusBadRequest,
+		http.MethodPost + " /_jw_cache/_handoff?from=http%3A%2F%2Flocalhost%3A1": http.StatusBadRequest,
+	}
+	for req, code := range testCases {
+		method, path, _ := strings.Cut(req, " ")
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, signedRequest(t, method, path, "1 2\n"))
+		if w.Code != code {
+			t.Errorf("%s should return %d, but %d got", req, code, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	pool.ServeHTTP(w, signedRequest(t, http.MethodPost, "/_jw_cache/_handoff/handoff-serve", "5 9\n1 2\n"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unsorted ranges should be rejected, got %d", w.Code)
+	}
+}
+
+func TestHandoffRequiresAuth(t *testing.T) {
+	fillGroup(t, "handoff-auth", 10)
+	// 没有配置共享密钥和双向TLS时拒绝所有的交接请求
+	pool := https.NewHTTPPool("http://localhost:1")
+	pool.Set("http://localhost:1", "http://localhost:2")
+	for _, path := range []string{"/_jw_cache/_handoff/handoff-auth", "/_jw_cache/_handoff?from=http%3A%2F%2Flocalhost%3A2"} {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader("0 4294967295\n")))
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s without auth should be forbidden, got %d", path, w.Code)
+		}
+	}
+	// 配置了共享密钥时没有签名的请求同样被拒绝
+	secured := https.NewHTTPPoolOpts("http://localhost:1", &https.HTTPPoolOptions{SharedSecret: []byte("secret")})
+	w := httptest.NewRecorder()
+	secured.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/_jw_cache/_handoff/handoff-auth", strings.NewReader("0 4294967295\n")))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned handoff should be rejected, got %d", w.Code)
+	}
+}
+
+func TestHandoffOnJoin(t *testing.T) {
+	const n = 500
+	// 原来的节点只有 old，它的值保存在 src 中
+	src := fillGroup(t, "handoff-join", n)
+	var requests int
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/_jw_cache/_handoff/handoff-join" {
+			// 其他测试创建的分组在原来的节点上不存在
+			http.Error(w, "no such group", http.StatusNotFound)
+			return
+		}
+		requests++
+		ranges := parseRanges(t, r.Body)
+		ring := hashes.New(replicas, nil)
+		src.ExportEntries(w, func(key string) bool { return ranges.Contains(ring.Hash(key)) })
+	}))
+	defer old.Close()
+
+	self := "http://localhost:1"
+	dst := cache.NewGroup("handoff-join", 1<<20, notFound)
+	pool := https.NewHTTPPool(self)
+	stats, err := pool.Handoff(context.Background(), old.URL, self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := hashes.New(replicas, nil)
+	ring.Add(old.URL, self)
+	owned := checkOwned(t, dst, n, self, ring)
+	if requests != 1 || stats.Entries != owned || owned == 0 || stats.Bytes == 0 || stats.Failed != 0 {
+		t.Fatalf("unexpected handoff %+v with %d requests, %d keys owned", stats, requests, owned)
+	}
+	if peers := pool.Peers(); len(peers) != 2 {
+		t.Fatalf("ring should be switched after handoff, got %v", peers)
+	}
+	if _, ok := pool.PickNode(keyOwnedBy(t, old.URL, old.URL, self)); !ok {
+		t.Fatalf("keys owned by the old node should be picked from it")
+	}
+
+	// 其他节点加入时由该节点负责交接
+	requests = 0
+	if stats, err := pool.Handoff(context.Background(), old.URL, self, "http://localhost:2"); err != nil || stats.Ranges != 0 || requests != 0 {
+		t.Fatalf("other nodes joining should only switch the ring, got %+v, %v", stats, err)
+	}
+}
+
+func TestHandoffOnLeave(t *testing.T) {
+	const n = 500
+	// 离开的节点使用真实的连接池，需要先启动服务才能得到节点的地址
+	fillGroup(t, "handoff-leave", n)
+	leaving := httptest.NewUnstartedServer(nil)
+	leaving.Start()
+	defer leaving.Close()
+	pool := https.NewHTTPPoolOpts(leaving.URL, &https.HTTPPoolOptions{SharedSecret: []byte("secret")})
+	leaving.Config.Handler = pool
+
+	// 新的节点收到通知后从离开的节点拉取，读取完整的响应之后再写入新的同名分组
+	var received *cache.Group
+	var receivedBytes int
+	next := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := r.URL.Query().Get("from")
+		if r.URL.Path != "/_jw_cache/_handoff" || from != leaving.URL {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		ranges, _ := io.ReadAll(r.Body)
+		req, _ := http.NewRequest(http.MethodPost, from+"/_jw_cache/_handoff/handoff-leave", bytes.NewReader(ranges))
+		if err := https.SignRequest(req, ranges, []byte("secret")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		receivedBytes = len(body)
+		received = cache.NewGroup("handoff-leave", 1<<20, notFound)
+		stats, err := received.ImportEntries(bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"entries": stats.Entries, "bytes": len(body)})
+	}))
+	defer next.Close()
+
+	pool.Set(leaving.URL, next.URL)
+	stats, err := pool.Leave(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received == nil {
+		t.Fatalf("the next node should be notified")
+	}
+	ring := hashes.New(replicas, nil)
+	ring.Add(leaving.URL, next.URL)
+	owned := checkOwned(t, received, n, leaving.URL, ring)
+	if stats.Entries != owned || stats.Bytes != int64(receivedBytes) || stats.Failed != 0 {
+		t.Fatalf("unexpected handoff %+v, %d keys owned by the leaving node", stats, owned)
+	}
+	if peers := pool.Peers(); len(peers) != 1 || peers[0] != next.URL {
+		t.Fatalf("leaving node should be removed from the ring, got %v", peers)
+	}
+}
+
+func TestHandoffFailure(t *testing.T) {
+	self := "http://localhost:1"
+	cache.NewGroup("handoff-failure", 1<<20, notFound)
+	pool := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{HandoffTimeout: time.Second})
+	// 原来的节点不可用时交接失败，但仍然切换哈希环
+	stats, err := pool.Handoff(context.Background(), "http://localhost:2", self)
+	if err == nil || stats.Failed != 1 {
+		t.Fatalf("handoff from an unavailable node should fail, got %+v, %v", stats, err)
+	}
+	if peers := pool.Peers(); len(peers) != 2 {
+		t.Fatalf("ring should be switched even if handoff failed, got %v", peers)
+	}
+}
@@ -0,0 +1,117 @@
+package https
+
+import (
+	"bytes"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/hashes"
+	"jw-cache/src/https"
+	"jw-cache/src/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 与 https 包中默认的虚拟节点数保持一致
+const replicas = 50
+
+// 开启一个可以手动切换状态的节点，宕机时返回 503
+func startFlakyNode(down *int32) *httptest.Server {
+	var pool *https.ConnectHTTPPool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		pool.ServeHTTP(w, r)
+	}))
+	pool = https.NewHTTPPool(server.URL)
+	return server
+}
+
+// 找到一个属于 node 节点的 key
+func keyOwnedBy(t *testing.T, node string, nodes ...string) string {
+	ring := hashes.New(replicas, nil)
+	ring.Add(nodes...)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if ring.Get(key) == node {
+			return key
+		}
+	}
+	t.Fatalf("no key owned by %s", node)
+	return ""
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var down int32
+	server := startFlakyNode(&down)
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPool(self)
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+
+	node, ok := pool.PickNode(key)
+	if !ok {
+		t.Fatalf("key %s should be picked to %s", key, server.URL)
+	}
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 3; i++ {
+		if err := node.Get(&pb.Request{Group: "scores", Key: key}, &pb.Response{}); err == nil {
+			t.Fatalf("node is down, but get succeeded")
+		}
+	}
+	if pool.Healthy(server.URL) {
+		t.Fatalf("node should be unhealthy after continuous failures")
+	}
+	if _, ok := pool.PickNode(key); ok {
+		t.Fatalf("unhealthy node should be skipped")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	pool.StartHealthCheck(10 * time.Millisecond)
+	defer pool.StopHealthCheck()
+	deadline := time.Now().Add(time.Second)
+	for !pool.Healthy(server.URL) {
+		if time.Now().After(deadline) {
+			t.Fatalf("node should recover after health check")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := pool.PickNode(key); !ok {
+		t.Fatalf("recovered node should be picked again")
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	newEchoGroup("pool-metrics")
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool { return true })
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPool(self)
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := pool.PickNode(key)
+	if err := node.Get(&pb.Request{Group: "pool-metrics", Key: key}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	pool.Collect(metrics.NewWriter(&buf))
+	for _, line := range []string{
+		"jwcache_ring_members 2",
+		`jwcache_peer_up{peer="` + self + `"} 1`,
+		`jwcache_peer_up{peer="` + server.URL + `"} 1`,
+		`jwcache_peer_request_duration_seconds_count{peer="` + server.URL + `"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("metrics should contain %q:\n%s", line, buf.String())
+		}
+	}
+}
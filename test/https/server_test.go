@@ -0,0 +1,178 @@
+package https
+
+import (
+	"github.com/golang/protobuf/proto"
+	"io"
+	"jw-cache/src/cache"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/https"
+	"jw-cache/src/nodes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMount(t *testing.T) {
+	newEchoGroup("mount")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	pool := https.NewHTTPPoolOpts(server.URL, &https.HTTPPoolOptions{BasePath: "/internal/cache"})
+	pool.Mount(mux)
+	if pool.BasePath() != "/internal/cache/" {
+		t.Fatalf("unexpected base path %s", pool.BasePath())
+	}
+
+	self := "http://localhost:1"
+	client := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{BasePath: "/internal/cache/"})
+	client.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := client.PickNode(key)
+	res := &pb.Response{}
+	if err := node.Get(&pb.Request{Group: "mount", Key: key}, res); err != nil || string(res.Value) != "value-"+key {
+		t.Fatalf("get from mounted pool failed: %v", err)
+	}
+	if res, err := http.Get(server.URL + "/api"); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("other handlers on the mux should still work")
+	}
+}
+
+func TestServeUnexpectedPath(t *testing.T) {
+	pool := https.NewHTTPPool("http://localhost:1")
+	testCases := map[string]int{
+		"/other/path":            http.StatusNotFound,
+		"/_jw_cache/nogroup":     http.StatusBadRequest,
+		"/_jw_cache/unknown/key": http.StatusNotFound,
+		"/_jw_cache/_health":     http.StatusOK,
+	}
+	for path, code := range testCases {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != code {
+			t.Errorf("%s should return %d, but %d got", path, code, w.Code)
+		}
+	}
+}
+
+func TestPeerWrite(t *testing.T) {
+	group := newEchoGroup("write")
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool { return true })
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPoolOpts(self, &https.HTTPPoolOptions{SharedSecret: []byte("secret")})
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := pool.PickNode(key)
+	writer, ok := node.(nodes.NodeWriter)
+	if !ok {
+		t.Fatalf("http node should support writes")
+	}
+	if err := writer.Set("write", key, []byte("written"), time.Minute); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if view, err := group.GetLocal(key); err != nil || view.String() != "written" || view.Expire().IsZero() {
+		t.Fatalf("value should be written to the owner")
+	}
+	if err := writer.Delete("write", key); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if view, _ := group.GetLocal(key); view.String() != "value-"+key {
+		t.Fatalf("value should be deleted from the owner")
+	}
+}
+
+// TestPeerWriteTooLarge 超过 32MB 的值返回 413，开启签名时在校验签名之前限制读取的大小
+func TestPeerWriteTooLarge(t *testing.T) {
+	newEchoGroup("write-large")
+	large := strings.Repeat("v", 32<<20+1)
+	const target = "/_jw_cache/write-large/key"
+	w := httptest.NewRecorder()
+	https.NewHTTPPool("http://localhost:1").ServeHTTP(w, httptest.NewRequest(http.MethodPut, target, strings.NewReader(large)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large value should return 413, but %d got", w.Code)
+	}
+	w = httptest.NewRecorder()
+	signed := https.NewHTTPPoolOpts("http://localhost:1", &https.HTTPPoolOptions{SharedSecret: []byte("secret")})
+	signed.ServeHTTP(w, signedRequest(t, http.MethodPut, target, large))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large signed value should return 413, but %d got", w.Code)
+	}
+}
+
+func TestServeHedged(t *testing.T) {
+	group := newEchoGroup("hedged-serve")
+	pool := https.NewHTTPPool("http://localhost:1")
+	req := httptest.NewRequest(http.MethodGet, "/_jw_cache/hedged-serve/key", nil)
+	req.Header.Set("X-JWCache-Hedged", "1")
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "value-key") {
+		t.Fatalf("hedged request failed: %d %s", w.Code, w.Body.String())
+	}
+	// 收到对冲请求的节点不是所有者，加载的值不会放入缓存
+	if stats := group.Stats(); stats.Items != 0 || stats.LocalLoads != 1 {
+		t.Fatalf("hedged load should not fill the cache, got %+v", stats)
+	}
+}
+
+func TestCompressedWire(t *testing.T) {
+	large := strings.Repeat("compressible ", 1000)
+	cache.NewGroupOpts("wire", 1<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(large), nil
+	}), &cache.GroupOptions{Compression: cache.CompressionFlate})
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool { return true })
+	defer server.Close()
+
+	self := "http://localhost:1"
+	pool := https.NewHTTPPool(self)
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := pool.PickNode(key)
+	res := &pb.Response{}
+	if err := node.Get(&pb.Request{Group: "wire", Key: key}, res); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Encoding(res.Encoding) != cache.EncodingFlate || len(res.Value) >= len(large) {
+		t.Fatalf("value should be sent compressed, encoding %d, len %d", res.Encoding, len(res.Value))
+	}
+}
+
+func TestDecodePeerResponse(t *testing.T) {
+	large := strings.Repeat("compressible ", 1000)
+	cache.NewGroupOpts("wire-decode", 1<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(large), nil
+	}), &cache.GroupOptions{Compression: cache.CompressionGzip})
+	server := httptest.NewServer(https.NewHTTPPool("http://localhost:1"))
+	defer server.Close()
+
+	// 与 jwcachectl -peer 相同，直接通过节点之间的协议读取并解码
+	res, err := http.Get(server.URL + "/_jw_cache/wire-decode/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response %d, %v", res.StatusCode, err)
+	}
+	out := &pb.Response{}
+	if err := proto.Unmarshal(body, out); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Encoding(out.Encoding) != cache.EncodingGzip {
+		t.Fatalf("value should be sent compressed, encoding %d", out.Encoding)
+	}
+	value, err := cache.Decode(cache.Encoding(out.Encoding), out.Value)
+	if err != nil || string(value) != large {
+		t.Fatalf("unexpected decoded value of %d bytes, %v", len(value), err)
+	}
+	if _, err := cache.Decode(cache.Encoding(9), out.Value); err == nil {
+		t.Fatalf("unknown encoding should fail")
+	}
+}
@@ -0,0 +1,73 @@
+package https
+
+import (
+	"context"
+	pb "jw-cache/src/cachepb"
+	"jw-cache/src/https"
+	"jw-cache/src/nodes"
+	"jw-cache/src/trace"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCrossNodeTrace(t *testing.T) {
+	var mu sync.Mutex
+	var spans []trace.SpanData
+	tracer := trace.NewW3CTracer(func(data trace.SpanData) {
+		mu.Lock()
+		defer mu.Unlock()
+		spans = append(spans, data)
+	})
+	trace.SetTracer(tracer)
+	defer trace.SetTracer(nil)
+
+	newEchoGroup("traced")
+	server := startNode(func(w http.ResponseWriter, r *http.Request) bool { return true })
+	defer server.Close()
+	self := "http://localhost:1"
+	pool := https.NewHTTPPool(self)
+	pool.Set(self, server.URL)
+	key := keyOwnedBy(t, server.URL, self, server.URL)
+	node, _ := pool.PickNode(key)
+	getter, ok := node.(nodes.ContextNodeGetter)
+	if !ok {
+		t.Fatalf("http node should accept a context")
+	}
+
+	ctx, root := trace.Start(context.Background(), "client")
+	res := &pb.Response{}
+	if err := getter.GetContext(ctx, &pb.Request{Group: "traced", Key: key}, res); err != nil || string(res.Value) != "value-"+key {
+		t.Fatalf("get failed: %v", err)
+	}
+	root.End()
+
+	// 响应写完之后服务端的 span 才结束，需要等待服务端导出
+	var client, serve *trace.SpanData
+	for deadline := time.Now().Add(time.Second); serve == nil && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		for i := range spans {
+			switch spans[i].Name {
+			case "client":
+				client = &spans[i]
+			case "jwcache.peer.serve":
+				serve = &spans[i]
+			}
+		}
+		mu.Unlock()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range spans {
+		if s.Context.TraceID != client.Context.TraceID {
+			t.Fatalf("%s should belong to the client trace", s.Name)
+		}
+	}
+	if serve == nil || !serve.Remote || serve.Parent.SpanID != client.Context.SpanID {
+		t.Fatalf("peer span should be a remote child of the client span")
+	}
+	if len(spans) < 5 {
+		t.Fatalf("remote stages should be traced, got %d spans", len(spans))
+	}
+}
@@ -0,0 +1,69 @@
+package log
+
+import (
+	"bytes"
+	"jw-cache/src/pgk/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := log.New(log.Options{Level: "info", Output: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	log.With(logger, "group", "scores").Warn("get from node failed", "key", "Tom", "node", "http://localhost:8002", "err", "peer is down", 42)
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("debug logs should be dropped at info level")
+	}
+	want := `[warning] get from node failed !BADKEY=42 err="peer is down" group=scores key=Tom node=http://localhost:8002` + "\n"
+	if !strings.HasSuffix(out, want) {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestNewWithDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested")
+	logger, err := log.New(log.Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello", "key", "Tom")
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, time.Now().Format("20060102")+".log"))
+	if err != nil || !strings.Contains(string(b), "hello key=Tom") {
+		t.Fatalf("log file should be written, got %q %v", b, err)
+	}
+
+	if _, err := log.New(log.Options{Level: "verbose"}); err == nil {
+		t.Fatalf("unknown level should be rejected")
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	defer log.SetDefault(nil)
+	saved := log.Default()
+	var buf bytes.Buffer
+	logger, _ := log.New(log.Options{Level: "debug", Output: &buf})
+	log.SetDefault(logger)
+	saved.Debug("through saved")
+	log.Info("through package")
+	if !strings.Contains(buf.String(), "through saved") || !strings.Contains(buf.String(), "through package") {
+		t.Fatalf("default logger should follow SetDefault, got %q", buf.String())
+	}
+
+	log.SetDefault(nil)
+	log.Error("dropped")
+	if strings.Contains(buf.String(), "dropped") {
+		t.Fatalf("nil should discard logs")
+	}
+}
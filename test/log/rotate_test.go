@@ -0,0 +1,116 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"jw-cache/src/pgk/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listDir 返回目录中按名称排列的文件
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	logger, err := log.New(log.Options{Dir: dir, FileFormat: "app", MaxSize: 200, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 60)
+	for i := 0; i < 20; i++ {
+		logger.Info(line, "i", i)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := listDir(t, dir)
+	if len(names) != 3 || names[2] != "app.log" {
+		t.Fatalf("should keep the active file and 2 backups, got %v", names)
+	}
+	for _, name := range names[:2] {
+		if !strings.HasSuffix(name, ".log.gz") {
+			t.Fatalf("backups should be compressed, got %v", names)
+		}
+		f, _ := os.Open(filepath.Join(dir, name))
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("bad gzip file %s: %v", name, err)
+		}
+		b, _ := io.ReadAll(zr)
+		f.Close()
+		if !bytes.Contains(b, []byte(line)) {
+			t.Fatalf("backup %s should contain logs", name)
+		}
+	}
+	info, _ := os.Stat(filepath.Join(dir, "app.log"))
+	if info.Size() > 200 {
+		t.Fatalf("active file should not exceed max size, got %d", info.Size())
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	dir := t.TempDir()
+	// 每毫秒一个文件，模拟日期变化
+	logger, err := log.New(log.Options{Dir: dir, FileFormat: "150405.000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("first")
+	time.Sleep(5 * time.Millisecond)
+	logger.Info("second")
+	logger.Close()
+	if names := listDir(t, dir); len(names) < 2 {
+		t.Fatalf("logs should be written to a new file when the time changes, got %v", names)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := log.New(log.Options{Level: "warn", Output: &buf})
+	if logger.Level() != "warn" {
+		t.Fatalf("unexpected level %s", logger.Level())
+	}
+	logger.Info("before")
+	if err := logger.SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("after")
+	if strings.Contains(buf.String(), "before") || !strings.Contains(buf.String(), "after") {
+		t.Fatalf("level should change at runtime, got %q", buf.String())
+	}
+	if err := logger.SetLevel("loud"); err == nil || logger.Level() != "debug" {
+		t.Fatalf("unknown level should be rejected")
+	}
+
+	defer log.SetDefault(nil)
+	log.SetDefault(log.Discard)
+	if err := log.SetLevel("debug"); err == nil {
+		t.Fatalf("loggers without levels should not be changed")
+	}
+	log.SetDefault(logger)
+	if err := log.SetLevel("error"); err != nil {
+		t.Fatal(err)
+	}
+	if level, ok := log.GetLevel(); !ok || level != "error" {
+		t.Fatalf("unexpected default level %s", level)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build go1.21
+
+package log
+
+import (
+	"bytes"
+	"jw-cache/src/pgk/log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogCompatible(t *testing.T) {
+	var buf bytes.Buffer
+	var logger log.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	log.With(logger, "group", "scores").Info("hit", "key", "Tom")
+	if !strings.Contains(buf.String(), "msg=hit group=scores key=Tom") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
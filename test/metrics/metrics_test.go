@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"jw-cache/src/cache"
+	"jw-cache/src/metrics"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := metrics.NewHistogram([]float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	w.Family("latency_seconds", "histogram", "Latency.")
+	w.Histogram("latency_seconds", h, "peer", `a"b`)
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{peer="a\"b",le="0.1"} 1
+latency_seconds_bucket{peer="a\"b",le="1"} 2
+latency_seconds_bucket{peer="a\"b",le="+Inf"} 3
+latency_seconds_sum{peer="a\"b"} 3.55
+latency_seconds_count{peer="a\"b"} 3
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected exposition:\n%s", buf.String())
+	}
+}
+
+func TestGroupsHandler(t *testing.T) {
+	group := cache.NewGroup("metrics", 2<<10, cache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	group.Get("key")
+	group.Get("key")
+
+	server := httptest.NewServer(metrics.Handler(metrics.Groups))
+	defer server.Close()
+	res, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %s", res.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`jwcache_group_gets_total{group="metrics"} 2`,
+		`jwcache_group_hits_total{group="metrics"} 1`,
+		`jwcache_group_misses_total{group="metrics"} 1`,
+		`jwcache_group_max_bytes{group="metrics"} 2048`,
+		`jwcache_group_evictions_total{group="metrics",reason="capacity"} 0`,
+		"# TYPE jwcache_group_inflight_loads gauge",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("metrics should contain %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package setting
+
+import (
+	"context"
+	"jw-cache/src/pgk/setting"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old := setting.Default()
+	old.Server.Peers = []string{"http://localhost:8001", "http://localhost:8002"}
+	old.Groups = []setting.GroupConfig{setting.DefaultGroup("scores"), setting.DefaultGroup("users")}
+
+	if changes := setting.Diff(old, old); !changes.Empty() {
+		t.Fatalf("same conf should have no changes, got %+v", changes)
+	}
+
+	new := setting.Default()
+	new.Server.Addr = "http://localhost:8003"
+	new.Server.Peers = []string{"http://localhost:8003", "http://localhost:8001"}
+	new.Log.Level = "debug"
+	scores := setting.DefaultGroup("scores")
+	scores.CacheBytes = 1 << 20
+	scores.Evicter = "fifo"
+	new.Groups = []setting.GroupConfig{setting.DefaultGroup("ranks"), scores}
+
+	changes := setting.Diff(old, new)
+	want := setting.Changes{
+		AddedPeers:    []string{"http://localhost:8003"},
+		RemovedPeers:  []string{"http://localhost:8002"},
+		AddedGroups:   []setting.GroupConfig{setting.DefaultGroup("ranks")},
+		ResizedGroups: []setting.GroupConfig{scores},
+		LogLevel:      "debug",
+		Restart:       []string{"server.addr", "groups.scores.evicter", "groups.users"},
+	}
+	if !reflect.DeepEqual(changes, want) || !changes.PeersChanged() {
+		t.Fatalf("got %+v, want %+v", changes, want)
+	}
+
+	// 只调整节点的顺序不会改变哈希环
+	reordered := *old
+	reordered.Server.Peers = []string{"http://localhost:8002", "http://localhost:8001"}
+	if changes := setting.Diff(old, &reordered); changes.PeersChanged() {
+		t.Fatalf("reordering peers should not change the ring")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.ini")
+	if err := os.WriteFile(path, []byte("[log]\nlevel = info\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 8)
+	go setting.Watch(ctx, path, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	// 只修改时间不会触发重新加载
+	time.Sleep(30 * time.Millisecond)
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Fatalf("touching the file should not trigger a reload")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 先写临时文件再重命名，与编辑器保存文件的方式相同
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte("[log]\nlevel = debug\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("changing the file should trigger a reload")
+	}
+}
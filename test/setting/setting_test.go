@@ -0,0 +1,246 @@
+package setting
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"jw-cache/src/pgk/setting"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const iniConf = `
+[server]
+addr = http://localhost:8001
+peers = http://localhost:8001, http://localhost:8002
+api_addr = :9999
+
+[transport]
+timeout = 1s
+retries = 2
+
+[log]
+level = debug
+
+[group.scores]
+cache_bytes = 2048
+evicter = fifo
+ttl = 1m
+`
+
+const yamlConf = `
+server:
+  addr: http://localhost:8001
+  peers: [http://localhost:8001, http://localhost:8002]
+  api_addr: ":9999"
+transport:
+  timeout: 1s
+  retries: 2
+log:
+  level: debug
+groups:
+  - name: scores
+    cache_bytes: 2048
+    evicter: fifo
+    ttl: 1m
+`
+
+const jsonConf = `{
+  "server": {
+    "addr": "http://localhost:8001",
+    "peers": ["http://localhost:8001", "http://localhost:8002"],
+    "api_addr": ":9999"
+  },
+  "transport": {"timeout": "1s", "retries": 2},
+  "log": {"level": "debug"},
+  "groups": {"scores": {"cache_bytes": 2048, "evicter": "fifo", "ttl": "1m"}}
+}`
+
+func TestParseFormats(t *testing.T) {
+	want := setting.Default()
+	want.Server.Peers = []string{"http://localhost:8001", "http://localhost:8002"}
+	want.Server.APIAddr = ":9999"
+	want.Transport.Timeout = time.Second
+	want.Transport.Retries = 2
+	want.Log.Level = "debug"
+	scores := setting.DefaultGroup("scores")
+	scores.CacheBytes = 2048
+	scores.Evicter = "fifo"
+	scores.TTL = time.Minute
+	want.Groups = []setting.GroupConfig{scores}
+
+	for format, data := range map[string]string{
+		setting.FormatINI:  iniConf,
+		setting.FormatYAML: yamlConf,
+		setting.FormatJSON: jsonConf,
+	} {
+		c, err := setting.Parse([]byte(data), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Fatalf("%s: got %+v, want %+v", format, c, want)
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	data := `
+[server]
+adr = http://localhost:8001
+health_interval = 2
+
+[log]
+max_size = big
+
+[cluster]
+size = 3
+`
+	_, err := setting.Parse([]byte(data), setting.FormatINI)
+	if err == nil {
+		t.Fatalf("expect errors")
+	}
+	for _, want := range []string{
+		`unknown section "cluster"`,
+		"unknown key server.adr",
+		`server.health_interval: bad duration "2"`,
+		`log.max_size: bad integer "big"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("errors %q should contain %q", err, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	c := setting.Default()
+	c.Groups = []setting.GroupConfig{setting.DefaultGroup("user-scores")}
+	err := c.ApplyEnv([]string{
+		"PATH=/bin",
+		"JWCACHE_CONF=conf/conf.yaml",
+		"JWCACHE_SERVER_ADDR=http://localhost:8003",
+		"JWCACHE_SERVER_PEERS=http://localhost:8003,http://localhost:8004",
+		"JWCACHE_LOG_COMPRESS=true",
+		"JWCACHE_TRANSPORT_HEDGE_DELAY=10ms",
+		"JWCACHE_GROUP_USER_SCORES_COMPRESS_THRESHOLD=512",
+		"JWCACHE_GROUP_USER_SCORES_COMPRESSION=gzip",
+		"JWCACHE_GROUP_RANKS_CACHE_BYTES=1024",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Addr != "http://localhost:8003" || len(c.Server.Peers) != 2 || !c.Log.Compress || c.Transport.HedgeDelay != 10*time.Millisecond {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	g, ok := c.Group("user-scores")
+	if !ok || g.CompressThreshold != 512 || g.Compression != "gzip" {
+		t.Fatalf("unexpected group %+v", g)
+	}
+	g, ok = c.Group("ranks")
+	if !ok || g.CacheBytes != 1024 {
+		t.Fatalf("group ranks should be created from env, got %+v", c.Groups)
+	}
+
+	err = c.ApplyEnv([]string{"JWCACHE_SERVER_PORT=8001", "JWCACHE_LOG_MAX_BACKUPS=many"})
+	if err == nil || !strings.Contains(err.Error(), "unknown environment variable JWCACHE_SERVER_PORT") ||
+		!strings.Contains(err.Error(), "JWCACHE_LOG_MAX_BACKUPS") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := setting.Default()
+	c.Server.Addr = "localhost:8001"
+	c.Server.Peers = []string{"http://localhost:8002", "http://localhost:8002"}
+	c.Transport.Retries = -1
+	c.Transport.HandoffTimeout = -time.Second
+	c.Transport.CertFile = "node.pem"
+	c.Log.Level = "verbose"
+	c.Snapshot.Path = "data/jwcache.snap"
+	c.AOF.Dir = "data"
+	c.AOF.Fsync = "sometimes"
+	bad := setting.DefaultGroup("scores")
+	bad.Evicter = "random"
+	bad.TTL = -time.Second
+	bad.L2Dir = "data/l2"
+	noDir := setting.DefaultGroup("users")
+	noDir.L2Bytes = 1 << 30
+	noDir.Evicter = "arena"
+	noDir.CacheBytes = 0
+	shared := setting.DefaultGroup("items")
+	shared.L2Dir = "data/l2/"
+	c.Groups = []setting.GroupConfig{bad, setting.DefaultGroup("scores"), noDir, shared}
+
+	err := c.Validate()
+	var errs setting.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expect setting.Errors, got %v", err)
+	}
+	for _, want := range []string{
+		"server.addr: bad node addr",
+		"server.peers: duplicate node",
+		"transport.retries",
+		"transport.handoff_timeout",
+		"cert_file and key_file",
+		"log.level",
+		"groups.scores: duplicate group",
+		`groups.scores.evicter: unknown policy "random"`,
+		"groups.scores.ttl",
+		`aof.fsync: unknown policy "sometimes"`,
+		"snapshot.path and aof.dir",
+		"groups.users.l2_bytes: requires l2_dir",
+		"groups.users.cache_bytes: evicter arena requires a positive cache size",
+		"groups.items.l2_dir: already used by group scores",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("errors %q should contain %q", err, want)
+		}
+	}
+	if len(errs) < 10 {
+		t.Fatalf("expect every problem to be reported, got %d", len(errs))
+	}
+
+	if err := setting.Default().Validate(); err == nil || !strings.Contains(err.Error(), "no group configured") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// TestErrorsIsAs 在 Go 1.19 中 errors.Is 和 errors.As 也能找到 Errors 中的每个错误
+func TestErrorsIsAs(t *testing.T) {
+	errs := setting.Errors{errors.New("log.level: bad level"), fmt.Errorf("conf.yml: %w", fs.ErrNotExist)}
+	if !errors.Is(errs, fs.ErrNotExist) || errors.Is(errs, fs.ErrPermission) {
+		t.Fatalf("errors.Is should match each error in %v", errs)
+	}
+	var pathErr *fs.PathError
+	if errors.As(errs, &pathErr) {
+		t.Fatalf("errors.As should not match")
+	}
+	errs = append(errs, &fs.PathError{Op: "open", Path: "conf.yml", Err: fs.ErrNotExist})
+	if !errors.As(errs, &pathErr) || pathErr.Path != "conf.yml" {
+		t.Fatalf("errors.As should find the path error, got %v", pathErr)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.yml")
+	if err := os.WriteFile(path, []byte(yamlConf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := setting.Load(path, []string{"JWCACHE_LOG_LEVEL=warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Log.Level != "warn" || len(c.Groups) != 1 {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if _, err := setting.Load(filepath.Join(t.TempDir(), "conf.toml"), nil); err == nil {
+		t.Fatalf("expect error for unknown format")
+	}
+}
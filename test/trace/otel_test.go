@@ -0,0 +1,80 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"jw-cache/src/trace"
+	traceotel "jw-cache/src/trace/otel"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// fakeSpan 记录 OpenTelemetry span 收到的调用，其余方法使用不记录数据的 span
+type fakeSpan struct {
+	oteltrace.Span
+	name   string
+	attrs  []attribute.KeyValue
+	status codes.Code
+	err    error
+	ended  bool
+}
+
+func (s *fakeSpan) SetAttributes(kv ...attribute.KeyValue) { s.attrs = append(s.attrs, kv...) }
+func (s *fakeSpan) RecordError(err error, _ ...oteltrace.EventOption) {
+	s.err = err
+}
+func (s *fakeSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *fakeSpan) IsRecording() bool                   { return true }
+func (s *fakeSpan) End(...oteltrace.SpanEndOption)      { s.ended = true }
+
+// fakeTracer 创建 fakeSpan，span 的标识沿用 ctx 中的父 span
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string, _ ...oteltrace.SpanStartOption) (context.Context, oteltrace.Span) {
+	parent := oteltrace.SpanFromContext(ctx)
+	s := &fakeSpan{Span: parent, name: name}
+	t.spans = append(t.spans, s)
+	return oteltrace.ContextWithSpan(ctx, s), s
+}
+
+func TestOTelTracer(t *testing.T) {
+	otel := &fakeTracer{}
+	tracer := traceotel.NewTracer(otel, propagation.TraceContext{})
+
+	// 其他节点通过 W3CTracer 发送的追踪上下文可以被 OpenTelemetry 读取，反之亦然
+	w3c := trace.NewW3CTracer(nil)
+	ctx, root := w3c.Start(context.Background(), "root")
+	root.End()
+	want, _ := trace.SpanContextFromContext(ctx)
+	header := http.Header{}
+	w3c.Inject(ctx, header)
+
+	ctx, span := tracer.Start(tracer.Extract(context.Background(), header), "jwcache.Group.Get")
+	if !span.IsRecording() {
+		t.Fatalf("span should be recording")
+	}
+	span.SetAttributes(trace.String("group", "scores"))
+	span.RecordError(errors.New("boom"))
+	span.End()
+	s := otel.spans[0]
+	if s.name != "jwcache.Group.Get" || !s.ended || s.err == nil || s.status != codes.Error {
+		t.Fatalf("span calls should be forwarded, got %+v", s)
+	}
+	if len(s.attrs) != 1 || s.attrs[0] != attribute.String("group", "scores") {
+		t.Fatalf("unexpected attributes %v", s.attrs)
+	}
+
+	header = http.Header{}
+	tracer.Inject(ctx, header)
+	got, err := trace.ParseTraceparent(header.Get(trace.TraceparentHeader))
+	if err != nil || got.TraceID != want.TraceID {
+		t.Fatalf("trace id should be propagated, got %v %v", header, err)
+	}
+}
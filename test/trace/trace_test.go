@@ -0,0 +1,86 @@
+package trace
+
+import (
+	"context"
+	"jw-cache/src/trace"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestNoopTracer(t *testing.T) {
+	ctx, span := trace.Start(context.Background(), "noop")
+	if span.IsRecording() {
+		t.Fatalf("default tracer should not record")
+	}
+	span.End()
+	header := http.Header{}
+	trace.Inject(ctx, header)
+	if len(header) != 0 {
+		t.Fatalf("default tracer should not inject headers, got %v", header)
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	const s = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := trace.ParseTraceparent(s)
+	if err != nil || !sc.Sampled || sc.Traceparent() != s {
+		t.Fatalf("traceparent should round trip, got %v %v", sc.Traceparent(), err)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		if _, err := trace.ParseTraceparent(bad); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
+
+func TestW3CTracer(t *testing.T) {
+	var mu sync.Mutex
+	var spans []trace.SpanData
+	tracer := trace.NewW3CTracer(func(data trace.SpanData) {
+		mu.Lock()
+		defer mu.Unlock()
+		spans = append(spans, data)
+	})
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttributes(trace.String("k", "v"))
+	child.End()
+	child.End()
+
+	// 模拟跨节点传递
+	header := http.Header{}
+	tracer.Inject(ctx, header)
+	remoteCtx := tracer.Extract(context.Background(), header)
+	_, remote := tracer.Start(remoteCtx, "remote")
+	remote.End()
+	root.End()
+
+	if len(spans) != 3 {
+		t.Fatalf("spans should be exported once when ended, got %d", len(spans))
+	}
+	c, r, rt := spans[0], spans[1], spans[2]
+	if rt.Parent.IsValid() || rt.Remote {
+		t.Fatalf("root span should have no parent")
+	}
+	if c.Context.TraceID != rt.Context.TraceID || c.Parent != rt.Context || c.Remote {
+		t.Fatalf("child should be a local child of root")
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0] != trace.String("k", "v") {
+		t.Fatalf("unexpected attributes %v", c.Attributes)
+	}
+	if r.Context.TraceID != rt.Context.TraceID || r.Parent.SpanID != rt.Context.SpanID || !r.Remote {
+		t.Fatalf("remote span should continue the trace of root")
+	}
+	if r.Context.SpanID == rt.Context.SpanID || r.Context.SpanID == c.Context.SpanID {
+		t.Fatalf("span ids should be unique")
+	}
+}